@@ -1,169 +1,651 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
 	"TaskManager5/Usecases"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type TaskController struct {
-	taskService *Usecases.TaskService
-	userService *Usecases.UserService
-	secretKey   string
+	taskService Usecases.TaskUsecase
+	userService Usecases.UserUsecase
 }
 
-func NewTaskController(taskService *Usecases.TaskService, userService *Usecases.UserService, secretKey string) *TaskController {
+func NewTaskController(taskService Usecases.TaskUsecase, userService Usecases.UserUsecase) *TaskController {
 	return &TaskController{
 		taskService: taskService,
 		userService: userService,
-		secretKey:   secretKey,
 	}
 }
 
+// principal returns the caller set by AuthMiddleware, aborting with
+// Domain.ErrUnauthenticated when there is none.
+func principal(c *gin.Context) (Domain.Principal, bool) {
+	p, ok := Infrastructure.PrincipalFrom(c)
+	if !ok {
+		c.Error(Domain.ErrUnauthenticated)
+		c.Abort()
+		return Domain.Principal{}, false
+	}
+	return p, true
+}
+
 func (tc *TaskController) Register(c *gin.Context) {
-	var user Domain.User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req RegisterRequest
+	if !bindJSON(c, &req) {
 		return
 	}
-	createdUser, err := tc.userService.RegisterUser(user)
+	createdUser, err := tc.userService.RegisterUser(c.Request.Context(), Domain.User{Username: req.Username, Password: req.Password})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusCreated, createdUser)
+	c.JSON(http.StatusCreated, newUserProfile(*createdUser))
 }
 
 func (tc *TaskController) Login(c *gin.Context) {
-	var credentials struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	var req LoginRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	tokens, challenge, err := tc.userService.AuthenticateUser(c.Request.Context(), req.Username, req.Password, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// LoginMFA completes a login that answered with an MFA challenge.
+func (tc *TaskController) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	tokens, err := tc.userService.CompleteMFALogin(c.Request.Context(), req.MFAToken, req.Code, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (tc *TaskController) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	tokens, err := tc.userService.RefreshTokens(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (tc *TaskController) Logout(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	if err := tc.userService.Logout(c.Request.Context(), caller); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully."})
+}
+
+func (tc *TaskController) GetProfile(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	user, err := tc.userService.GetProfile(c.Request.Context(), caller)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newUserProfile(*user))
+}
+
+func (tc *TaskController) UpdateProfile(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req UpdateProfileRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := tc.userService.UpdateProfile(c.Request.Context(), caller, req.Username)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newUserProfile(*user))
+}
+
+// ChangePassword responds with the tokens of a new session, since every
+// existing session of the caller is ended.
+func (tc *TaskController) ChangePassword(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req ChangePasswordRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	tokens, err := tc.userService.ChangePassword(c.Request.Context(), caller, req.CurrentPassword, req.NewPassword, c.ClientIP())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (tc *TaskController) DeleteAccount(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	if err := tc.userService.DeleteAccount(c.Request.Context(), caller); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Account has been deleted."})
+}
+
+// BeginTOTPEnrollment responds with a new secret and its otpauth URI for the
+// caller's authenticator app. MFA is only on once a code confirms it.
+func (tc *TaskController) BeginTOTPEnrollment(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	enrollment, err := tc.userService.BeginTOTPEnrollment(c.Request.Context(), caller)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (tc *TaskController) ConfirmTOTPEnrollment(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	codes, err := tc.userService.ConfirmTOTPEnrollment(c.Request.Context(), caller, req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, RecoveryCodes{Codes: codes})
+}
+
+func (tc *TaskController) RegenerateRecoveryCodes(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
 	}
-	if err := c.ShouldBindJSON(&credentials); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req MFACodeRequest
+	if !bindJSON(c, &req) {
 		return
 	}
-	token, err := tc.userService.AuthenticateUser(credentials.Username, credentials.Password)
+	codes, err := tc.userService.RegenerateRecoveryCodes(c.Request.Context(), caller, req.Code)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, RecoveryCodes{Codes: codes})
+}
+
+func (tc *TaskController) DisableMFA(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req MFACodeRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := tc.userService.DisableMFA(c.Request.Context(), caller, req.Code); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Multi-factor authentication has been disabled."})
+}
+
+// CreateAPIKey responds with the new key, which is the only time it is shown.
+func (tc *TaskController) CreateAPIKey(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req CreateAPIKeyRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	key, secret, err := tc.userService.CreateAPIKey(c.Request.Context(), caller, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, CreatedAPIKey{APIKeyView: newAPIKeyView(*key), Key: secret})
+}
+
+func (tc *TaskController) ListAPIKeys(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	keys, err := tc.userService.ListAPIKeys(c.Request.Context(), caller)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	views := make([]APIKeyView, len(keys))
+	for i, key := range keys {
+		views[i] = newAPIKeyView(key)
+	}
+	c.JSON(http.StatusOK, views)
+}
+
+func (tc *TaskController) RevokeAPIKey(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	if err := tc.userService.RevokeAPIKey(c.Request.Context(), caller, c.Param("id")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "API key has been revoked."})
 }
 
 func (tc *TaskController) GetTasks(c *gin.Context) {
-	tasks, err := tc.taskService.GetTasks()
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	filter, err := parseTaskFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(fmt.Errorf("%w: %v", Domain.ErrInvalidRequest, err))
 		return
 	}
-	c.JSON(http.StatusOK, tasks)
+	page, err := tc.taskService.GetTasks(c.Request.Context(), caller, filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// parseTaskFilter reads the listing query parameters:
+// status, owner, q, due_after, due_before, sort (prefix "-" for descending),
+// limit and cursor.
+func parseTaskFilter(c *gin.Context) (Domain.TaskFilter, error) {
+	filter := Domain.TaskFilter{
+		Search: c.Query("q"),
+		Cursor: c.Query("cursor"),
+	}
+
+	if status := c.Query("status"); status != "" {
+		parsed, err := Domain.ParseTaskStatus(status)
+		if err != nil {
+			return filter, err
+		}
+		filter.Status = parsed
+	}
+
+	if owner := c.Query("owner"); owner != "" {
+		if _, err := primitive.ObjectIDFromHex(owner); err != nil {
+			return filter, fmt.Errorf("invalid owner %q", owner)
+		}
+		filter.UserID = owner
+	}
+
+	for param, target := range map[string]**time.Time{
+		"due_after":  &filter.DueAfter,
+		"due_before": &filter.DueBefore,
+	} {
+		if value := c.Query(param); value != "" {
+			t, err := parseQueryTime(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q", param, value)
+			}
+			*target = &t
+		}
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		field := strings.TrimPrefix(sort, "-")
+		if !Domain.TaskSortFields[field] {
+			return filter, fmt.Errorf("cannot sort by %q", field)
+		}
+		filter.SortBy = field
+		filter.SortDesc = strings.HasPrefix(sort, "-")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > Domain.MaxTaskPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", Domain.MaxTaskPageSize)
+		}
+		filter.Limit = n
+	}
+
+	return filter, nil
+}
+
+// parseQueryTime accepts RFC 3339 timestamps or plain dates.
+func parseQueryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
 }
 
 func (tc *TaskController) GetTask(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
 	id := c.Param("id")
-	task, err := tc.taskService.GetTask(id)
+	task, err := tc.taskService.GetTask(c.Request.Context(), caller, id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+	etag := taskETag(task)
+	c.Header("ETag", etag)
+	if ifNoneMatch(c, etag) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 	c.JSON(http.StatusOK, task)
 }
 
 func (tc *TaskController) CreateTask(c *gin.Context) {
-    var task Domain.Task
-    if err := c.ShouldBindJSON(&task); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-        return
-    }
-
-    // Get the claims from the context
-    claims, exists := c.Get("user")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-        return
-    }
-
-    // Assert the claims as jwt.MapClaims
-    userClaims, ok := claims.(jwt.MapClaims)
-    if !ok {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user claims"})
-        return
-    }
-
-    // Extract the user ID from the claims
-    userID, ok := userClaims["user_id"].(string)
-    if !ok {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract user ID"})
-        return
-    }
-
-    // Convert userID to ObjectID and assign to task
-    task.UserID, _ = primitive.ObjectIDFromHex(userID)
-    createdTask, err := tc.taskService.CreateTask(task)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-        return
-    }
-    c.JSON(http.StatusCreated, createdTask)
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	var req CreateTaskRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	createdTask, err := tc.taskService.CreateTask(c.Request.Context(), caller, req.toTask())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("ETag", taskETag(createdTask))
+	c.JSON(http.StatusCreated, createdTask)
 }
 
-
 func (tc *TaskController) UpdateTask(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
 	id := c.Param("id")
-	var task Domain.Task
-	if err := c.ShouldBindJSON(&task); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.Error(Domain.ErrVersionMismatch)
 		return
 	}
-	updatedTask, err := tc.taskService.UpdateTask(id, task)
+	var req UpdateTaskRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	updatedTask, err := tc.taskService.UpdateTask(c.Request.Context(), caller, id, version, req.toTask())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
+	c.Header("ETag", taskETag(updatedTask))
 	c.JSON(http.StatusOK, updatedTask)
 }
 
+// PatchTask accepts application/merge-patch+json (or plain JSON) and
+// application/json-patch+json bodies.
+func (tc *TaskController) PatchTask(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.Error(Domain.ErrVersionMismatch)
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.Error(fmt.Errorf("%w: %v", Domain.ErrInvalidRequest, err))
+		return
+	}
+	patchedTask, err := tc.taskService.PatchTask(c.Request.Context(), caller, c.Param("id"), version, c.GetHeader("Content-Type"), patch)
+	if err != nil {
+		if errors.Is(err, Domain.ErrUnsupportedPatch) {
+			c.Header("Accept-Patch", Infrastructure.MergePatchContentType+", "+Infrastructure.JSONPatchContentType)
+		}
+		c.Error(err)
+		return
+	}
+	c.Header("ETag", taskETag(patchedTask))
+	c.JSON(http.StatusOK, patchedTask)
+}
+
 func (tc *TaskController) DeleteTask(c *gin.Context) {
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
 	id := c.Param("id")
-	err := tc.taskService.DeleteTask(id)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		c.Error(Domain.ErrVersionMismatch)
+		return
+	}
+	err := tc.taskService.DeleteTask(c.Request.Context(), caller, id, version)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Task has been Deleted Successfully."})
 }
 
 func (tc *TaskController) ListUsers(c *gin.Context) {
-	users, err := tc.userService.GetAllUsers()
+	users, err := tc.userService.GetAllUsers(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, newAdminUserViews(users))
 }
 
 func (tc *TaskController) GetUserByID(c *gin.Context) {
 	id := c.Param("id")
-	user, err := tc.userService.GetUserByID(id)
+	user, err := tc.userService.GetUserByID(c.Request.Context(), id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, user)
+	c.JSON(http.StatusOK, newAdminUserView(*user))
 }
 
+// GetTasksByUserID lists one user's tasks. It takes the same query parameters
+// and returns the same page as GetTasks, with the owner taken from the path.
 func (tc *TaskController) GetTasksByUserID(c *gin.Context) {
-	userID := c.Param("user_id")
-	tasks, err := tc.taskService.GetTasksByUserID(userID)
+	caller, ok := principal(c)
+	if !ok {
+		return
+	}
+	filter, err := parseTaskFilter(c)
+	if err != nil {
+		c.Error(fmt.Errorf("%w: %v", Domain.ErrInvalidRequest, err))
+		return
+	}
+	filter.UserID = c.Param("user_id")
+	page, err := tc.taskService.GetTasks(c.Request.Context(), caller, filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func (tc *TaskController) SetUserRole(c *gin.Context) {
+	var req SetRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := tc.userService.SetUserRole(c.Request.Context(), c.Param("id"), req.Role)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newAdminUserView(*user))
+}
+
+func (tc *TaskController) SetUserTeam(c *gin.Context) {
+	var req SetTeamRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	user, err := tc.userService.SetUserTeam(c.Request.Context(), c.Param("id"), req.Team)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newAdminUserView(*user))
+}
+
+func (tc *TaskController) ResetUserMFA(c *gin.Context) {
+	user, err := tc.userService.ResetMFA(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newAdminUserView(*user))
+}
+
+func (tc *TaskController) DisableUser(c *gin.Context) {
+	tc.setUserDisabled(c, true)
+}
+
+func (tc *TaskController) EnableUser(c *gin.Context) {
+	tc.setUserDisabled(c, false)
+}
+
+func (tc *TaskController) setUserDisabled(c *gin.Context, disabled bool) {
+	user, err := tc.userService.SetUserDisabled(c.Request.Context(), c.Param("id"), disabled)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, newAdminUserView(*user))
+}
+
+// DeleteUser reads the policy for the user's tasks from the "tasks" query
+// parameter (restrict, delete or reassign; restrict by default) and the
+// new owner from "reassign_to".
+func (tc *TaskController) DeleteUser(c *gin.Context) {
+	deletion := Domain.UserDeletion{Tasks: Domain.CascadeRestrict, ReassignTo: c.Query("reassign_to")}
+	if policy := c.Query("tasks"); policy != "" {
+		cascade, err := Domain.ParseTaskCascade(policy)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		deletion.Tasks = cascade
+	}
+	if err := tc.userService.DeleteUser(c.Request.Context(), c.Param("id"), deletion); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User has been deleted."})
+}
+
+func (tc *TaskController) ListLockouts(c *gin.Context) {
+	attempts, err := tc.userService.ListLoginAttempts(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// ClearLockout takes a key as listed by ListLockouts, such as "user:alice"
+// or "ip:192.0.2.1".
+func (tc *TaskController) ClearLockout(c *gin.Context) {
+	if err := tc.userService.ClearLoginAttempts(c.Request.Context(), c.Param("key")); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Lockout has been cleared."})
+}
+
+func (tc *TaskController) ListRoles(c *gin.Context) {
+	roles, err := tc.userService.ListRoles(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// SaveRole creates the role named in the path or replaces its permissions.
+func (tc *TaskController) SaveRole(c *gin.Context) {
+	var req SaveRoleRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	role, err := tc.userService.SaveRole(c.Request.Context(), Domain.Role{Name: c.Param("name"), Permissions: req.Permissions})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (tc *TaskController) SetRoleMFA(c *gin.Context) {
+	var req RoleMFARequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	role, err := tc.userService.SetRoleMFA(c.Request.Context(), c.Param("name"), *req.Required)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, role)
+}
+
+func (tc *TaskController) DeleteRole(c *gin.Context) {
+	if err := tc.userService.DeleteRole(c.Request.Context(), c.Param("name")); err != nil {
+		c.Error(err)
 		return
 	}
-	c.JSON(http.StatusOK, tasks)
+	c.JSON(http.StatusOK, gin.H{"message": "Role has been deleted."})
 }
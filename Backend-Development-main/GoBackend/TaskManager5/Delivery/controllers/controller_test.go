@@ -1,13 +1,20 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
 	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -16,34 +23,37 @@ type MockTaskService struct {
 	mock.Mock
 }
 
-func (m *MockTaskService) GetTasks() ([]Domain.Task, error) {
-	args := m.Called()
-	return args.Get(0).([]Domain.Task), args.Error(1)
+func (m *MockTaskService) GetTasks(ctx context.Context, principal Domain.Principal, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	args := m.Called(principal, filter)
+	return args.Get(0).(*Domain.TaskPage), args.Error(1)
 }
 
-func (m *MockTaskService) GetTask(id primitive.ObjectID) (*Domain.Task, error) {
-	args := m.Called(id)
-	return args.Get(0).(*Domain.Task), args.Error(1)
+func (m *MockTaskService) GetTask(ctx context.Context, principal Domain.Principal, id string) (*Domain.Task, error) {
+	args := m.Called(principal, id)
+	task, _ := args.Get(0).(*Domain.Task)
+	return task, args.Error(1)
 }
 
-func (m *MockTaskService) CreateTask(task Domain.Task) (*Domain.Task, error) {
-	args := m.Called(task)
+func (m *MockTaskService) CreateTask(ctx context.Context, principal Domain.Principal, task Domain.Task) (*Domain.Task, error) {
+	args := m.Called(principal, task)
 	return args.Get(0).(*Domain.Task), args.Error(1)
 }
 
-func (m *MockTaskService) UpdateTask(id primitive.ObjectID, updatedTask Domain.Task) (*Domain.Task, error) {
-	args := m.Called(id, updatedTask)
-	return args.Get(0).(*Domain.Task), args.Error(1)
+func (m *MockTaskService) UpdateTask(ctx context.Context, principal Domain.Principal, id string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	args := m.Called(principal, id, version, updatedTask)
+	task, _ := args.Get(0).(*Domain.Task)
+	return task, args.Error(1)
 }
 
-func (m *MockTaskService) DeleteTask(id primitive.ObjectID) error {
-	args := m.Called(id)
-	return args.Error(0)
+func (m *MockTaskService) PatchTask(ctx context.Context, principal Domain.Principal, id string, version int64, contentType string, patch []byte) (*Domain.Task, error) {
+	args := m.Called(principal, id, version, contentType, patch)
+	task, _ := args.Get(0).(*Domain.Task)
+	return task, args.Error(1)
 }
 
-func (m *MockTaskService) GetTasksByUserID(userID primitive.ObjectID) ([]Domain.Task, error) {
-	args := m.Called(userID)
-	return args.Get(0).([]Domain.Task), args.Error(1)
+func (m *MockTaskService) DeleteTask(ctx context.Context, principal Domain.Principal, id string, version int64) error {
+	args := m.Called(principal, id, version)
+	return args.Error(0)
 }
 
 // Mock UserService
@@ -51,66 +61,344 @@ type MockUserService struct {
 	mock.Mock
 }
 
-func (m *MockUserService) RegisterUser(user Domain.User) (*Domain.User, error) {
+func (m *MockUserService) RegisterUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
 	args := m.Called(user)
 	return args.Get(0).(*Domain.User), args.Error(1)
 }
 
-func (m *MockUserService) AuthenticateUser(username, password string) (string, error) {
-	args := m.Called(username, password)
-	return args.String(0), args.Error(1)
+func (m *MockUserService) AuthenticateUser(ctx context.Context, username, password, clientIP string) (*Domain.TokenPair, *Domain.MFAChallenge, error) {
+	args := m.Called(username, password, clientIP)
+	pair, _ := args.Get(0).(*Domain.TokenPair)
+	challenge, _ := args.Get(1).(*Domain.MFAChallenge)
+	return pair, challenge, args.Error(2)
+}
+
+func (m *MockUserService) CompleteMFALogin(ctx context.Context, mfaToken, code, clientIP string) (*Domain.TokenPair, error) {
+	args := m.Called(mfaToken, code, clientIP)
+	pair, _ := args.Get(0).(*Domain.TokenPair)
+	return pair, args.Error(1)
+}
+
+func (m *MockUserService) BeginTOTPEnrollment(ctx context.Context, principal Domain.Principal) (*Domain.TOTPEnrollment, error) {
+	args := m.Called(principal)
+	enrollment, _ := args.Get(0).(*Domain.TOTPEnrollment)
+	return enrollment, args.Error(1)
+}
+
+func (m *MockUserService) ConfirmTOTPEnrollment(ctx context.Context, principal Domain.Principal, code string) ([]string, error) {
+	args := m.Called(principal, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockUserService) RegenerateRecoveryCodes(ctx context.Context, principal Domain.Principal, code string) ([]string, error) {
+	args := m.Called(principal, code)
+	codes, _ := args.Get(0).([]string)
+	return codes, args.Error(1)
+}
+
+func (m *MockUserService) DisableMFA(ctx context.Context, principal Domain.Principal, code string) error {
+	args := m.Called(principal, code)
+	return args.Error(0)
 }
 
-func (m *MockUserService) GetUserByID(userID primitive.ObjectID) (*Domain.User, error) {
+func (m *MockUserService) ResetMFA(ctx context.Context, userID string) (*Domain.User, error) {
+	args := m.Called(userID)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) SetRoleMFA(ctx context.Context, name string, required bool) (*Domain.Role, error) {
+	args := m.Called(name, required)
+	role, _ := args.Get(0).(*Domain.Role)
+	return role, args.Error(1)
+}
+
+func (m *MockUserService) RefreshTokens(ctx context.Context, refreshToken string) (*Domain.TokenPair, error) {
+	args := m.Called(refreshToken)
+	pair, _ := args.Get(0).(*Domain.TokenPair)
+	return pair, args.Error(1)
+}
+
+func (m *MockUserService) Logout(ctx context.Context, principal Domain.Principal) error {
+	args := m.Called(principal)
+	return args.Error(0)
+}
+
+func (m *MockUserService) GetUserByID(ctx context.Context, userID string) (*Domain.User, error) {
 	args := m.Called(userID)
 	return args.Get(0).(*Domain.User), args.Error(1)
 }
 
-func (m *MockUserService) GetAllUsers() ([]Domain.User, error) {
+func (m *MockUserService) GetAllUsers(ctx context.Context) ([]Domain.User, error) {
 	args := m.Called()
 	return args.Get(0).([]Domain.User), args.Error(1)
 }
 
+func (m *MockUserService) GetProfile(ctx context.Context, principal Domain.Principal) (*Domain.User, error) {
+	args := m.Called(principal)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) UpdateProfile(ctx context.Context, principal Domain.Principal, username string) (*Domain.User, error) {
+	args := m.Called(principal, username)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) ChangePassword(ctx context.Context, principal Domain.Principal, currentPassword, newPassword, clientIP string) (*Domain.TokenPair, error) {
+	args := m.Called(principal, currentPassword, newPassword)
+	pair, _ := args.Get(0).(*Domain.TokenPair)
+	return pair, args.Error(1)
+}
+
+func (m *MockUserService) DeleteAccount(ctx context.Context, principal Domain.Principal) error {
+	args := m.Called(principal)
+	return args.Error(0)
+}
+
+func (m *MockUserService) SetUserRole(ctx context.Context, userID, role string) (*Domain.User, error) {
+	args := m.Called(userID, role)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) SetUserDisabled(ctx context.Context, userID string, disabled bool) (*Domain.User, error) {
+	args := m.Called(userID, disabled)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) DeleteUser(ctx context.Context, userID string, deletion Domain.UserDeletion) error {
+	args := m.Called(userID, deletion)
+	return args.Error(0)
+}
+
+func (m *MockUserService) SetUserTeam(ctx context.Context, userID, team string) (*Domain.User, error) {
+	args := m.Called(userID, team)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserService) CreateAPIKey(ctx context.Context, principal Domain.Principal, name string, scopes []Domain.Permission, expiresAt *time.Time) (*Domain.APIKey, string, error) {
+	args := m.Called(principal, name, scopes, expiresAt)
+	key, _ := args.Get(0).(*Domain.APIKey)
+	return key, args.String(1), args.Error(2)
+}
+
+func (m *MockUserService) ListAPIKeys(ctx context.Context, principal Domain.Principal) ([]Domain.APIKey, error) {
+	args := m.Called(principal)
+	return args.Get(0).([]Domain.APIKey), args.Error(1)
+}
+
+func (m *MockUserService) RevokeAPIKey(ctx context.Context, principal Domain.Principal, id string) error {
+	args := m.Called(principal, id)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListRoles(ctx context.Context) ([]Domain.Role, error) {
+	args := m.Called()
+	return args.Get(0).([]Domain.Role), args.Error(1)
+}
+
+func (m *MockUserService) SaveRole(ctx context.Context, role Domain.Role) (*Domain.Role, error) {
+	args := m.Called(role)
+	saved, _ := args.Get(0).(*Domain.Role)
+	return saved, args.Error(1)
+}
+
+func (m *MockUserService) DeleteRole(ctx context.Context, name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+func (m *MockUserService) ListLoginAttempts(ctx context.Context) ([]Domain.LoginAttempts, error) {
+	args := m.Called()
+	return args.Get(0).([]Domain.LoginAttempts), args.Error(1)
+}
+
+func (m *MockUserService) ClearLoginAttempts(ctx context.Context, key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+var caller = Domain.Principal{UserID: primitive.NewObjectID(), Username: "caller", Role: Domain.RoleUser}
+
+// newTestRouter returns a router that renders handler errors the way the
+// real one does.
+func newTestRouter() *gin.Engine {
+	router := gin.Default()
+	router.Use(Infrastructure.ErrorMiddleware())
+	return router
+}
+
+// authenticatedAs stands in for AuthMiddleware
+func authenticatedAs(p Domain.Principal) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		Infrastructure.SetPrincipal(c, p)
+	}
+}
+
 // Test GetTasks
 func TestTaskController_GetTasks(t *testing.T) {
 	mockTaskService := new(MockTaskService)
 	mockUserService := new(MockUserService)
 
-	mockTaskService.On("GetTasks").Return([]Domain.Task{
-		{ID: primitive.NewObjectID(), Title: "Test Task"},
+	expectedFilter := Domain.TaskFilter{
+		Status:   Domain.StatusTodo,
+		Search:   "test",
+		SortBy:   "due_date",
+		SortDesc: true,
+		Limit:    10,
+	}
+	mockTaskService.On("GetTasks", caller, expectedFilter).Return(&Domain.TaskPage{
+		Tasks: []Domain.Task{{ID: primitive.NewObjectID(), Title: "Test Task"}},
+		Total: 1,
 	}, nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
-	req, _ := http.NewRequest("GET", "/tasks", nil)
+	req, _ := http.NewRequest("GET", "/tasks?status=todo&q=test&sort=-due_date&limit=10", nil)
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.GET("/tasks", tc.GetTasks)
+	router := newTestRouter()
+	router.GET("/tasks", authenticatedAs(caller), tc.GetTasks)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Test Task")
+	assert.Contains(t, w.Body.String(), `"total":1`)
 
 	mockTaskService.AssertExpectations(t)
 }
 
+// Test GetTasks with invalid query parameters
+func TestTaskController_GetTasksInvalidQuery(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.GET("/tasks", authenticatedAs(caller), tc.GetTasks)
+
+	for _, query := range []string{"sort=password", "limit=0", "owner=nope", "due_before=tomorrow", "status=someday"} {
+		req, _ := http.NewRequest("GET", "/tasks?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	mockTaskService.AssertNotCalled(t, "GetTasks", mock.Anything, mock.Anything)
+}
+
+// Test that invalid task payloads come back as a list of field errors
+func TestTaskController_CreateTaskValidation(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.POST("/tasks", authenticatedAs(caller), tc.CreateTask)
+
+	body := `{"title":"  ","due_date":"1970-01-01T00:00:00Z","status":"someday"}`
+	req, _ := http.NewRequest("POST", "/tasks", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var problem Infrastructure.Problem
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "validation_failed", problem.Code)
+	assert.ElementsMatch(t, []Domain.FieldError{
+		{Field: "title", Rule: "notblank", Message: "must not be blank"},
+		{Field: "due_date", Rule: "duedate", Message: "must be between 2000 and 2100"},
+		{Field: "status", Rule: "taskstatus", Message: "must be one of todo, in_progress, blocked, done, archived"},
+	}, problem.Errors)
+
+	yesterday := time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	req, _ = http.NewRequest("POST", "/tasks", strings.NewReader(`{"title":"Late","due_date":"`+yesterday+`"}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"rule":"notpast"`)
+
+	mockTaskService.AssertNotCalled(t, "CreateTask", mock.Anything, mock.Anything)
+}
+
+// Test that Register rejects weak input and client-chosen roles
+func TestTaskController_RegisterValidation(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+	mockUserService.On("RegisterUser", Domain.User{Username: "alice", Password: "correct horse"}).
+		Return(&Domain.User{ID: primitive.NewObjectID(), Username: "alice", Role: Domain.RoleUser}, nil)
+	// Password length is the service's PasswordPolicy to decide
+	mockUserService.On("RegisterUser", Domain.User{Username: "alice", Password: "short"}).
+		Return((*Domain.User)(nil), &Domain.ValidationError{Fields: []Domain.FieldError{{Field: "password", Rule: "min", Message: "must be at least 8 characters"}}})
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.POST("/register", tc.Register)
+
+	cases := []struct {
+		body   string
+		status int
+		field  string
+	}{
+		{`{"username":"alice","password":"correct horse"}`, http.StatusCreated, ""},
+		{`{"username":"alice","password":"correct horse","role":"admin"}`, http.StatusUnprocessableEntity, `"field":"role"`},
+		{`{"username":"alice","password":""}`, http.StatusUnprocessableEntity, `"field":"password"`},
+		{`{"username":"alice","password":"short"}`, http.StatusUnprocessableEntity, `"field":"password"`},
+		{`{"username":"al ice","password":"correct horse"}`, http.StatusUnprocessableEntity, `"field":"username"`},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest("POST", "/register", strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Code, tc.body)
+		assert.Contains(t, w.Body.String(), tc.field, tc.body)
+	}
+	mockUserService.AssertNumberOfCalls(t, "RegisterUser", 2)
+}
+
+// Test that registering a taken username is a conflict
+func TestTaskController_RegisterDuplicate(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+	mockUserService.On("RegisterUser", Domain.User{Username: "Alice", Password: "correct horse"}).
+		Return((*Domain.User)(nil), Domain.ErrUsernameTaken)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.POST("/register", tc.Register)
+
+	req, _ := http.NewRequest("POST", "/register", strings.NewReader(`{"username":"Alice","password":"correct horse"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"username_taken"`)
+	mockUserService.AssertExpectations(t)
+}
+
 // Test GetTask
 func TestTaskController_GetTask(t *testing.T) {
 	mockTaskService := new(MockTaskService)
 	mockUserService := new(MockUserService)
 
 	taskID := primitive.NewObjectID()
-	mockTaskService.On("GetTask", taskID).Return(&Domain.Task{
+	mockTaskService.On("GetTask", caller, taskID.Hex()).Return(&Domain.Task{
 		ID:    taskID,
 		Title: "Test Task",
 	}, nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
 	req, _ := http.NewRequest("GET", "/tasks/"+taskID.Hex(), nil)
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.GET("/tasks/:id", tc.GetTask)
+	router := newTestRouter()
+	router.GET("/tasks/:id", authenticatedAs(caller), tc.GetTask)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -119,23 +407,59 @@ func TestTaskController_GetTask(t *testing.T) {
 	mockTaskService.AssertExpectations(t)
 }
 
+// Test that GetTask sends an ETag and honours If-None-Match
+func TestTaskController_GetTaskConditional(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	mockTaskService.On("GetTask", caller, taskID.Hex()).Return(&Domain.Task{
+		ID:      taskID,
+		Title:   "Test Task",
+		Version: 7,
+	}, nil)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.GET("/tasks/:id", authenticatedAs(caller), tc.GetTask)
+
+	req, _ := http.NewRequest("GET", "/tasks/"+taskID.Hex(), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"7"`, w.Header().Get("ETag"))
+
+	req, _ = http.NewRequest("GET", "/tasks/"+taskID.Hex(), nil)
+	req.Header.Set("If-None-Match", `"6", W/"7"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	req, _ = http.NewRequest("GET", "/tasks/"+taskID.Hex(), nil)
+	req.Header.Set("If-None-Match", `"6"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 // Test CreateTask
 func TestTaskController_CreateTask(t *testing.T) {
 	mockTaskService := new(MockTaskService)
 	mockUserService := new(MockUserService)
 
 	newTask := Domain.Task{Title: "New Task"}
-	mockTaskService.On("CreateTask", newTask).Return(&Domain.Task{
+	mockTaskService.On("CreateTask", caller, newTask).Return(&Domain.Task{
 		ID:    primitive.NewObjectID(),
 		Title: "New Task",
 	}, nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
-	req, _ := http.NewRequest("POST", "/tasks", nil)
+	req, _ := http.NewRequest("POST", "/tasks", strings.NewReader(`{"title":"New Task"}`))
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.POST("/tasks", tc.CreateTask)
+	router := newTestRouter()
+	router.POST("/tasks", authenticatedAs(caller), tc.CreateTask)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
@@ -151,17 +475,17 @@ func TestTaskController_UpdateTask(t *testing.T) {
 
 	taskID := primitive.NewObjectID()
 	updatedTask := Domain.Task{Title: "Updated Task"}
-	mockTaskService.On("UpdateTask", taskID, updatedTask).Return(&Domain.Task{
+	mockTaskService.On("UpdateTask", caller, taskID.Hex(), int64(0), updatedTask).Return(&Domain.Task{
 		ID:    taskID,
 		Title: "Updated Task",
 	}, nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
-	req, _ := http.NewRequest("PUT", "/tasks/"+taskID.Hex(), nil)
+	req, _ := http.NewRequest("PUT", "/tasks/"+taskID.Hex(), strings.NewReader(`{"title":"Updated Task"}`))
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.PUT("/tasks/:id", tc.UpdateTask)
+	router := newTestRouter()
+	router.PUT("/tasks/:id", authenticatedAs(caller), tc.UpdateTask)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -170,50 +494,430 @@ func TestTaskController_UpdateTask(t *testing.T) {
 	mockTaskService.AssertExpectations(t)
 }
 
+// Test that disallowed status transitions are reported as conflicts
+func TestTaskController_UpdateTaskInvalidTransition(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	updatedTask := Domain.Task{Title: "Reopened", Status: Domain.StatusTodo}
+	mockTaskService.On("UpdateTask", caller, taskID.Hex(), int64(0), updatedTask).Return(nil,
+		&Domain.TransitionError{From: Domain.StatusArchived, To: Domain.StatusTodo})
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+
+	req, _ := http.NewRequest("PUT", "/tasks/"+taskID.Hex(), strings.NewReader(`{"title":"Reopened","status":"todo"}`))
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.PUT("/tasks/:id", authenticatedAs(caller), tc.UpdateTask)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "cannot move a task from archived to todo")
+
+	mockTaskService.AssertExpectations(t)
+}
+
+// Test that If-Match is passed on as the expected version
+func TestTaskController_UpdateTaskIfMatch(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	mockTaskService.On("UpdateTask", caller, taskID.Hex(), int64(3), Domain.Task{Title: "Updated Task"}).
+		Return(&Domain.Task{ID: taskID, Title: "Updated Task", Version: 4}, nil)
+	mockTaskService.On("UpdateTask", caller, taskID.Hex(), int64(2), Domain.Task{Title: "Updated Task"}).
+		Return(nil, Domain.ErrVersionMismatch)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.PUT("/tasks/:id", authenticatedAs(caller), tc.UpdateTask)
+
+	cases := []struct {
+		ifMatch string
+		status  int
+	}{
+		{`"3"`, http.StatusOK},
+		{`"2"`, http.StatusPreconditionFailed},
+		{`W/"3"`, http.StatusPreconditionFailed},
+		{`"three"`, http.StatusPreconditionFailed},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest("PUT", "/tasks/"+taskID.Hex(), strings.NewReader(`{"title":"Updated Task"}`))
+		req.Header.Set("If-Match", tc.ifMatch)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Code, tc.ifMatch)
+		if tc.status == http.StatusOK {
+			assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+		}
+	}
+
+	mockTaskService.AssertExpectations(t)
+}
+
+// Test PatchTask
+func TestTaskController_PatchTask(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	patch := `{"status":"in_progress"}`
+	mockTaskService.On("PatchTask", caller, taskID.Hex(), int64(0), "application/merge-patch+json", []byte(patch)).Return(&Domain.Task{
+		ID:     taskID,
+		Title:  "Unchanged Title",
+		Status: Domain.StatusInProgress,
+	}, nil)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+
+	req, _ := http.NewRequest("PATCH", "/tasks/"+taskID.Hex(), strings.NewReader(patch))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.PATCH("/tasks/:id", authenticatedAs(caller), tc.PatchTask)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Unchanged Title")
+
+	mockTaskService.AssertExpectations(t)
+}
+
+// Test that unsupported patch formats advertise the accepted ones
+func TestTaskController_PatchTaskUnsupportedMediaType(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	mockTaskService.On("PatchTask", caller, taskID.Hex(), int64(0), "text/plain", []byte("title=x")).Return(nil, Domain.ErrUnsupportedPatch)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+
+	req, _ := http.NewRequest("PATCH", "/tasks/"+taskID.Hex(), strings.NewReader("title=x"))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.PATCH("/tasks/:id", authenticatedAs(caller), tc.PatchTask)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Contains(t, w.Header().Get("Accept-Patch"), "application/json-patch+json")
+}
+
+// Test that usecase errors become problem+json responses with stable codes
+func TestTaskController_ErrorResponses(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	mockTaskService.On("UpdateTask", caller, "not-an-id", int64(0), Domain.Task{Title: "Task"}).Return(nil, Domain.ErrInvalidID)
+	mockTaskService.On("GetTask", caller, "broken").Return(nil, errors.New("server selection timeout"))
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.GET("/tasks/:id", authenticatedAs(caller), tc.GetTask)
+	router.PUT("/tasks/:id", authenticatedAs(caller), tc.UpdateTask)
+
+	req, _ := http.NewRequest("PUT", "/tasks/not-an-id", strings.NewReader(`{"title":"Task"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, Infrastructure.ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"code":"invalid_id"`)
+
+	req, _ = http.NewRequest("GET", "/tasks/broken", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"internal_error"`)
+
+	req, _ = http.NewRequest("PUT", "/tasks/not-an-id", strings.NewReader(`{"title":`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"invalid_request"`)
+}
+
 // Test DeleteTask
 func TestTaskController_DeleteTask(t *testing.T) {
 	mockTaskService := new(MockTaskService)
 	mockUserService := new(MockUserService)
 
 	taskID := primitive.NewObjectID()
-	mockTaskService.On("DeleteTask", taskID).Return(nil)
+	mockTaskService.On("DeleteTask", caller, taskID.Hex(), int64(0)).Return(nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
 	req, _ := http.NewRequest("DELETE", "/tasks/"+taskID.Hex(), nil)
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.DELETE("/tasks/:id", tc.DeleteTask)
+	router := newTestRouter()
+	router.DELETE("/tasks/:id", authenticatedAs(caller), tc.DeleteTask)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockTaskService.AssertExpectations(t)
+}
+
+// Test UpdateTask on a task the caller doesn't own
+func TestTaskController_UpdateTaskNotOwned(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	taskID := primitive.NewObjectID()
+	mockTaskService.On("UpdateTask", caller, taskID.Hex(), int64(0), Domain.Task{Title: "Mine now"}).
+		Return(nil, Domain.ErrTaskNotFound)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+
+	req, _ := http.NewRequest("PUT", "/tasks/"+taskID.Hex(), strings.NewReader(`{"title":"Mine now"}`))
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.PUT("/tasks/:id", authenticatedAs(caller), tc.UpdateTask)
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 
 	mockTaskService.AssertExpectations(t)
 }
 
+// Test that task routes reject requests without a principal
+func TestTaskController_RequiresPrincipal(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+
+	req, _ := http.NewRequest("GET", "/tasks", nil)
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.GET("/tasks", tc.GetTasks)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
 // Test GetTasksByUserID
 func TestTaskController_GetTasksByUserID(t *testing.T) {
 	mockTaskService := new(MockTaskService)
 	mockUserService := new(MockUserService)
 
 	userID := primitive.NewObjectID()
-	mockTaskService.On("GetTasksByUserID", userID).Return([]Domain.Task{
-		{ID: primitive.NewObjectID(), Title: "Task for user1"},
+	expectedFilter := Domain.TaskFilter{
+		UserID: userID.Hex(),
+		Status: Domain.StatusTodo,
+		Limit:  5,
+		Cursor: "next",
+	}
+	mockTaskService.On("GetTasks", caller, expectedFilter).Return(&Domain.TaskPage{
+		Tasks:      []Domain.Task{{ID: primitive.NewObjectID(), Title: "Task for user1"}},
+		NextCursor: "after",
+		Total:      6,
 	}, nil)
 
-	tc := NewTaskController(mockTaskService, mockUserService, "secretKey")
+	tc := NewTaskController(mockTaskService, mockUserService)
 
-	req, _ := http.NewRequest("GET", "/tasks/user/"+userID.Hex(), nil)
+	// The path owner wins over an owner in the query.
+	other := primitive.NewObjectID().Hex()
+	req, _ := http.NewRequest("GET", "/tasks/user/"+userID.Hex()+"?status=todo&limit=5&cursor=next&owner="+other, nil)
 	w := httptest.NewRecorder()
-	router := gin.Default()
-	router.GET("/tasks/user/:userID", tc.GetTasksByUserID)
+	router := newTestRouter()
+	router.GET("/tasks/user/:user_id", authenticatedAs(caller), tc.GetTasksByUserID)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Task for user1")
+	assert.Contains(t, w.Body.String(), `"next_cursor":"after"`)
+	assert.Contains(t, w.Body.String(), `"total":6`)
 
 	mockTaskService.AssertExpectations(t)
 }
 
+// Test Refresh
+func TestTaskController_Refresh(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	mockUserService.On("RefreshTokens", "old").Return(&Domain.TokenPair{AccessToken: "access", RefreshToken: "new"}, nil)
+	mockUserService.On("RefreshTokens", "reused").Return(nil, Domain.ErrTokenReused)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.POST("/refresh", tc.Refresh)
+
+	req, _ := http.NewRequest("POST", "/refresh", strings.NewReader(`{"refresh_token":"old"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"refresh_token":"new"`)
+
+	req, _ = http.NewRequest("POST", "/refresh", strings.NewReader(`{"refresh_token":"reused"}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	mockUserService.AssertExpectations(t)
+}
+
+// Test Logout
+func TestTaskController_Logout(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	mockUserService.On("Logout", caller).Return(nil)
 
+	tc := NewTaskController(mockTaskService, mockUserService)
 
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	w := httptest.NewRecorder()
+	router := newTestRouter()
+	router.POST("/logout", authenticatedAs(caller), tc.Logout)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockUserService.AssertExpectations(t)
+}
+
+// Test the /me endpoints
+func TestTaskController_Account(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	profile := &Domain.User{ID: caller.UserID, Username: "caller", Password: "hash", Role: Domain.RoleUser}
+	renamed := &Domain.User{ID: caller.UserID, Username: "renamed", Password: "hash", Role: Domain.RoleUser}
+	mockUserService.On("GetProfile", caller).Return(profile, nil)
+	mockUserService.On("UpdateProfile", caller, "renamed").Return(renamed, nil)
+	mockUserService.On("ChangePassword", caller, "old password", "new password").Return(&Domain.TokenPair{RefreshToken: "fresh"}, nil)
+	mockUserService.On("ChangePassword", caller, "wrong", "new password").Return(nil, Domain.ErrWrongPassword)
+	mockUserService.On("ChangePassword", caller, "old password", "short").
+		Return(nil, &Domain.ValidationError{Fields: []Domain.FieldError{{Field: "new_password", Rule: "min", Message: "must be at least 8 characters"}}})
+	mockUserService.On("DeleteAccount", caller).Return(nil)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	me := router.Group("/me", authenticatedAs(caller))
+	me.GET("", tc.GetProfile)
+	me.PATCH("", tc.UpdateProfile)
+	me.PUT("/password", tc.ChangePassword)
+	me.DELETE("", tc.DeleteAccount)
+
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+	}{
+		{"GET", "/me", "", http.StatusOK, `"username":"caller"`},
+		{"PATCH", "/me", `{"username":"renamed"}`, http.StatusOK, `"username":"renamed"`},
+		{"PATCH", "/me", `{"username":"no"}`, http.StatusUnprocessableEntity, `"field":"username"`},
+		{"PUT", "/me/password", `{"current_password":"old password","new_password":"new password"}`, http.StatusOK, `"refresh_token":"fresh"`},
+		{"PUT", "/me/password", `{"current_password":"wrong","new_password":"new password"}`, http.StatusForbidden, `"code":"wrong_password"`},
+		{"PUT", "/me/password", `{"current_password":"old password","new_password":"short"}`, http.StatusUnprocessableEntity, `"field":"new_password"`},
+		{"DELETE", "/me", "", http.StatusOK, "deleted"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.method+" "+tt.body)
+		assert.Contains(t, w.Body.String(), tt.wantBody)
+		assert.NotContains(t, w.Body.String(), "hash")
+	}
+	mockUserService.AssertExpectations(t)
+}
+
+// Test the admin user management endpoints
+func TestTaskController_AdminUserManagement(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+
+	userID := primitive.NewObjectID().Hex()
+	otherID := primitive.NewObjectID().Hex()
+	promoted := &Domain.User{Username: "bob", Role: Domain.RoleAdmin}
+	disabled := &Domain.User{Username: "bob", Role: Domain.RoleUser, Disabled: true}
+	mockUserService.On("SetUserRole", userID, Domain.RoleAdmin).Return(promoted, nil)
+	mockUserService.On("SetUserRole", userID, "root").Return(nil, Domain.ErrInvalidRole)
+	mockUserService.On("SetUserDisabled", userID, true).Return(disabled, nil)
+	mockUserService.On("SetUserDisabled", userID, false).Return(nil, Domain.ErrLastAdmin)
+	mockUserService.On("DeleteUser", userID, Domain.UserDeletion{Tasks: Domain.CascadeRestrict}).Return(Domain.ErrUserHasTasks)
+	mockUserService.On("DeleteUser", userID, Domain.UserDeletion{Tasks: Domain.CascadeReassign, ReassignTo: otherID}).Return(nil)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.PUT("/admin/users/:id/role", tc.SetUserRole)
+	router.POST("/admin/users/:id/disable", tc.DisableUser)
+	router.POST("/admin/users/:id/enable", tc.EnableUser)
+	router.DELETE("/admin/users/:id", tc.DeleteUser)
+
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantBody           string
+	}{
+		{"PUT", "/admin/users/" + userID + "/role", `{"role":"admin"}`, http.StatusOK, `"role":"admin"`},
+		{"PUT", "/admin/users/" + userID + "/role", `{"role":"root"}`, http.StatusUnprocessableEntity, `"code":"invalid_role"`},
+		{"POST", "/admin/users/" + userID + "/disable", "", http.StatusOK, `"disabled":true`},
+		{"POST", "/admin/users/" + userID + "/enable", "", http.StatusConflict, `"code":"last_admin"`},
+		{"DELETE", "/admin/users/" + userID, "", http.StatusConflict, `"code":"user_has_tasks"`},
+		{"DELETE", "/admin/users/" + userID + "?tasks=reassign&reassign_to=" + otherID, "", http.StatusOK, "deleted"},
+		{"DELETE", "/admin/users/" + userID + "?tasks=shred", "", http.StatusBadRequest, `"code":"invalid_request"`},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.method+" "+tt.path)
+		assert.Contains(t, w.Body.String(), tt.wantBody)
+	}
+	mockUserService.AssertExpectations(t)
+}
+
+// Test that a throttled login is answered with 429 and Retry-After
+func TestTaskController_LoginThrottled(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+	mockUserService.On("AuthenticateUser", "alice", "guess", "192.0.2.1").
+		Return(nil, nil, &Domain.ThrottledError{RetryAfter: 90 * time.Second})
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.POST("/login", tc.Login)
+
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(`{"username":"alice","password":"guess"}`))
+	req.RemoteAddr = "192.0.2.1:40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "90", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"code":"too_many_attempts"`)
+	mockUserService.AssertExpectations(t)
+}
+
+// Test listing and clearing lockouts
+func TestTaskController_Lockouts(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockUserService := new(MockUserService)
+	mockUserService.On("ListLoginAttempts").Return([]Domain.LoginAttempts{{Key: "user:alice", Failures: 5}}, nil)
+	mockUserService.On("ClearLoginAttempts", "user:alice").Return(nil)
+
+	tc := NewTaskController(mockTaskService, mockUserService)
+	router := newTestRouter()
+	router.GET("/admin/lockouts", tc.ListLockouts)
+	router.DELETE("/admin/lockouts/:key", tc.ClearLockout)
+
+	req, _ := http.NewRequest("GET", "/admin/lockouts", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"user:alice","failures":5`)
+
+	req, _ = http.NewRequest("DELETE", "/admin/lockouts/user:alice", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockUserService.AssertExpectations(t)
+}
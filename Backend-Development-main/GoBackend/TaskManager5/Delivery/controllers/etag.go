@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskETag is the strong entity tag of one version of a task.
+func taskETag(task *Domain.Task) string {
+	return `"` + strconv.FormatInt(task.Version, 10) + `"`
+}
+
+// ifMatchVersion reads the If-Match header. An absent header or "*" yields
+// version 0, which writes unconditionally. ok is false when the header
+// can't name a task version and so can never match.
+func ifMatchVersion(c *gin.Context) (version int64, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, true
+	}
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version < 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches etag, using
+// the weak comparison RFC 9110 prescribes for it.
+func ifNoneMatch(c *gin.Context, etag string) bool {
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Request bodies are bound into these DTOs rather than the persistence
+// structs, so clients can only set the fields listed here and every field
+// is validated by its binding tag. Passwords are left to the service's
+// PasswordPolicy, which is configurable. The task limits are those of
+// Domain.ValidateTask, which PATCH is checked with.
+
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=32,username"`
+	Password string `json:"password" binding:"required"`
+	// Role is only declared so that a client trying to choose one gets a
+	// field error instead of having it silently dropped.
+	Role string `json:"role" binding:"isdefault"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// MFALoginRequest answers an MFA challenge with a TOTP or recovery code.
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required,max=32"`
+}
+
+// MFACodeRequest carries a TOTP code, or a recovery code where one is
+// accepted, to confirm a change to the caller's second factor.
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+type UpdateProfileRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=32,username"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// CreateAPIKeyRequest names a new API key and what it may do. Without an
+// expiry the key gets the server's default lifetime.
+type CreateAPIKeyRequest struct {
+	Name      string              `json:"name" binding:"notblank,max=64"`
+	Scopes    []Domain.Permission `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time          `json:"expires_at"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// SetTeamRequest moves a user to a team; an empty team takes them out of it.
+type SetTeamRequest struct {
+	Team string `json:"team" binding:"max=64"`
+}
+
+// SaveRoleRequest lists every permission of the role; there is no partial
+// update.
+type SaveRoleRequest struct {
+	Permissions []Domain.Permission `json:"permissions" binding:"required"`
+}
+
+// RoleMFARequest sets whether a role requires MFA. Required is a pointer so
+// that leaving it out is an error rather than false.
+type RoleMFARequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+type CreateTaskRequest struct {
+	Title       string     `json:"title" binding:"notblank,max=200"`
+	Description string     `json:"description" binding:"max=5000"`
+	DueDate     *time.Time `json:"due_date" binding:"omitempty,duedate,notpast"`
+	Status      string     `json:"status" binding:"omitempty,taskstatus"`
+}
+
+func (r CreateTaskRequest) toTask() Domain.Task {
+	task := Domain.Task{Title: r.Title, Description: r.Description, Status: Domain.TaskStatus(r.Status)}
+	if r.DueDate != nil {
+		task.DueDate = *r.DueDate
+	}
+	return task
+}
+
+// UpdateTaskRequest replaces a task. Past due dates are allowed so that
+// overdue tasks can still be edited; an empty status keeps the current one.
+type UpdateTaskRequest struct {
+	Title       string     `json:"title" binding:"notblank,max=200"`
+	Description string     `json:"description" binding:"max=5000"`
+	DueDate     *time.Time `json:"due_date" binding:"omitempty,duedate"`
+	Status      string     `json:"status" binding:"omitempty,taskstatus"`
+}
+
+func (r UpdateTaskRequest) toTask() Domain.Task {
+	return CreateTaskRequest(r).toTask()
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// dueDateGrace lets a client create a task due earlier today without
+// tripping over clock differences and time zones.
+const dueDateGrace = 24 * time.Hour
+
+var fieldRules = map[string]validator.Func{
+	"notblank": func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	},
+	"username": func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	},
+	"taskstatus": func(fl validator.FieldLevel) bool {
+		_, err := Domain.ParseTaskStatus(fl.Field().String())
+		return err == nil
+	},
+	"duedate": func(fl validator.FieldLevel) bool {
+		t, ok := fl.Field().Interface().(time.Time)
+		return ok && Domain.ValidDueDate(t)
+	},
+	"notpast": func(fl validator.FieldLevel) bool {
+		t, ok := fl.Field().Interface().(time.Time)
+		return ok && t.After(time.Now().Add(-dueDateGrace))
+	},
+}
+
+var ruleMessages = map[string]string{
+	"required":   "is required",
+	"notblank":   "must not be blank",
+	"username":   "may only contain letters, digits, '.', '_' and '-'",
+	"taskstatus": "must be one of todo, in_progress, blocked, done, archived",
+	"duedate":    "must be between 2000 and 2100",
+	"notpast":    "must not be in the past",
+	"isdefault":  "cannot be set",
+}
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	for tag, rule := range fieldRules {
+		if err := engine.RegisterValidation(tag, rule); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// bindJSON binds and validates the request body. On failure it records a
+// Domain.ValidationError, or Domain.ErrInvalidRequest for a body that isn't
+// valid JSON, and returns false.
+func bindJSON(c *gin.Context, target interface{}) bool {
+	err := c.ShouldBindJSON(target)
+	if err == nil {
+		return true
+	}
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		c.Error(fmt.Errorf("%w: %v", Domain.ErrInvalidRequest, err))
+		return false
+	}
+	fields := make([]Domain.FieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		fields = append(fields, Domain.FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: ruleMessage(fe),
+		})
+	}
+	c.Error(&Domain.ValidationError{Fields: fields})
+	return false
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "min":
+		return fmt.Sprintf("must be at least %s characters", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	}
+	if message, ok := ruleMessages[fe.Tag()]; ok {
+		return message
+	}
+	return "is invalid"
+}
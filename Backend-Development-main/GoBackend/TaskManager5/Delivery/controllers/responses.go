@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"time"
+
+	"TaskManager5/Domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Users are only ever written to responses through these views. They copy
+// the fields a client may see, so a credential added to Domain.User later
+// can't leak by accident.
+
+// UserProfile is what a user sees about an account.
+type UserProfile struct {
+	ID         primitive.ObjectID `json:"id"`
+	Username   string             `json:"username"`
+	Role       string             `json:"role"`
+	Team       string             `json:"team,omitempty"`
+	MFAEnabled bool               `json:"mfa_enabled"`
+}
+
+func newUserProfile(user Domain.User) UserProfile {
+	return UserProfile{ID: user.ID, Username: user.Username, Role: user.Role, Team: user.Team, MFAEnabled: user.MFA.Enabled}
+}
+
+// AdminUserView is what an admin sees when managing accounts.
+type AdminUserView struct {
+	ID         primitive.ObjectID `json:"id"`
+	Username   string             `json:"username"`
+	Role       string             `json:"role"`
+	Team       string             `json:"team,omitempty"`
+	Disabled   bool               `json:"disabled"`
+	MFAEnabled bool               `json:"mfa_enabled"`
+}
+
+func newAdminUserView(user Domain.User) AdminUserView {
+	return AdminUserView{
+		ID:         user.ID,
+		Username:   user.Username,
+		Role:       user.Role,
+		Team:       user.Team,
+		Disabled:   user.Disabled,
+		MFAEnabled: user.MFA.Enabled,
+	}
+}
+
+func newAdminUserViews(users []Domain.User) []AdminUserView {
+	views := make([]AdminUserView, len(users))
+	for i, user := range users {
+		views[i] = newAdminUserView(user)
+	}
+	return views
+}
+
+// APIKeyView describes an API key without its secret.
+type APIKeyView struct {
+	ID         string              `json:"id"`
+	Name       string              `json:"name"`
+	Scopes     []Domain.Permission `json:"scopes"`
+	CreatedAt  time.Time           `json:"created_at"`
+	ExpiresAt  time.Time           `json:"expires_at"`
+	LastUsedAt *time.Time          `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time          `json:"revoked_at,omitempty"`
+}
+
+func newAPIKeyView(key Domain.APIKey) APIKeyView {
+	return APIKeyView{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
+
+// CreatedAPIKey is an API key as it is created, the only time Key is shown.
+type CreatedAPIKey struct {
+	APIKeyView
+	Key string `json:"key"`
+}
+
+// RecoveryCodes are a user's new recovery codes, shown this once only.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
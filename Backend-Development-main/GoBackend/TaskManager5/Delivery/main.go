@@ -2,56 +2,183 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"TaskManager5/Delivery/controllers"
 	"TaskManager5/Delivery/router"
+	"TaskManager5/Infrastructure"
 	"TaskManager5/Repositories"
 	"TaskManager5/Usecases"
 )
 
-const (
-	mongoURI  = "mongodb://localhost:27017"
-	dbName    = "task_manager"
-	secretKey = "s5e8ydy9GrJwXJf5cF6Sb58y4KpIhR9+Z1kqfO3D6R0="
-)
-
 func main() {
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	cfg, err := Infrastructure.LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		fatal("loading configuration", err)
+	}
+	logger := cfg.Logging.Logger(os.Stdout)
+	slog.SetDefault(logger)
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	// Cancelled on SIGTERM or Ctrl-C, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	store, err := Repositories.OpenStore(ctx, Repositories.StoreOptions{
+		Driver:   cfg.Storage.Driver,
+		MongoURI: cfg.Storage.MongoURI,
+		Database: cfg.Storage.Database,
+		DataDir:  cfg.Storage.DataDir,
+
+		OperationTimeout: time.Duration(cfg.Storage.OperationTimeout),
+	})
 	if err != nil {
-		log.Fatal(err)
+		fatal("opening storage", err)
 	}
 
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			log.Fatal(err)
+	keys, err := cfg.Auth.KeyRing()
+	if err != nil {
+		fatal("loading signing keys", err)
+	}
+	go keys.RunRotation(ctx)
+
+	tokens := Infrastructure.NewTokenService(keys)
+	tokens.AccessTTL = time.Duration(cfg.Auth.AccessTokenTTL)
+	tokens.RefreshTTL = time.Duration(cfg.Auth.RefreshTokenTTL)
+	tokens.Issuer = cfg.Auth.Issuer
+	tokens.Audience = cfg.Auth.Audience
+	tokens.ClockSkew = time.Duration(cfg.Auth.ClockSkew)
+
+	metrics := Infrastructure.NewMetrics()
+	metrics.CountTasks(store.Tasks)
+	tokens.Metrics = metrics
+	tasks := Repositories.NewInstrumentedTaskRepository(store.Tasks, metrics)
+	users := Repositories.NewInstrumentedUserRepository(store.Users, metrics)
+
+	taskService := Usecases.NewTaskService(tasks, users)
+	passwordPolicy := cfg.Password.Policy()
+	if cfg.Password.BlocklistFile != "" {
+		if passwordPolicy.Blocklist, err = Infrastructure.LoadPasswordBlocklist(cfg.Password.BlocklistFile); err != nil {
+			fatal("loading password blocklist", err)
+		}
+	}
+
+	userService := Usecases.NewUserService(users, tasks, store.Tokens, store.LoginAttempts, store.Roles, store.APIKeys, tokens)
+	userService.PasswordPolicy = passwordPolicy
+	userService.Passwords = Infrastructure.NewPasswordService(cfg.Password.Hasher())
+	userService.APIKeyTTL = time.Duration(cfg.Auth.APIKeyTTL)
+	userService.MaxAPIKeyTTL = time.Duration(cfg.Auth.MaxAPIKeyTTL)
+	userService.TOTP.Issuer = cfg.Auth.TOTPIssuer
+	userService.Metrics = metrics
+
+	go func() {
+		if migrate(ctx, store) && cfg.Admin.Username != "" {
+			ensureAdmin(ctx, userService, cfg.Admin)
 		}
 	}()
 
-	db := client.Database(dbName)
+	controller := controllers.NewTaskController(taskService, userService)
 
-	taskRepo := Repositories.NewTaskRepository(db)
-	userRepo := Repositories.NewUserRepository(db, secretKey)
+	r := gin.New()
+	// Login throttling keys on the client address, so only trusted proxies
+	// may set it through X-Forwarded-For.
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("setting trusted proxies", err)
+	}
+	r.Use(Infrastructure.RequestIDMiddleware(), Infrastructure.AccessLogMiddleware(logger))
+	r.Use(metrics.Middleware(), Infrastructure.RecoveryMiddleware())
+	r.Use(Infrastructure.TimeoutMiddleware(time.Duration(cfg.Server.RequestTimeout)))
+	readiness := Infrastructure.NewDrainable(store)
+	routers.SetupRoutes(r, controller, tokens, store.Tokens, store.Roles, userService, readiness)
 
-	taskService := Usecases.NewTaskService(taskRepo)
-	userService := Usecases.NewUserService(userRepo, secretKey)
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: r}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
 
-	controller := controllers.NewTaskController(taskService, userService, secretKey)
+	// Metrics get a listener of their own so that they are not public.
+	var metricsServer *http.Server
+	if cfg.Server.MetricsAddr != "" {
+		mr := gin.New()
+		mr.GET("/metrics", metrics.Handler())
+		metricsServer = &http.Server{Addr: cfg.Server.MetricsAddr, Handler: mr}
+		go func() {
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				fatal("serving metrics", err)
+			}
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
+		fatal("running server", err)
+	case <-ctx.Done():
+	}
+	stop()
 
-	r := gin.Default()
-	routers.SetupRoutes(r, controller, secretKey)
+	// Fail /readyz while still serving, so that load balancers stop sending
+	// requests before connections are refused; then requests in flight get
+	// ShutdownTimeout to finish.
+	slog.Info("shutting down", "drain_delay", time.Duration(cfg.Server.DrainDelay).String())
+	readiness.Drain()
+	time.Sleep(time.Duration(cfg.Server.DrainDelay))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("draining connections", "error", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if err := store.Close(shutdownCtx); err != nil {
+		slog.Error("closing storage", "error", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("running server", "error", err)
+	}
+}
 
+// migrate applies the storage migrations, retrying until they succeed so
+// the server can start before the database is reachable. Until then the
+// store, and so /readyz, reports not ready. It reports false if the server
+// stopped first.
+func migrate(ctx context.Context, store *Repositories.Store) bool {
+	const retryInterval = 5 * time.Second
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := store.Migrate(attemptCtx)
+		cancel()
+		if err == nil {
+			return true
+		}
+		slog.Warn("storage not ready", "retry_in", retryInterval.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(retryInterval):
+		}
+	}
+}
 
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to run server: ", err)
+// ensureAdmin creates the configured admin account if it is missing. A
+// failure is logged rather than fatal, since the server is useful without
+// it and the next start tries again.
+func ensureAdmin(ctx context.Context, users *Usecases.UserService, admin Infrastructure.AdminConfig) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := users.EnsureAdmin(ctx, admin.Username, admin.Password); err != nil {
+		slog.Error("creating admin account", "username", admin.Username, "error", err)
 	}
 }
+
+// fatal logs what failed and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -2,28 +2,80 @@ package routers
 
 import (
 	"TaskManager5/Delivery/controllers"
+	"TaskManager5/Domain"
 	"TaskManager5/Infrastructure"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, controller *controllers.TaskController, secretKey string) {
+func SetupRoutes(r *gin.Engine, controller *controllers.TaskController, tokens *Infrastructure.TokenService, revocations Infrastructure.RevocationChecker, roles Infrastructure.RoleSource, apiKeys Infrastructure.APIKeyAuthenticator, ready Infrastructure.ReadinessChecker) {
+	// Errors recorded by any later middleware or handler become problem+json
+	r.Use(Infrastructure.ErrorMiddleware())
+	// Unknown paths are not found whether or not the caller signed in
+	r.NoRoute(func(c *gin.Context) {
+		c.Error(Domain.NewError(Domain.KindNotFound, "route_not_found", "no such route"))
+	})
+
+	// Probes for the orchestrator: restart when /healthz fails, send no
+	// traffic while /readyz fails
+	r.GET("/healthz", Infrastructure.LivenessHandler())
+	r.GET("/readyz", Infrastructure.ReadinessHandler(ready))
+
+	// Lets other services verify our tokens without sharing a secret
+	r.GET("/.well-known/jwks.json", Infrastructure.JWKSHandler(tokens.Keys()))
+
 	r.POST("/register", controller.Register)
 	r.POST("/login", controller.Login)
+	r.POST("/login/mfa", controller.LoginMFA)
+	r.POST("/refresh", controller.Refresh)
+
+	// Authenticated routes. They share a group so that the middleware only
+	// runs on them and not on unknown paths.
+	authed := r.Group("/", Infrastructure.AuthMiddleware(tokens, revocations, roles, apiKeys))
+	session := Infrastructure.RequireSession()
+	authed.POST("/logout", session, controller.Logout)
 
-	// Authenticated routes
-	r.Use(Infrastructure.AuthMiddleware(secretKey))
+	// Account routes
+	authed.GET("/me", controller.GetProfile)
+	authed.PATCH("/me", session, controller.UpdateProfile)
+	authed.PUT("/me/password", session, controller.ChangePassword)
+	authed.DELETE("/me", session, controller.DeleteAccount)
+	authed.GET("/me/api-keys", session, controller.ListAPIKeys)
+	authed.POST("/me/api-keys", session, controller.CreateAPIKey)
+	authed.DELETE("/me/api-keys/:id", session, controller.RevokeAPIKey)
+	authed.POST("/me/mfa/totp", session, controller.BeginTOTPEnrollment)
+	authed.POST("/me/mfa/totp/confirm", session, controller.ConfirmTOTPEnrollment)
+	authed.POST("/me/mfa/recovery-codes", session, controller.RegenerateRecoveryCodes)
+	authed.POST("/me/mfa/disable", session, controller.DisableMFA)
 
-	// Task routes
-	r.GET("/tasks", controller.GetTasks)
-	r.GET("/tasks/:id", controller.GetTask)
-	r.POST("/tasks", controller.CreateTask)
-	r.PUT("/tasks/:id", controller.UpdateTask)
-	r.DELETE("/tasks/:id", controller.DeleteTask)
+	// Task routes. The task service narrows reads and writes further to the
+	// scope of the caller's permissions.
+	readTasks := Infrastructure.RequirePermission(Domain.PermTasksReadOwn)
+	writeTasks := Infrastructure.RequirePermission(Domain.PermTasksWriteOwn)
+	authed.GET("/tasks", readTasks, controller.GetTasks)
+	authed.GET("/tasks/:id", readTasks, controller.GetTask)
+	authed.POST("/tasks", writeTasks, controller.CreateTask)
+	authed.PUT("/tasks/:id", writeTasks, controller.UpdateTask)
+	authed.PATCH("/tasks/:id", writeTasks, controller.PatchTask)
+	authed.DELETE("/tasks/:id", writeTasks, controller.DeleteTask)
 
 	// Admin routes
-	r.Use(Infrastructure.AdminMiddleware())
-	r.GET("/admin/users", controller.ListUsers)
-	r.GET("/admin/users/:id", controller.GetUserByID)
-	r.GET("/admin/tasks/user/:user_id", controller.GetTasksByUserID)
+	readUsers := Infrastructure.RequirePermission(Domain.PermUsersRead)
+	manageUsers := Infrastructure.RequirePermission(Domain.PermUsersManage)
+	manageRoles := Infrastructure.RequirePermission(Domain.PermRolesManage)
+	authed.GET("/admin/users", readUsers, controller.ListUsers)
+	authed.GET("/admin/users/:id", readUsers, controller.GetUserByID)
+	authed.PUT("/admin/users/:id/role", manageUsers, controller.SetUserRole)
+	authed.PUT("/admin/users/:id/team", manageUsers, controller.SetUserTeam)
+	authed.DELETE("/admin/users/:id/mfa", manageUsers, controller.ResetUserMFA)
+	authed.POST("/admin/users/:id/disable", manageUsers, controller.DisableUser)
+	authed.POST("/admin/users/:id/enable", manageUsers, controller.EnableUser)
+	authed.DELETE("/admin/users/:id", manageUsers, controller.DeleteUser)
+	authed.GET("/admin/lockouts", manageUsers, controller.ListLockouts)
+	authed.DELETE("/admin/lockouts/:key", manageUsers, controller.ClearLockout)
+	authed.GET("/admin/tasks/user/:user_id", Infrastructure.RequirePermission(Domain.PermTasksReadAny), controller.GetTasksByUserID)
+	authed.GET("/admin/roles", manageRoles, controller.ListRoles)
+	authed.PUT("/admin/roles/:name", manageRoles, controller.SaveRole)
+	authed.PUT("/admin/roles/:name/mfa", manageRoles, controller.SetRoleMFA)
+	authed.DELETE("/admin/roles/:name", manageRoles, controller.DeleteRole)
 }
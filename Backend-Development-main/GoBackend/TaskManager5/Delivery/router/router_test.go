@@ -0,0 +1,310 @@
+package routers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"TaskManager5/Delivery/controllers"
+	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
+	"TaskManager5/Repositories"
+	"TaskManager5/Usecases"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPassword = "correct horse battery"
+
+type testServer struct {
+	router *gin.Engine
+	users  Repositories.UserRepository
+	tokens *Infrastructure.TokenService
+}
+
+func newTestServer(t *testing.T) *testServer {
+	gin.SetMode(gin.TestMode)
+	tasks := Repositories.NewInMemoryTaskRepository()
+	users := Repositories.NewInMemoryUserRepository()
+	tokenStore := Repositories.NewInMemoryTokenRepository()
+	roles := Repositories.NewInMemoryRoleRepository()
+	keys, err := Infrastructure.NewKeyRing(Infrastructure.KeyRingOptions{Algorithm: Infrastructure.AlgEdDSA})
+	require.NoError(t, err)
+	tokens := Infrastructure.NewTokenService(keys)
+
+	userService := Usecases.NewUserService(users, tasks, tokenStore, Repositories.NewInMemoryLoginAttemptRepository(), roles,
+		Repositories.NewInMemoryAPIKeyRepository(), tokens)
+	require.NoError(t, userService.EnsureAdmin(context.Background(), "admin", testPassword))
+	controller := controllers.NewTaskController(Usecases.NewTaskService(tasks, users), userService)
+	router := gin.New()
+	SetupRoutes(router, controller, tokens, tokenStore, roles, userService, readyStore{})
+	return &testServer{router: router, users: users, tokens: tokens}
+}
+
+// readyStore passes every readiness check, as the in-memory repositories
+// need no migrations.
+type readyStore struct{}
+
+func (readyStore) Ready(ctx context.Context) error { return nil }
+
+func (s *testServer) do(method, path, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *testServer) login(t *testing.T, username string) Domain.TokenPair {
+	w := s.do("POST", "/login", "", `{"username":"`+username+`","password":"`+testPassword+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var pair Domain.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pair))
+	return pair
+}
+
+// Every route is called as an admin with a plausible body, and no response
+// may carry a password field or the stored hash.
+func TestNoEndpointEmitsPasswords(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+
+	var bodies []string
+	record := func(w *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+		bodies = append(bodies, w.Body.String())
+		return w
+	}
+
+	w := record(s.do("POST", "/register", "", `{"username":"alice","password":"`+testPassword+`"}`))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alice, err := s.users.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	require.NotEmpty(t, alice.Password)
+
+	pair := s.login(t, "admin")
+	w = record(s.do("POST", "/tasks", pair.AccessToken, `{"title":"Audit"}`))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var task Domain.Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+
+	bodyFor := map[string]string{
+		"POST /register":             `{"username":"bob","password":"` + testPassword + `"}`,
+		"POST /login":                `{"username":"alice","password":"` + testPassword + `"}`,
+		"POST /refresh":              `{"refresh_token":"` + pair.RefreshToken + `"}`,
+		"POST /tasks":                `{"title":"Another"}`,
+		"PUT /tasks/:id":             `{"title":"Audit","status":"in_progress"}`,
+		"PATCH /tasks/:id":           `{"description":"checked"}`,
+		"DELETE /tasks/:id":          ``,
+		"PATCH /me":                  `{"username":"root"}`,
+		"PUT /me/password":           `{"current_password":"` + testPassword + `","new_password":"another long password"}`,
+		"PUT /admin/users/:id/role":  `{"role":"admin"}`,
+		"PUT /admin/users/:id/team":  `{"team":"ops"}`,
+		"PUT /admin/roles/:name":     `{"permissions":["tasks:read:any"]}`,
+		"POST /me/api-keys":          `{"name":"ci","scopes":["tasks:read:own"]}`,
+		"POST /login/mfa":            `{"mfa_token":"expired","code":"123456"}`,
+		"PUT /admin/roles/:name/mfa": `{"required":false}`,
+	}
+	params := strings.NewReplacer(":user_id", alice.ID.Hex(), ":name", "auditor", "/admin/users/:id", "/admin/users/"+alice.ID.Hex(), ":id", task.ID.Hex())
+
+	routes := s.router.Routes()
+	// Destructive calls go last so the other routes still have a task and a
+	// valid session to work with.
+	rank := func(r gin.RouteInfo) int {
+		switch {
+		case r.Path == "/logout" || r.Path == "/me/password":
+			return 2
+		case r.Method == "DELETE":
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return rank(routes[i]) < rank(routes[j]) })
+
+	for _, route := range routes {
+		token := pair.AccessToken
+		if route.Path == "/refresh" {
+			token = ""
+		}
+		w := record(s.do(route.Method, params.Replace(route.Path), token, bodyFor[route.Method+" "+route.Path]))
+		assert.Less(t, w.Code, http.StatusInternalServerError, "%s %s: %s", route.Method, route.Path, w.Body.String())
+	}
+
+	for _, body := range bodies {
+		assert.NotContains(t, strings.ToLower(body), `"password"`)
+		assert.NotContains(t, body, alice.Password)
+		assert.NotContains(t, body, "$2a$")
+	}
+}
+
+// Routes check the permissions of the caller's role, and role changes
+// apply to tokens already issued.
+func TestRoutePermissions(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do("POST", "/register", "", `{"username":"alice","password":"`+testPassword+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	admin, alice := s.login(t, "admin"), s.login(t, "alice")
+
+	assert.Equal(t, http.StatusForbidden, s.do("GET", "/admin/users", alice.AccessToken, "").Code)
+	assert.Equal(t, http.StatusForbidden, s.do("GET", "/admin/roles", alice.AccessToken, "").Code)
+
+	w = s.do("PUT", "/admin/roles/user", admin.AccessToken, `{"permissions":["tasks:read:own","tasks:write:own","users:read"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, s.do("GET", "/admin/users", alice.AccessToken, "").Code)
+
+	w = s.do("PUT", "/admin/roles/admin", admin.AccessToken, `{"permissions":[]}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"code":"role_built_in"`)
+}
+
+// An API key acts as its owner within its scopes, can't manage keys or
+// credentials, and stops working once revoked.
+func TestAPIKeyAccess(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do("POST", "/register", "", `{"username":"alice","password":"`+testPassword+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	pair := s.login(t, "alice")
+
+	w = s.do("POST", "/me/api-keys", pair.AccessToken, `{"name":"ci","scopes":["tasks:read:own"]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	withKey := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(Infrastructure.APIKeyHeader, created.Key)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, withKey("GET", "/tasks", "").Code)
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/tasks", `{"title":"Nope"}`).Code)
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/me/api-keys", `{"name":"more","scopes":["tasks:read:own"]}`).Code)
+	assert.Equal(t, http.StatusForbidden, withKey("PUT", "/me/password", `{"current_password":"x","new_password":"another long password"}`).Code)
+
+	w = s.do("GET", "/me/api-keys", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"last_used_at"`)
+	assert.NotContains(t, w.Body.String(), created.Key)
+
+	w = s.do("DELETE", "/me/api-keys/"+created.ID, pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/tasks", "").Code)
+}
+
+// Once the admin role requires MFA, an admin needs a session opened with a
+// TOTP code, which takes enrolling and then a two-step login.
+func TestMFARequiredForAdmins(t *testing.T) {
+	s := newTestServer(t)
+	pair := s.login(t, "admin")
+
+	w := s.do("PUT", "/admin/roles/admin/mfa", pair.AccessToken, `{"required":true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"require_mfa":true`)
+	w = s.do("GET", "/admin/users", pair.AccessToken, "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"mfa_required"`)
+
+	w = s.do("POST", "/me/mfa/totp", pair.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var enrollment Domain.TOTPEnrollment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/"))
+	code, err := Infrastructure.DefaultTOTP.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	w = s.do("POST", "/me/mfa/totp/confirm", pair.AccessToken, `{"code":"`+code+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"recovery_codes"`)
+
+	w = s.do("POST", "/login", "", `{"username":"admin","password":"`+testPassword+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var challenge Domain.MFAChallenge
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+	require.True(t, challenge.MFARequired)
+	assert.NotContains(t, w.Body.String(), "access_token")
+
+	w = s.do("GET", "/admin/users", challenge.MFAToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the MFA token is no access token")
+	code, err = Infrastructure.DefaultTOTP.Code(enrollment.Secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	w = s.do("POST", "/login/mfa", "", `{"mfa_token":"`+challenge.MFAToken+`","code":"`+code+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var mfaPair Domain.TokenPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &mfaPair))
+
+	w = s.do("GET", "/admin/users", mfaPair.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"mfa_enabled":true`)
+}
+
+// The JWKS is public and lists the key the tokens name in their kid header.
+func TestJWKS(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do("GET", "/.well-known/jwks.json", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var set Infrastructure.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 1)
+	key := set.Keys[0]
+	assert.Equal(t, "OKP", key.KeyType)
+	assert.Equal(t, "Ed25519", key.Curve)
+	assert.Equal(t, Infrastructure.AlgEdDSA, key.Algorithm)
+	assert.Equal(t, s.tokens.Keys().Current().ID, key.ID)
+	assert.NotContains(t, w.Body.String(), `"d"`, "no private key material")
+}
+
+// The probes are public, so the orchestrator needs no credentials.
+func TestProbes(t *testing.T) {
+	s := newTestServer(t)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		w := s.do("GET", path, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+// Unknown paths are not found even without a token, while known routes
+// still ask for one.
+func TestUnknownRouteNeedsNoToken(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do("GET", "/no/such/route", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"code":"route_not_found"`)
+
+	assert.Equal(t, http.StatusUnauthorized, s.do("GET", "/tasks", "", "").Code)
+}
+
+// Wrong current passwords count against the login throttle, so a stolen
+// session can't be used to guess the password.
+func TestChangePasswordThrottled(t *testing.T) {
+	s := newTestServer(t)
+	w := s.do("POST", "/register", "", `{"username":"alice","password":"`+testPassword+`"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	alice := s.login(t, "alice")
+
+	wrong := `{"current_password":"wrong password","new_password":"brand new password"}`
+	for i := 0; i <= Domain.DefaultUserLockoutPolicy.FreeFailures; i++ {
+		w = s.do("PUT", "/me/password", alice.AccessToken, wrong)
+		require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	}
+
+	right := `{"current_password":"` + testPassword + `","new_password":"brand new password"}`
+	w = s.do("PUT", "/me/password", alice.AccessToken, right)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	w = s.do("POST", "/login", "", `{"username":"alice","password":"`+testPassword+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the lock is shared with login")
+}
@@ -0,0 +1,35 @@
+package Domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultAPIKeyTTL = 90 * 24 * time.Hour
+	MaxAPIKeyTTL     = 365 * 24 * time.Hour
+)
+
+// APIKey lets a program act for its owner without logging in. Only a hash
+// of the key's secret is stored: the key itself is shown once, when it is
+// created. Scopes limit the key to some of its owner's permissions; the
+// owner's role still applies, so a key never grants more than its owner has.
+// MFA records whether the key was created in a session with a second factor.
+type APIKey struct {
+	ID         string             `bson:"_id" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name"`
+	SecretHash string             `bson:"secret_hash" json:"-"`
+	Scopes     []Permission       `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	MFA        bool               `bson:"mfa" json:"mfa"`
+}
+
+// Active reports whether the key can still be used.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
@@ -6,19 +6,24 @@ import (
 )
 
 type Task struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Title       string             `bson:"title" json:"title"`
-	Description string             `bson:"description" json:"description"`
-	DueDate     time.Time          `bson:"due_date" json:"due_date"`
-	Status      string             `bson:"status" json:"status"`
-	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title         string             `bson:"title" json:"title"`
+	Description   string             `bson:"description" json:"description"`
+	DueDate       time.Time          `bson:"due_date" json:"due_date"`
+	Status        TaskStatus         `bson:"status" json:"status"`
+	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	StatusHistory []StatusChange     `bson:"status_history,omitempty" json:"status_history,omitempty"`
+	Version       int64              `bson:"version" json:"version"`
 }
 
 type User struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Username string             `bson:"username" json:"username"`
-	Password string             `bson:"password" json:"password"`
+	Password string             `bson:"password" json:"-"`
 	Role     string             `bson:"role" json:"role"`
+	Team     string             `bson:"team,omitempty" json:"team,omitempty"`
+	Disabled bool               `bson:"disabled" json:"disabled"`
+	MFA      MFA                `bson:"mfa" json:"-"`
 }
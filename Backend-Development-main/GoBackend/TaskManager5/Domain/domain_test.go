@@ -1,6 +1,7 @@
 package Domain
 
 import(
+	"errors"
 	"testing"
 	"time"
 
@@ -18,7 +19,7 @@ func TestTaskInitialization(t *testing.T){
 		Title:       "Sample Task",
 		Description: "This is a sample task.",
 		DueDate:     now,
-		Status:      "Pending",
+		Status:      StatusTodo,
 		UserID:      userID,
 		CreatedAt:   now,
 		UpdatedAt:   now,
@@ -27,7 +28,7 @@ func TestTaskInitialization(t *testing.T){
 	assert.Equal(t, "Sample Task", task.Title)
 	assert.Equal(t, "This is a sample task.", task.Description)
 	assert.Equal(t, now, task.DueDate)
-	assert.Equal(t, "Pending", task.Status)
+	assert.Equal(t, StatusTodo, task.Status)
 	assert.Equal(t, userID, task.UserID)
 	assert.Equal(t, now, task.CreatedAt)
 	assert.Equal(t, now, task.UpdatedAt)
@@ -46,4 +47,129 @@ func TestUserrInitialization (t *testing.T){
 	assert.Equal(t, "john_doe", user.Username)
 	assert.Equal(t, "securepassword", user.Password)
 	assert.Equal(t, "admin", user.Role)
-}
\ No newline at end of file
+}
+func TestParseTaskStatus(t *testing.T) {
+	cases := map[string]TaskStatus{
+		"todo":        StatusTodo,
+		"In Progress": StatusInProgress,
+		"in-progress": StatusInProgress,
+		"BLOCKED":     StatusBlocked,
+		" done ":      StatusDone,
+		"Pending":     StatusTodo,
+		"Completed":   StatusDone,
+	}
+	for input, expected := range cases {
+		status, err := ParseTaskStatus(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, status, input)
+	}
+
+	_, err := ParseTaskStatus("someday")
+	assert.ErrorIs(t, err, ErrInvalidStatus)
+}
+
+func TestTaskTransitionTo(t *testing.T) {
+	actor := primitive.NewObjectID()
+	now := time.Now()
+	task := Task{Status: StatusTodo}
+
+	assert.NoError(t, task.TransitionTo(StatusInProgress, actor, now))
+	assert.NoError(t, task.TransitionTo(StatusInProgress, actor, now))
+	assert.NoError(t, task.TransitionTo(StatusDone, actor, now))
+	assert.Equal(t, StatusDone, task.Status)
+	assert.Equal(t, []StatusChange{
+		{From: StatusTodo, To: StatusInProgress, Actor: actor, At: now},
+		{From: StatusInProgress, To: StatusDone, Actor: actor, At: now},
+	}, task.StatusHistory)
+
+	err := task.TransitionTo(StatusBlocked, actor, now)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+	assert.Equal(t, StatusDone, task.Status)
+
+	assert.NoError(t, task.TransitionTo(StatusArchived, actor, now))
+	assert.ErrorIs(t, task.TransitionTo(StatusTodo, actor, now), ErrInvalidTransition)
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	assert.Equal(t, "alice", NormalizeUsername("Alice"))
+	assert.Equal(t, "alice", NormalizeUsername("  ALICE "))
+	assert.Equal(t, NormalizeUsername("Alice"), NormalizeUsername("alice"))
+}
+
+func TestLockoutPolicy(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeFailures: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		MaxFailures:  7,
+		Lockout:      time.Hour,
+		ResetAfter:   24 * time.Hour,
+	}
+	start := time.Now()
+	attempts := LoginAttempts{Key: "user:alice"}
+
+	// Each failure happens once the previous lock has run out.
+	wantLocks := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Hour}
+	at := start
+	for i, want := range wantLocks {
+		at = at.Add(5 * time.Second)
+		policy.RecordFailure(&attempts, at)
+		assert.Equal(t, i+1, attempts.Failures)
+		assert.Equal(t, want, attempts.RetryAfter(at), "failure %d", i+1)
+	}
+	assert.Equal(t, at.Add(24*time.Hour), attempts.ExpiresAt)
+
+	// Once the record expires the count starts over.
+	at = attempts.ExpiresAt
+	policy.RecordFailure(&attempts, at)
+	assert.Equal(t, 1, attempts.Failures)
+	assert.Zero(t, attempts.RetryAfter(at))
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:  10,
+		MinClasses: 3,
+		Blocklist:  PasswordBlocklist{"correcthorse1!": {}},
+	}
+	rules := func(err error) []string {
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			return nil
+		}
+		var names []string
+		for _, f := range invalid.Fields {
+			assert.Equal(t, "password", f.Field)
+			names = append(names, f.Rule)
+		}
+		return names
+	}
+
+	assert.NoError(t, policy.Check("password", "alice", "Tr0ub4dor&3"))
+	assert.Equal(t, []string{"min", "classes"}, rules(policy.Check("password", "alice", "short")))
+	assert.Equal(t, []string{"username"}, rules(policy.Check("password", "Alice", "xxALICE99xx")))
+	assert.Equal(t, []string{"blocklist"}, rules(policy.Check("password", "alice", "CorrectHorse1!")))
+	assert.ErrorIs(t, policy.Check("password", "alice", "short"), ErrValidation)
+
+	// Under a byte limit, as for bcrypt, multi-byte characters count more.
+	policy = PasswordPolicy{MinLength: 10, MaxLength: 20, MaxBytes: 24}
+	assert.NoError(t, policy.Check("password", "alice", "Tr0ub4dor&3Tr0ub4dor"))
+	assert.Equal(t, []string{"max"}, rules(policy.Check("password", "alice", "Tr0ub4dor&3Tr0ub4dor&3")))
+	assert.Equal(t, []string{"max"}, rules(policy.Check("password", "alice", "Tr0ub4dor&3ééééééé")))
+}
+
+func TestPermissionImplies(t *testing.T) {
+	assert.True(t, PermTasksReadAny.Implies(PermTasksReadOwn))
+	assert.True(t, PermTasksReadTeam.Implies(PermTasksReadOwn))
+	assert.True(t, PermTasksReadTeam.Implies(PermTasksReadTeam))
+	assert.False(t, PermTasksReadOwn.Implies(PermTasksReadTeam))
+	assert.False(t, PermTasksReadAny.Implies(PermTasksWriteOwn))
+	assert.False(t, PermUsersManage.Implies(PermUsersRead))
+
+	manager := Principal{Role: RoleManager, Permissions: DefaultRoles()[1].Permissions}
+	assert.True(t, manager.Can(PermTasksReadOwn))
+	assert.True(t, manager.Can(PermTasksReadTeam))
+	assert.False(t, manager.Can(PermTasksReadAny))
+	assert.False(t, manager.Can(PermTasksWriteAny))
+	assert.False(t, Principal{}.Can(PermTasksReadOwn))
+}
@@ -0,0 +1,120 @@
+package Domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies domain errors so the delivery layer can translate
+// them without knowing every individual error.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindInvalidInput
+	KindValidation
+	KindConflict
+	KindPreconditionFailed
+	KindUnsupportedMediaType
+	KindUnauthorized
+	KindForbidden
+	KindTooManyRequests
+	KindUnavailable
+)
+
+// Error is a domain error with a stable, machine-readable code. Wrap one
+// with fmt.Errorf("%w: ...") to add detail; errors.Is and errors.As still
+// find it.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+}
+
+func NewError(kind ErrorKind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrInvalidID          = NewError(KindInvalidInput, "invalid_id", "invalid id")
+	ErrInvalidRequest     = NewError(KindInvalidInput, "invalid_request", "invalid request")
+	ErrTaskNotFound       = NewError(KindNotFound, "task_not_found", "task not found")
+	ErrUserNotFound       = NewError(KindNotFound, "user_not_found", "user not found")
+	ErrInvalidCredentials = NewError(KindUnauthorized, "invalid_credentials", "invalid username or password")
+	ErrUnauthenticated    = NewError(KindUnauthorized, "unauthenticated", "authentication required")
+	ErrInvalidToken       = NewError(KindUnauthorized, "invalid_token", "invalid or expired token")
+	ErrTokenRevoked       = NewError(KindUnauthorized, "token_revoked", "token has been revoked")
+	ErrTokenNotFound      = NewError(KindNotFound, "token_not_found", "token not found")
+	ErrTokenReused        = NewError(KindUnauthorized, "token_reused", "refresh token has already been used")
+	ErrInvalidMFACode     = NewError(KindUnauthorized, "invalid_mfa_code", "invalid or already used authentication code")
+	ErrMFARequired        = NewError(KindForbidden, "mfa_required", "your role requires multi-factor authentication")
+	ErrMFAEnabled         = NewError(KindConflict, "mfa_enabled", "multi-factor authentication is already enabled")
+	ErrMFANotEnabled      = NewError(KindConflict, "mfa_not_enabled", "multi-factor authentication is not enabled")
+	ErrInvalidAPIKey      = NewError(KindUnauthorized, "invalid_api_key", "invalid, expired or revoked API key")
+	ErrAPIKeyNotFound     = NewError(KindNotFound, "api_key_not_found", "API key not found")
+	ErrForbidden          = NewError(KindForbidden, "forbidden", "access forbidden")
+	ErrInvalidPatch       = NewError(KindInvalidInput, "invalid_patch", "invalid patch")
+	ErrPatchTestFailed    = NewError(KindConflict, "patch_test_failed", "patch test failed")
+	ErrUnsupportedPatch   = NewError(KindUnsupportedMediaType, "unsupported_patch_type", "unsupported patch content type")
+	ErrInvalidTask        = NewError(KindValidation, "invalid_task", "invalid task")
+	ErrValidation         = NewError(KindValidation, "validation_failed", "validation failed")
+	ErrVersionMismatch    = NewError(KindPreconditionFailed, "version_mismatch", "task has been modified since it was read")
+	ErrAccountDisabled    = NewError(KindForbidden, "account_disabled", "account is disabled")
+	ErrWrongPassword      = NewError(KindForbidden, "wrong_password", "current password is incorrect")
+	ErrInvalidRole        = NewError(KindValidation, "invalid_role", "unknown role")
+	ErrRoleNotFound       = NewError(KindNotFound, "role_not_found", "role not found")
+	ErrRoleBuiltIn        = NewError(KindConflict, "role_built_in", "built-in roles cannot be deleted and the admin role cannot be changed")
+	ErrRoleInUse          = NewError(KindConflict, "role_in_use", "role is still held by users")
+	ErrLastAdmin          = NewError(KindConflict, "last_admin", "the last active admin cannot be demoted, disabled or deleted")
+	ErrUserHasTasks       = NewError(KindConflict, "user_has_tasks", "user still owns tasks")
+	ErrUsernameTaken      = NewError(KindConflict, "username_taken", "username is already taken")
+	ErrTooManyAttempts    = NewError(KindTooManyRequests, "too_many_attempts", "too many failed login attempts")
+	ErrTimeout            = NewError(KindUnavailable, "timeout", "the request took too long, try again later")
+	ErrNotReady           = NewError(KindUnavailable, "not_ready", "the service is not ready to take requests")
+)
+
+// FieldError describes one invalid field of a request. Rule names the
+// check that failed, such as "required" or "max".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a request. It unwraps to
+// ErrValidation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Field + " " + f.Message
+	}
+	return ErrValidation.Message + ": " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// ThrottledError rejects a request that may be retried once RetryAfter has
+// passed. It unwraps to ErrTooManyAttempts.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrTooManyAttempts.Message, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
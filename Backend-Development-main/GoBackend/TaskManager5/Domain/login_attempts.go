@@ -0,0 +1,99 @@
+package Domain
+
+import "time"
+
+// LoginAttempts counts the failed logins for one key, which is either a
+// username or a client address. The record can be forgotten once
+// ExpiresAt has passed.
+type LoginAttempts struct {
+	Key         string    `bson:"_id" json:"key"`
+	Failures    int       `bson:"failures" json:"failures"`
+	LastFailure time.Time `bson:"last_failure" json:"last_failure"`
+	LockedUntil time.Time `bson:"locked_until" json:"locked_until"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+func UsernameAttemptKey(username string) string {
+	return "user:" + NormalizeUsername(username)
+}
+
+func ClientAttemptKey(clientIP string) string {
+	return "ip:" + clientIP
+}
+
+// RetryAfter returns how much longer the key is locked at now, or 0.
+func (a LoginAttempts) RetryAfter(now time.Time) time.Duration {
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// LockoutPolicy decides how long a key is locked after a failed login. The
+// first FreeFailures failures cost nothing. Each one after that locks the
+// key for BaseDelay, doubled for every further failure up to MaxDelay, and
+// reaching MaxFailures locks it for Lockout. Failures are forgotten once
+// ResetAfter passes without another one.
+type LockoutPolicy struct {
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxFailures  int
+	Lockout      time.Duration
+	ResetAfter   time.Duration
+}
+
+// Usernames are locked out quickly. Client addresses get more slack, since
+// many users can share one behind a NAT or proxy.
+var (
+	DefaultUserLockoutPolicy = LockoutPolicy{
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  10,
+		Lockout:      15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+	DefaultClientLockoutPolicy = LockoutPolicy{
+		FreeFailures: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		MaxFailures:  100,
+		Lockout:      15 * time.Minute,
+		ResetAfter:   time.Hour,
+	}
+)
+
+// RecordFailure counts a failed login made at the given time.
+func (p LockoutPolicy) RecordFailure(a *LoginAttempts, at time.Time) {
+	if !a.ExpiresAt.IsZero() && !at.Before(a.ExpiresAt) {
+		a.Failures = 0
+		a.LockedUntil = time.Time{}
+	}
+	a.Failures++
+	a.LastFailure = at
+	if lock := p.lockFor(a.Failures); lock > 0 && at.Add(lock).After(a.LockedUntil) {
+		a.LockedUntil = at.Add(lock)
+	}
+	a.ExpiresAt = at.Add(p.ResetAfter)
+	if a.LockedUntil.After(a.ExpiresAt) {
+		a.ExpiresAt = a.LockedUntil
+	}
+}
+
+func (p LockoutPolicy) lockFor(failures int) time.Duration {
+	if p.MaxFailures > 0 && failures >= p.MaxFailures {
+		return p.Lockout
+	}
+	if failures <= p.FreeFailures {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,28 @@
+package Domain
+
+// MFA is a user's second factor. A TOTP secret waits in PendingSecret until
+// the user proves their app has it, and only then becomes TOTPSecret.
+// LastStep is the time step of the last accepted code, so that no code
+// works twice. RecoveryCodes are hashes of the unused recovery codes.
+type MFA struct {
+	Enabled       bool     `bson:"enabled"`
+	TOTPSecret    string   `bson:"totp_secret,omitempty"`
+	PendingSecret string   `bson:"pending_secret,omitempty"`
+	LastStep      int64    `bson:"last_step,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
+}
+
+// TOTPEnrollment is what an authenticator app needs to add an account.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAChallenge is what a login returns instead of a TokenPair when the
+// account has a second factor. The client exchanges MFAToken together with
+// a code for the token pair.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
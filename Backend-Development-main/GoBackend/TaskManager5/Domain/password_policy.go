@@ -0,0 +1,87 @@
+package Domain
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordBlocklist holds common or breached passwords, lowercased.
+type PasswordBlocklist map[string]struct{}
+
+func (b PasswordBlocklist) Contains(password string) bool {
+	_, blocked := b[strings.ToLower(password)]
+	return blocked
+}
+
+// PasswordPolicy decides which passwords users may choose. MinLength and
+// MaxLength count characters. MaxBytes, if set, also limits the encoded
+// length, for hashes such as bcrypt that ignore whatever comes after it.
+// MinClasses is how many of lowercase letters, uppercase letters, digits
+// and symbols a password has to mix. A password may never contain the
+// username.
+type PasswordPolicy struct {
+	MinLength  int
+	MaxLength  int
+	MaxBytes   int
+	MinClasses int
+	Blocklist  PasswordBlocklist
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8, MaxLength: 128, MinClasses: 1}
+
+// Check returns a *ValidationError naming field for every rule the password
+// breaks, or nil.
+func (p PasswordPolicy) Check(field, username, password string) error {
+	var problems []FieldError
+	if length := utf8.RuneCountInString(password); length < p.MinLength {
+		problems = append(problems, FieldError{Field: field, Rule: "min",
+			Message: fmt.Sprintf("must be at least %d characters", p.MinLength)})
+	} else if p.MaxLength > 0 && length > p.MaxLength {
+		problems = append(problems, FieldError{Field: field, Rule: "max",
+			Message: fmt.Sprintf("must be at most %d characters", p.MaxLength)})
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, FieldError{Field: field, Rule: "max",
+			Message: fmt.Sprintf("must be at most %d bytes", p.MaxBytes)})
+	}
+	if passwordClasses(password) < p.MinClasses {
+		problems = append(problems, FieldError{Field: field, Rule: "classes",
+			Message: fmt.Sprintf("must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)})
+	}
+	if username = NormalizeUsername(username); username != "" && strings.Contains(strings.ToLower(password), username) {
+		problems = append(problems, FieldError{Field: field, Rule: "username",
+			Message: "must not contain the username"})
+	}
+	if p.Blocklist.Contains(password) {
+		problems = append(problems, FieldError{Field: field, Rule: "blocklist",
+			Message: "is too common or has appeared in a data breach"})
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	return classes
+}
@@ -0,0 +1,112 @@
+package Domain
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Permission names something a role allows, as resource:action or, for
+// tasks, resource:action:scope. A scope also grants the narrower ones:
+// tasks:read:any implies tasks:read:team, which implies tasks:read:own.
+type Permission string
+
+const (
+	PermTasksReadOwn  Permission = "tasks:read:own"
+	PermTasksReadTeam Permission = "tasks:read:team"
+	PermTasksReadAny  Permission = "tasks:read:any"
+	PermTasksWriteOwn Permission = "tasks:write:own"
+	PermTasksWriteAny Permission = "tasks:write:any"
+	PermUsersRead     Permission = "users:read"
+	PermUsersManage   Permission = "users:manage"
+	PermRolesManage   Permission = "roles:manage"
+)
+
+// AllPermissions lists every permission a role can be given.
+var AllPermissions = []Permission{
+	PermTasksReadOwn, PermTasksReadTeam, PermTasksReadAny,
+	PermTasksWriteOwn, PermTasksWriteAny,
+	PermUsersRead, PermUsersManage, PermRolesManage,
+}
+
+func ValidPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+var scopeRank = map[string]int{"own": 1, "team": 2, "any": 3}
+
+// Implies reports whether holding p grants want.
+func (p Permission) Implies(want Permission) bool {
+	if p == want {
+		return true
+	}
+	i, j := strings.LastIndex(string(p), ":"), strings.LastIndex(string(want), ":")
+	if i < 0 || j < 0 || p[:i] != want[:j] {
+		return false
+	}
+	have, wanted := scopeRank[string(p[i+1:])], scopeRank[string(want[j+1:])]
+	return have > 0 && wanted > 0 && have >= wanted
+}
+
+// Role is a named set of permissions. Users hold exactly one role. Holders
+// of a role with RequireMFA only get its permissions after logging in with
+// a second factor.
+type Role struct {
+	Name        string       `bson:"_id" json:"name"`
+	Permissions []Permission `bson:"permissions" json:"permissions"`
+	RequireMFA  bool         `bson:"require_mfa" json:"require_mfa"`
+}
+
+func (r Role) Has(want Permission) bool {
+	return HasPermission(r.Permissions, want)
+}
+
+func HasPermission(permissions []Permission, want Permission) bool {
+	for _, p := range permissions {
+		if p.Implies(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictPermissions returns the scopes that granted covers, for callers
+// that must not exceed either.
+func RestrictPermissions(granted, scopes []Permission) []Permission {
+	var allowed []Permission
+	for _, scope := range scopes {
+		if HasPermission(granted, scope) {
+			allowed = append(allowed, scope)
+		}
+	}
+	return allowed
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// ValidRoleName reports whether name can name a role: up to 32 lowercase
+// letters, digits, dashes and underscores, starting with a letter.
+func ValidRoleName(name string) bool {
+	return roleNamePattern.MatchString(name)
+}
+
+// BuiltInRole reports whether the role comes with the server. Built-in roles
+// can't be deleted, and the admin role can't be changed either, so that
+// somebody can always administer the server.
+func BuiltInRole(name string) bool {
+	return name == RoleAdmin || name == RoleManager || name == RoleUser
+}
+
+// DefaultRoles returns the roles a new store starts with. Managers see the
+// tasks of everybody in their team but only change their own.
+func DefaultRoles() []Role {
+	return []Role{
+		{Name: RoleAdmin, Permissions: append([]Permission(nil), AllPermissions...)},
+		{Name: RoleManager, Permissions: []Permission{PermTasksReadTeam, PermTasksWriteOwn, PermUsersRead}},
+		{Name: RoleUser, Permissions: []Permission{PermTasksReadOwn, PermTasksWriteOwn}},
+	}
+}
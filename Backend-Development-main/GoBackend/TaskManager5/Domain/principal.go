@@ -0,0 +1,41 @@
+package Domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleManager = "manager"
+	RoleUser    = "user"
+)
+
+// Principal is the authenticated caller a usecase acts on behalf of.
+// TokenID, SessionID and ExpiresAt describe the access token it presented.
+// Permissions are those of the role when the request was made, so that
+// changes to a role apply to tokens already issued. A caller using an API
+// key has APIKeyID set instead, and only the permissions among Scopes.
+// MFA says whether the caller gave a second factor. MFARequired is set when
+// the role demands one the caller didn't give; such a caller has no
+// permissions until they enroll and log in again.
+type Principal struct {
+	UserID      primitive.ObjectID
+	Username    string
+	Role        string
+	Permissions []Permission
+	TokenID     string
+	SessionID   string
+	ExpiresAt   time.Time
+	APIKeyID    string
+	Scopes      []Permission
+	MFA         bool
+	MFARequired bool
+}
+
+// Can reports whether the principal's role grants want, directly or through
+// a broader scope.
+func (p Principal) Can(want Permission) bool {
+	return HasPermission(p.Permissions, want)
+}
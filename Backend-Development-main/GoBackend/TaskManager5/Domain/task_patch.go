@@ -0,0 +1,17 @@
+package Domain
+
+import "time"
+
+// TaskPatch lists the fields a partial update changes; nil fields are left
+// as they are. StatusChange, when set, is appended to the status history.
+type TaskPatch struct {
+	Title        *string
+	Description  *string
+	DueDate      *time.Time
+	Status       *TaskStatus
+	StatusChange *StatusChange
+}
+
+func (p TaskPatch) IsEmpty() bool {
+	return p.Title == nil && p.Description == nil && p.DueDate == nil && p.Status == nil
+}
@@ -0,0 +1,59 @@
+package Domain
+
+import "time"
+
+const (
+	DefaultTaskPageSize = 50
+	MaxTaskPageSize     = 200
+	DefaultTaskSort     = "created_at"
+)
+
+// TaskSortFields lists the fields a task listing can be ordered by.
+var TaskSortFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"due_date":   true,
+	"title":      true,
+	"status":     true,
+}
+
+var ErrInvalidCursor = NewError(KindInvalidInput, "invalid_cursor", "invalid page cursor")
+
+// TaskFilter narrows, orders and pages a task listing. Zero values mean
+// "no constraint". DueAfter is inclusive and DueBefore is exclusive.
+// Owners, unless nil, restricts the listing to tasks of those users; it is
+// how usecases scope what a caller can see, and UserID still applies.
+type TaskFilter struct {
+	Status    TaskStatus
+	DueAfter  *time.Time
+	DueBefore *time.Time
+	UserID    string
+	Owners    []string
+	Search    string
+	SortBy    string
+	SortDesc  bool
+	Limit     int
+	Cursor    string
+}
+
+// WithDefaults returns a copy of the filter with the sort field and page
+// size filled in and the page size capped at MaxTaskPageSize.
+func (f TaskFilter) WithDefaults() TaskFilter {
+	if f.SortBy == "" {
+		f.SortBy = DefaultTaskSort
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultTaskPageSize
+	}
+	if f.Limit > MaxTaskPageSize {
+		f.Limit = MaxTaskPageSize
+	}
+	return f
+}
+
+// TaskPage is one page of a task listing. NextCursor is empty on the last page.
+type TaskPage struct {
+	Tasks      []Task `json:"tasks"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      int64  `json:"total"`
+}
@@ -0,0 +1,48 @@
+package Domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The limits on task fields. The request DTOs state the same limits in
+// their binding tags; ValidateTask applies them where no DTO is involved,
+// such as to the result of a PATCH.
+const (
+	MaxTaskTitleLength       = 200
+	MaxTaskDescriptionLength = 5000
+)
+
+var (
+	EarliestDueDate = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	LatestDueDate   = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// ValidDueDate reports whether t lies in the range due dates may take.
+func ValidDueDate(t time.Time) bool {
+	return !t.Before(EarliestDueDate) && t.Before(LatestDueDate)
+}
+
+// ValidateTask checks the title, description and due date of a task, a
+// zero due date meaning none, and lists every field that breaks the rules
+// in a *ValidationError.
+func ValidateTask(task Task) error {
+	var fields []FieldError
+	if strings.TrimSpace(task.Title) == "" {
+		fields = append(fields, FieldError{Field: "title", Rule: "notblank", Message: "must not be blank"})
+	} else if utf8.RuneCountInString(task.Title) > MaxTaskTitleLength {
+		fields = append(fields, FieldError{Field: "title", Rule: "max", Message: fmt.Sprintf("must be at most %d characters", MaxTaskTitleLength)})
+	}
+	if utf8.RuneCountInString(task.Description) > MaxTaskDescriptionLength {
+		fields = append(fields, FieldError{Field: "description", Rule: "max", Message: fmt.Sprintf("must be at most %d characters", MaxTaskDescriptionLength)})
+	}
+	if !task.DueDate.IsZero() && !ValidDueDate(task.DueDate) {
+		fields = append(fields, FieldError{Field: "due_date", Rule: "duedate", Message: "must be between 2000 and 2100"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
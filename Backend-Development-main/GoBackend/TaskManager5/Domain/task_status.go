@@ -0,0 +1,113 @@
+package Domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TaskStatus string
+
+const (
+	StatusTodo       TaskStatus = "todo"
+	StatusInProgress TaskStatus = "in_progress"
+	StatusBlocked    TaskStatus = "blocked"
+	StatusDone       TaskStatus = "done"
+	StatusArchived   TaskStatus = "archived"
+)
+
+// TaskStatuses lists every status, in workflow order.
+var TaskStatuses = []TaskStatus{StatusTodo, StatusInProgress, StatusBlocked, StatusDone, StatusArchived}
+
+// taskTransitions lists the statuses each status may move to. Archived is
+// terminal.
+var taskTransitions = map[TaskStatus][]TaskStatus{
+	StatusTodo:       {StatusInProgress, StatusBlocked, StatusDone, StatusArchived},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusDone, StatusArchived},
+	StatusBlocked:    {StatusTodo, StatusInProgress, StatusArchived},
+	StatusDone:       {StatusInProgress, StatusArchived},
+	StatusArchived:   {},
+}
+
+// legacyStatuses maps the free-form values stored before statuses were
+// validated onto their replacements.
+var legacyStatuses = map[string]TaskStatus{
+	"pending":   StatusTodo,
+	"completed": StatusDone,
+	"complete":  StatusDone,
+}
+
+var (
+	ErrInvalidStatus     = NewError(KindValidation, "invalid_status", "invalid task status")
+	ErrInvalidTransition = NewError(KindConflict, "invalid_transition", "invalid task status transition")
+)
+
+// TransitionError reports a status change the state machine doesn't allow.
+type TransitionError struct {
+	From TaskStatus
+	To   TaskStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move a task from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// ParseTaskStatus accepts a status in any case, with spaces or hyphens in
+// place of underscores, as well as the legacy values.
+func ParseTaskStatus(value string) (TaskStatus, error) {
+	normalized := strings.ToLower(strings.TrimSpace(value))
+	normalized = strings.NewReplacer(" ", "_", "-", "_").Replace(normalized)
+	if status, ok := legacyStatuses[normalized]; ok {
+		return status, nil
+	}
+	status := TaskStatus(normalized)
+	if _, ok := taskTransitions[status]; !ok {
+		return "", fmt.Errorf("%w %q: must be one of todo, in_progress, blocked, done, archived", ErrInvalidStatus, value)
+	}
+	return status, nil
+}
+
+func (s TaskStatus) CanTransitionTo(next TaskStatus) bool {
+	for _, allowed := range taskTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange records one status transition of a task. From is empty for
+// the status a task was created with.
+type StatusChange struct {
+	From  TaskStatus         `bson:"from,omitempty" json:"from,omitempty"`
+	To    TaskStatus         `bson:"to" json:"to"`
+	Actor primitive.ObjectID `bson:"actor" json:"actor"`
+	At    time.Time          `bson:"at" json:"at"`
+}
+
+// TransitionTo moves the task to next, recording who did it and when. Moving
+// to the current status is a no-op. Statuses stored before validation
+// existed are read through ParseTaskStatus, and anything unrecognisable is
+// treated as todo.
+func (t *Task) TransitionTo(next TaskStatus, actor primitive.ObjectID, at time.Time) error {
+	current, err := ParseTaskStatus(string(t.Status))
+	if err != nil {
+		current = StatusTodo
+	}
+	if next == current {
+		t.Status = current
+		return nil
+	}
+	if !current.CanTransitionTo(next) {
+		return &TransitionError{From: current, To: next}
+	}
+	t.Status = next
+	t.StatusHistory = append(t.StatusHistory, StatusChange{From: current, To: next, Actor: actor, At: at})
+	return nil
+}
@@ -0,0 +1,30 @@
+package Domain
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Every
+// token minted by rotating another one shares its SessionID, so the whole
+// token family of a login can be revoked at once. MFA records whether the
+// login used a second factor, which every token of the session carries on.
+type RefreshToken struct {
+	ID         string             `bson:"_id" json:"id"`
+	SessionID  string             `bson:"session_id" json:"session_id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time          `bson:"expires_at" json:"expires_at"`
+	ReplacedBy string             `bson:"replaced_by" json:"replaced_by,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	MFA        bool               `bson:"mfa" json:"mfa"`
+}
+
+// TokenPair is what a successful login or refresh returns to the client.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
@@ -0,0 +1,52 @@
+package Domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeUsername returns the canonical form usernames are stored and
+// looked up in, so that "Alice" and "alice" name the same account.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// UserUpdate changes the non-nil fields of a user. Passwords are changed
+// separately because they have to be hashed.
+type UserUpdate struct {
+	Username *string
+	Role     *string
+	Team     *string
+	Disabled *bool
+}
+
+func (u UserUpdate) IsEmpty() bool {
+	return u.Username == nil && u.Role == nil && u.Team == nil && u.Disabled == nil
+}
+
+// TaskCascade says what happens to the tasks of a user being deleted.
+type TaskCascade string
+
+const (
+	// CascadeRestrict refuses to delete a user who still owns tasks.
+	CascadeRestrict TaskCascade = "restrict"
+	// CascadeDelete deletes the user's tasks along with the account.
+	CascadeDelete TaskCascade = "delete"
+	// CascadeReassign hands the user's tasks to another user.
+	CascadeReassign TaskCascade = "reassign"
+)
+
+func ParseTaskCascade(s string) (TaskCascade, error) {
+	switch cascade := TaskCascade(s); cascade {
+	case CascadeRestrict, CascadeDelete, CascadeReassign:
+		return cascade, nil
+	}
+	return "", fmt.Errorf("%w: unknown task policy %q", ErrInvalidRequest, s)
+}
+
+// UserDeletion describes how to delete a user. ReassignTo names the new
+// owner of the tasks when Tasks is CascadeReassign.
+type UserDeletion struct {
+	Tasks      TaskCascade
+	ReassignTo string
+}
@@ -0,0 +1,58 @@
+package Infrastructure
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyHeader is the request header clients send an API key in.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix marks TaskManager API keys, so that one committed by mistake
+// is easy to spot and to search for.
+const apiKeyPrefix = "tm_"
+
+// NewAPIKey returns a new key as tm_<id>_<secret>, with its id and the hash
+// of its secret, which is all the server keeps.
+func NewAPIKey() (key, id, secretHash string, err error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	return apiKeyPrefix + id + "_" + secret, id, hashSecret(secret), nil
+}
+
+// ParseAPIKey splits a key into its id and secret.
+func ParseAPIKey(key string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(key, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, "_")
+	if !found || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// APIKeySecretMatches reports whether secret hashes to secretHash.
+func APIKeySecretMatches(secret, secretHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(secretHash)) == 1
+}
+
+// hashSecret hashes a random secret for storage. Unlike passwords, such
+// secrets are too long to guess, so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
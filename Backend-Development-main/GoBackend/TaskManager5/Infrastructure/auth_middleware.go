@@ -1,54 +1,154 @@
 package Infrastructure
 
 import (
-	"net/http"
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"TaskManager5/Domain"
+
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(secretKey string) gin.HandlerFunc {
+// RevocationChecker reports whether an access token has been revoked, either
+// by its own id or through its session.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, tokenID, sessionID string) (bool, error)
+}
+
+// RoleSource looks up role definitions, such as a Repositories.RoleRepository.
+type RoleSource interface {
+	GetRole(ctx context.Context, name string) (*Domain.Role, error)
+}
+
+// APIKeyAuthenticator resolves an API key to the principal it acts as.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (Domain.Principal, error)
+}
+
+// AuthMiddleware authenticates the caller by either a bearer access token
+// or an API key in the APIKeyHeader, and records the caller's
+// Domain.Principal, which handlers get back with PrincipalFrom.
+// The principal's permissions are looked up on every request, so changes to
+// a role apply to tokens and keys already issued. A role that no longer
+// exists grants nothing, and an API key only keeps the permissions among
+// its scopes. A role that requires MFA grants nothing to callers who
+// didn't give a second factor; they are marked MFARequired instead.
+// Failures are reported with c.Error for ErrorMiddleware to render.
+func AuthMiddleware(tokens *TokenService, revocations RevocationChecker, roles RoleSource, apiKeys APIKeyAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization header provided"})
+		ctx := c.Request.Context()
+		var principal Domain.Principal
+		var err error
+		authHeader, apiKey := c.GetHeader("Authorization"), c.GetHeader(APIKeyHeader)
+		switch {
+		case authHeader != "" && apiKey != "":
+			err = fmt.Errorf("%w: send either a bearer token or an API key, not both", Domain.ErrUnauthenticated)
+		case apiKey != "":
+			principal, err = apiKeys.AuthenticateAPIKey(ctx, apiKey)
+		case authHeader != "":
+			principal, err = bearerPrincipal(ctx, tokens, revocations, authHeader)
+		default:
+			err = fmt.Errorf("%w: no Authorization or %s header provided", Domain.ErrUnauthenticated, APIKeyHeader)
+		}
+		if err != nil {
+			c.Error(err)
 			c.Abort()
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(secretKey), nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		role, err := roles.GetRole(ctx, principal.Role)
+		switch {
+		case err == nil && role.RequireMFA && !principal.MFA:
+			principal.MFARequired = true
+		case err == nil:
+			principal.Permissions = role.Permissions
+		case !errors.Is(err, Domain.ErrRoleNotFound):
+			c.Error(fmt.Errorf("loading role %q: %w", principal.Role, err))
 			c.Abort()
 			return
 		}
+		if principal.APIKeyID != "" {
+			principal.Permissions = Domain.RestrictPermissions(principal.Permissions, principal.Scopes)
+		}
+
+		SetPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+func bearerPrincipal(ctx context.Context, tokens *TokenService, revocations RevocationChecker, authHeader string) (Domain.Principal, error) {
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := tokens.Parse(tokenString, AccessTokenType)
+	if err != nil {
+		return Domain.Principal{}, err
+	}
+	principal, err := claims.Principal()
+	if err != nil {
+		return Domain.Principal{}, err
+	}
+	revoked, err := revocations.IsRevoked(ctx, principal.TokenID, principal.SessionID)
+	if err != nil {
+		return Domain.Principal{}, fmt.Errorf("checking token revocation: %w", err)
+	}
+	if revoked {
+		tokens.Metrics.ObserveTokenRejected(AccessTokenType, "revoked")
+		return Domain.Principal{}, Domain.ErrTokenRevoked
+	}
+	return principal, nil
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+// RequireSession rejects callers using an API key. Changing credentials and
+// creating keys need somebody who logged in, so that a leaked key can't be
+// turned into a login or into more keys.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, ok := PrincipalFrom(c); !ok || principal.APIKeyID != "" {
+			c.Error(fmt.Errorf("%w: not allowed with an API key", Domain.ErrForbidden))
 			c.Abort()
 			return
 		}
-
-		c.Set("user", claims)
-		c.Set("role", claims["role"]) 
-
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission lets the request through only if the principal's role
+// grants every one of perms. It must run after AuthMiddleware.
+func RequirePermission(perms ...Domain.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access forbidden: Admins only"})
+		principal, ok := PrincipalFrom(c)
+		if ok && principal.MFARequired {
+			c.Error(Domain.ErrMFARequired)
 			c.Abort()
 			return
 		}
+		for _, perm := range perms {
+			if !ok || !principal.Can(perm) {
+				c.Error(fmt.Errorf("%w: requires %s", Domain.ErrForbidden, perm))
+				c.Abort()
+				return
+			}
+		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+const principalKey = "principal"
+
+// SetPrincipal records the authenticated caller on the request context,
+// and for the log lines of the request.
+func SetPrincipal(c *gin.Context, principal Domain.Principal) {
+	c.Set(principalKey, principal)
+	if info := requestInfoFrom(c.Request.Context()); info != nil {
+		info.userID = principal.UserID.Hex()
+		info.apiKeyID = principal.APIKeyID
+	}
+}
+
+// PrincipalFrom returns the caller AuthMiddleware authenticated, if any.
+func PrincipalFrom(c *gin.Context) (Domain.Principal, bool) {
+	value, _ := c.Get(principalKey)
+	principal, ok := value.(Domain.Principal)
+	return principal, ok
+}
@@ -0,0 +1,214 @@
+package Infrastructure
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type revokedTokens map[string]bool
+
+func (r revokedTokens) IsRevoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	return r[tokenID] || r[sessionID], nil
+}
+
+type staticRoles map[string]Domain.Role
+
+func (r staticRoles) GetRole(ctx context.Context, name string) (*Domain.Role, error) {
+	role, ok := r[name]
+	if !ok {
+		return nil, Domain.ErrRoleNotFound
+	}
+	return &role, nil
+}
+
+// fixedAPIKeys maps each known key to the principal it acts as.
+type fixedAPIKeys map[string]Domain.Principal
+
+func (k fixedAPIKeys) AuthenticateAPIKey(ctx context.Context, key string) (Domain.Principal, error) {
+	principal, ok := k[key]
+	if !ok {
+		return Domain.Principal{}, Domain.ErrInvalidAPIKey
+	}
+	return principal, nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	keys, err := NewKeyRing(KeyRingOptions{Algorithm: AlgEdDSA})
+	require.NoError(t, err)
+	tokens := NewTokenService(keys)
+	user := Domain.User{ID: primitive.NewObjectID(), Username: "alice", Role: Domain.RoleUser}
+	revoked := revokedTokens{"revoked-session": true}
+
+	router := gin.New()
+	router.Use(ErrorMiddleware())
+	router.GET("/me", AuthMiddleware(tokens, revoked, staticRoles{}, fixedAPIKeys{}), func(c *gin.Context) {
+		p, _ := PrincipalFrom(c)
+		c.String(http.StatusOK, p.UserID.Hex())
+	})
+	request := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/me", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	access, err := tokens.IssueAccessToken(user, "session", false)
+	assert.NoError(t, err)
+	w := request(access.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, user.ID.Hex(), w.Body.String())
+
+	refresh, err := tokens.IssueRefreshToken(user, "session")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(refresh.Token).Code)
+
+	inRevokedSession, err := tokens.IssueAccessToken(user, "revoked-session", false)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(inRevokedSession.Token).Code)
+
+	revoked[access.ID] = true
+	w = request(access.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"token_revoked"`)
+
+	w = request("")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"code":"unauthenticated"`)
+
+	otherKeys, err := NewKeyRing(KeyRingOptions{Algorithm: AlgEdDSA})
+	require.NoError(t, err)
+	otherKey, err := NewTokenService(otherKeys).IssueAccessToken(user, "session", false)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(otherKey.Token).Code)
+
+	// A token claiming HS256 under our kid, keyed with the public key, must
+	// not verify.
+	current := keys.Current()
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID.Hex(), "jti": "forged", "sid": "session", "typ": AccessTokenType,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = current.ID
+	forgedToken, err := forged.SignedString([]byte(current.public.(ed25519.PublicKey)))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, request(forgedToken).Code)
+}
+
+func TestRequirePermission(t *testing.T) {
+	keys, err := NewKeyRing(KeyRingOptions{Algorithm: AlgEdDSA})
+	require.NoError(t, err)
+	tokens := NewTokenService(keys)
+	roles := staticRoles{
+		"reader": {Name: "reader", Permissions: []Domain.Permission{Domain.PermTasksReadAny}},
+		"writer": {Name: "writer", Permissions: []Domain.Permission{Domain.PermTasksWriteOwn}},
+	}
+
+	router := gin.New()
+	router.Use(ErrorMiddleware(), AuthMiddleware(tokens, revokedTokens{}, roles, fixedAPIKeys{}))
+	router.GET("/tasks", RequirePermission(Domain.PermTasksReadOwn), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	request := func(role string) int {
+		user := Domain.User{ID: primitive.NewObjectID(), Username: "alice", Role: role}
+		access, err := tokens.IssueAccessToken(user, "session", false)
+		require.NoError(t, err)
+		req, _ := http.NewRequest("GET", "/tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+access.Token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, request("reader"), "a broader scope grants the narrower one")
+	assert.Equal(t, http.StatusForbidden, request("writer"))
+	assert.Equal(t, http.StatusForbidden, request("deleted"), "an unknown role grants nothing")
+
+	// Permissions are looked up per request, so a changed role applies to
+	// tokens already issued.
+	roles["writer"] = Domain.Role{Name: "writer", Permissions: []Domain.Permission{Domain.PermTasksReadOwn}}
+	assert.Equal(t, http.StatusNoContent, request("writer"))
+}
+
+// A role that requires MFA grants nothing to a session without it.
+func TestRequirePermissionMFA(t *testing.T) {
+	keys, err := NewKeyRing(KeyRingOptions{Algorithm: AlgEdDSA})
+	require.NoError(t, err)
+	tokens := NewTokenService(keys)
+	roles := staticRoles{Domain.RoleAdmin: {Name: Domain.RoleAdmin, Permissions: []Domain.Permission{Domain.PermUsersRead}, RequireMFA: true}}
+
+	router := gin.New()
+	router.Use(ErrorMiddleware(), AuthMiddleware(tokens, revokedTokens{}, roles, fixedAPIKeys{}))
+	router.GET("/admin/users", RequirePermission(Domain.PermUsersRead), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	request := func(mfa bool) *httptest.ResponseRecorder {
+		user := Domain.User{ID: primitive.NewObjectID(), Username: "root", Role: Domain.RoleAdmin}
+		access, err := tokens.IssueAccessToken(user, "session", mfa)
+		require.NoError(t, err)
+		req, _ := http.NewRequest("GET", "/admin/users", nil)
+		req.Header.Set("Authorization", "Bearer "+access.Token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, request(true).Code)
+	w := request(false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"mfa_required"`)
+}
+
+func TestAuthMiddlewareAPIKey(t *testing.T) {
+	keys, err := NewKeyRing(KeyRingOptions{Algorithm: AlgEdDSA})
+	require.NoError(t, err)
+	tokens := NewTokenService(keys)
+	roles := staticRoles{Domain.RoleUser: {Name: Domain.RoleUser, Permissions: []Domain.Permission{Domain.PermTasksReadOwn, Domain.PermTasksWriteOwn}}}
+	apiKeys := fixedAPIKeys{
+		"tm_ci_secret": {UserID: primitive.NewObjectID(), Role: Domain.RoleUser, APIKeyID: "ci",
+			Scopes: []Domain.Permission{Domain.PermTasksReadOwn, Domain.PermUsersManage}},
+	}
+
+	router := gin.New()
+	router.Use(ErrorMiddleware(), AuthMiddleware(tokens, revokedTokens{}, roles, apiKeys))
+	router.GET("/tasks", func(c *gin.Context) {
+		p, _ := PrincipalFrom(c)
+		c.JSON(http.StatusOK, p.Permissions)
+	})
+	request := func(headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/tasks", nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The key keeps only the scopes its owner's role grants.
+	w := request(map[string]string{APIKeyHeader: "tm_ci_secret"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `["tasks:read:own"]`, w.Body.String())
+
+	w = request(map[string]string{APIKeyHeader: "tm_ci_wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"invalid_api_key"`)
+
+	both := map[string]string{APIKeyHeader: "tm_ci_secret", "Authorization": "Bearer whatever"}
+	assert.Equal(t, http.StatusUnauthorized, request(both).Code)
+}
@@ -0,0 +1,103 @@
+package Infrastructure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Claims are the claims of the access and refresh tokens. Times are Unix
+// seconds. Username, Role and MFA are only set on access tokens.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+	SessionID string   `json:"sid"`
+	Type      string   `json:"typ"`
+	Username  string   `json:"username,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	MFA       bool     `json:"mfa,omitempty"`
+}
+
+// Audience is the "aud" claim, which RFC 7519 allows to be a single string
+// or an array of them.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Valid makes Claims a jwt.Claims. TokenService.Parse checks the claims
+// itself with its clock skew, issuer and audience, so this only applies the
+// time checks without any leeway.
+func (c *Claims) Valid() error {
+	return c.validTimes(time.Now(), 0)
+}
+
+// validTimes checks exp, nbf and iat at now, allowing skew either way for
+// clocks that disagree with the issuer's.
+func (c *Claims) validTimes(now time.Time, skew time.Duration) error {
+	switch {
+	case c.ExpiresAt == 0:
+		return errors.New("token has no expiry")
+	case !now.Add(-skew).Before(time.Unix(c.ExpiresAt, 0)):
+		return errors.New("token has expired")
+	case now.Add(skew).Before(time.Unix(c.NotBefore, 0)):
+		return errors.New("token is not valid yet")
+	case now.Add(skew).Before(time.Unix(c.IssuedAt, 0)):
+		return errors.New("token was issued in the future")
+	}
+	return nil
+}
+
+// Principal returns the caller an access token authenticates.
+func (c *Claims) Principal() (Domain.Principal, error) {
+	userID, err := primitive.ObjectIDFromHex(c.Subject)
+	if err != nil {
+		return Domain.Principal{}, fmt.Errorf("%w: bad subject", Domain.ErrInvalidToken)
+	}
+	return Domain.Principal{
+		UserID:    userID,
+		Username:  c.Username,
+		Role:      c.Role,
+		TokenID:   c.ID,
+		SessionID: c.SessionID,
+		ExpiresAt: time.Unix(c.ExpiresAt, 0),
+		MFA:       c.MFA,
+	}, nil
+}
@@ -0,0 +1,520 @@
+package Infrastructure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the server configuration. It is built from, in increasing order
+// of precedence: built-in defaults, a JSON config file, TASKMANAGER_*
+// environment variables and command-line flags.
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Storage  StorageConfig  `json:"storage"`
+	Auth     AuthConfig     `json:"auth"`
+	Password PasswordConfig `json:"password"`
+	Admin    AdminConfig    `json:"admin"`
+	Logging  LoggingConfig  `json:"logging"`
+}
+
+// ServerConfig holds the listener settings. TrustedProxies lists the
+// addresses or CIDRs whose X-Forwarded-For header is believed; with none,
+// the client address is the peer address. RequestTimeout is the deadline
+// of the work done for one request. On shutdown /readyz fails for
+// DrainDelay, so that load balancers stop sending requests, before requests
+// in flight get ShutdownTimeout to finish. MetricsAddr is
+// a separate listener for /metrics, kept off the public API and by default
+// only reachable from the host itself; empty turns it off.
+type ServerConfig struct {
+	Addr            string   `json:"addr"`
+	MetricsAddr     string   `json:"metrics_addr"`
+	TrustedProxies  []string `json:"trusted_proxies"`
+	RequestTimeout  Duration `json:"request_timeout"`
+	DrainDelay      Duration `json:"drain_delay"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// StorageConfig selects the storage backend. OperationTimeout bounds each
+// MongoDB operation within a request's deadline.
+type StorageConfig struct {
+	Driver           string   `json:"driver"`
+	MongoURI         string   `json:"mongo_uri"`
+	Database         string   `json:"database"`
+	DataDir          string   `json:"data_dir"`
+	OperationTimeout Duration `json:"operation_timeout"`
+}
+
+// AuthConfig holds the token settings. Tokens are signed with
+// SigningAlgorithm: EdDSA or RS256 keys are kept in KeyDir, by default in
+// the user's config directory, and replaced every KeyRotation, while HS256
+// uses the shared JWTSecret. The secret can be given inline or, preferably,
+// as the path of a file containing it. Tokens name Issuer and Audience, and
+// verifiers allow ClockSkew on their times. API keys live for APIKeyTTL
+// unless created with an expiry, which can be at most MaxAPIKeyTTL away.
+// TOTPIssuer names the service in users' authenticator apps.
+type AuthConfig struct {
+	Issuer           string   `json:"issuer"`
+	Audience         string   `json:"audience"`
+	ClockSkew        Duration `json:"clock_skew"`
+	SigningAlgorithm string   `json:"signing_algorithm"`
+	KeyDir           string   `json:"key_dir"`
+	KeyRotation      Duration `json:"key_rotation"`
+	JWTSecret        string   `json:"jwt_secret"`
+	JWTSecretFile    string   `json:"jwt_secret_file"`
+	AccessTokenTTL   Duration `json:"access_token_ttl"`
+	RefreshTokenTTL  Duration `json:"refresh_token_ttl"`
+	APIKeyTTL        Duration `json:"api_key_ttl"`
+	MaxAPIKeyTTL     Duration `json:"max_api_key_ttl"`
+	TOTPIssuer       string   `json:"totp_issuer"`
+}
+
+// KeyRing builds the signing key ring. Retired keys keep verifying for the
+// refresh token lifetime, the longest any token lives.
+func (c AuthConfig) KeyRing() (*KeyRing, error) {
+	if c.SigningAlgorithm == AlgHS256 {
+		return NewHMACKeyRing(c.JWTSecret), nil
+	}
+	return NewKeyRing(KeyRingOptions{
+		Algorithm:   c.SigningAlgorithm,
+		Dir:         c.KeyDir,
+		RotateEvery: time.Duration(c.KeyRotation),
+		Grace:       time.Duration(c.RefreshTokenTTL),
+	})
+}
+
+// PasswordConfig is the policy for new passwords and how they are hashed.
+// BlocklistFile, if set, names a file of common or breached passwords, one
+// per line. Hash picks the algorithm for new hashes, argon2id or bcrypt;
+// users whose stored hash differs are moved to it when they next log in.
+type PasswordConfig struct {
+	MinLength         int    `json:"min_length"`
+	MaxLength         int    `json:"max_length"`
+	MinClasses        int    `json:"min_classes"`
+	BlocklistFile     string `json:"blocklist_file"`
+	Hash              string `json:"hash"`
+	BcryptCost        int    `json:"bcrypt_cost"`
+	Argon2MemoryKiB   int    `json:"argon2_memory_kib"`
+	Argon2Iterations  int    `json:"argon2_iterations"`
+	Argon2Parallelism int    `json:"argon2_parallelism"`
+}
+
+// Policy returns the password policy, without the blocklist, which is
+// loaded separately. Under bcrypt passwords are also limited to the bytes
+// it hashes.
+func (c PasswordConfig) Policy() Domain.PasswordPolicy {
+	policy := Domain.PasswordPolicy{
+		MinLength:  c.MinLength,
+		MaxLength:  c.MaxLength,
+		MinClasses: c.MinClasses,
+	}
+	if c.Hash == "bcrypt" {
+		policy.MaxBytes = BcryptMaxPasswordBytes
+	}
+	return policy
+}
+
+// Hasher returns the hasher for new password hashes.
+func (c PasswordConfig) Hasher() PasswordHasher {
+	if c.Hash == "bcrypt" {
+		return BcryptHasher{Cost: c.BcryptCost}
+	}
+	hasher := DefaultArgon2idHasher
+	hasher.Memory = uint32(c.Argon2MemoryKiB)
+	hasher.Iterations = uint32(c.Argon2Iterations)
+	hasher.Parallelism = uint8(c.Argon2Parallelism)
+	return hasher
+}
+
+// AdminConfig names the admin account created on start if it does not
+// exist yet, which is how a new installation gets its first admin. The
+// password can be given inline or as the path of a file containing it, and
+// only matters when the account is created.
+type AdminConfig struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password_file"`
+}
+
+// LoggingConfig sets up the JSON log. Level is debug, info, warn or error.
+// Redact names the attributes, such as headers and request fields, whose
+// values are kept out of the log; it replaces DefaultRedactedKeys.
+type LoggingConfig struct {
+	Level  string   `json:"level"`
+	Redact []string `json:"redact"`
+}
+
+// Logger returns the logger writing to w.
+func (c LoggingConfig) Logger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level))
+	return NewLogger(w, level, c.Redact)
+}
+
+// Duration is a time.Duration written as a string such as "15m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+const minJWTSecretLength = 32
+
+// defaultKeyDir keeps signing keys in the user's config directory rather
+// than the working directory, which may be a source checkout. Without a
+// config directory the keys only live in memory.
+func defaultKeyDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "taskmanager", "keys")
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			MetricsAddr:     "127.0.0.1:9090",
+			RequestTimeout:  Duration(30 * time.Second),
+			DrainDelay:      Duration(5 * time.Second),
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Storage: StorageConfig{
+			Driver:           "mongo",
+			MongoURI:         "mongodb://localhost:27017",
+			Database:         "task_manager",
+			DataDir:          "data",
+			OperationTimeout: Duration(5 * time.Second),
+		},
+		Auth: AuthConfig{
+			Issuer:           DefaultIssuer,
+			Audience:         DefaultAudience,
+			ClockSkew:        Duration(DefaultClockSkew),
+			SigningAlgorithm: AlgEdDSA,
+			KeyDir:           defaultKeyDir(),
+			KeyRotation:      Duration(30 * 24 * time.Hour),
+			AccessTokenTTL:   Duration(DefaultAccessTokenTTL),
+			RefreshTokenTTL:  Duration(DefaultRefreshTokenTTL),
+			APIKeyTTL:        Duration(Domain.DefaultAPIKeyTTL),
+			MaxAPIKeyTTL:     Duration(Domain.MaxAPIKeyTTL),
+			TOTPIssuer:       DefaultTOTPIssuer,
+		},
+		Password: PasswordConfig{
+			MinLength:         Domain.DefaultPasswordPolicy.MinLength,
+			MaxLength:         Domain.DefaultPasswordPolicy.MaxLength,
+			MinClasses:        Domain.DefaultPasswordPolicy.MinClasses,
+			Hash:              "argon2id",
+			BcryptCost:        bcrypt.DefaultCost,
+			Argon2MemoryKiB:   int(DefaultArgon2idHasher.Memory),
+			Argon2Iterations:  int(DefaultArgon2idHasher.Iterations),
+			Argon2Parallelism: int(DefaultArgon2idHasher.Parallelism),
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Redact: DefaultRedactedKeys,
+		},
+	}
+}
+
+// setting is one configuration value that can be overridden from the
+// environment or the command line.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+func stringSetting(target func(cfg *Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*target(cfg) = value
+		return nil
+	}
+}
+
+// listSetting reads a comma-separated list.
+func listSetting(target func(cfg *Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*target(cfg) = items
+		return nil
+	}
+}
+
+func intSetting(target func(cfg *Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*target(cfg) = n
+		return nil
+	}
+}
+
+func durationSetting(target func(cfg *Config) *Duration) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target(cfg) = Duration(d)
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"TASKMANAGER_ADDR", "addr", "address to listen on", stringSetting(func(c *Config) *string { return &c.Server.Addr })},
+	{"TASKMANAGER_METRICS_ADDR", "metrics-addr", "address to serve /metrics on, empty for none", stringSetting(func(c *Config) *string { return &c.Server.MetricsAddr })},
+	{"TASKMANAGER_TRUSTED_PROXIES", "trusted-proxies", "comma-separated proxy addresses allowed to set X-Forwarded-For", listSetting(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+	{"TASKMANAGER_REQUEST_TIMEOUT", "request-timeout", "deadline for handling one request", durationSetting(func(c *Config) *Duration { return &c.Server.RequestTimeout })},
+	{"TASKMANAGER_DRAIN_DELAY", "drain-delay", "time /readyz fails before shutting down", durationSetting(func(c *Config) *Duration { return &c.Server.DrainDelay })},
+	{"TASKMANAGER_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time given to requests in flight on shutdown", durationSetting(func(c *Config) *Duration { return &c.Server.ShutdownTimeout })},
+	{"TASKMANAGER_STORAGE", "storage", "storage backend: mongo, memory or file", stringSetting(func(c *Config) *string { return &c.Storage.Driver })},
+	{"TASKMANAGER_MONGO_URI", "mongo-uri", "MongoDB connection string", stringSetting(func(c *Config) *string { return &c.Storage.MongoURI })},
+	{"TASKMANAGER_DATABASE", "database", "MongoDB database name", stringSetting(func(c *Config) *string { return &c.Storage.Database })},
+	{"TASKMANAGER_DATA_DIR", "data-dir", "directory for the file storage backend", stringSetting(func(c *Config) *string { return &c.Storage.DataDir })},
+	{"TASKMANAGER_OPERATION_TIMEOUT", "operation-timeout", "deadline for one MongoDB operation", durationSetting(func(c *Config) *Duration { return &c.Storage.OperationTimeout })},
+	{"TASKMANAGER_JWT_ISSUER", "jwt-issuer", "iss claim of issued tokens", stringSetting(func(c *Config) *string { return &c.Auth.Issuer })},
+	{"TASKMANAGER_JWT_AUDIENCE", "jwt-audience", "aud claim of issued tokens", stringSetting(func(c *Config) *string { return &c.Auth.Audience })},
+	{"TASKMANAGER_JWT_CLOCK_SKEW", "jwt-clock-skew", "how far token times may be off", durationSetting(func(c *Config) *Duration { return &c.Auth.ClockSkew })},
+	{"TASKMANAGER_JWT_ALGORITHM", "jwt-algorithm", "token signing algorithm: EdDSA, RS256 or HS256", stringSetting(func(c *Config) *string { return &c.Auth.SigningAlgorithm })},
+	{"TASKMANAGER_JWT_KEY_DIR", "jwt-key-dir", "directory for the token signing keys", stringSetting(func(c *Config) *string { return &c.Auth.KeyDir })},
+	{"TASKMANAGER_JWT_KEY_ROTATION", "jwt-key-rotation", "how often to replace the signing key, 0 for never", durationSetting(func(c *Config) *Duration { return &c.Auth.KeyRotation })},
+	{"TASKMANAGER_JWT_SECRET", "", "", stringSetting(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"TASKMANAGER_JWT_SECRET_FILE", "jwt-secret-file", "file containing the JWT signing secret", stringSetting(func(c *Config) *string { return &c.Auth.JWTSecretFile })},
+	{"TASKMANAGER_ACCESS_TOKEN_TTL", "access-token-ttl", "access token lifetime", durationSetting(func(c *Config) *Duration { return &c.Auth.AccessTokenTTL })},
+	{"TASKMANAGER_REFRESH_TOKEN_TTL", "refresh-token-ttl", "refresh token lifetime", durationSetting(func(c *Config) *Duration { return &c.Auth.RefreshTokenTTL })},
+	{"TASKMANAGER_API_KEY_TTL", "api-key-ttl", "default API key lifetime", durationSetting(func(c *Config) *Duration { return &c.Auth.APIKeyTTL })},
+	{"TASKMANAGER_MAX_API_KEY_TTL", "max-api-key-ttl", "longest API key lifetime", durationSetting(func(c *Config) *Duration { return &c.Auth.MaxAPIKeyTTL })},
+	{"TASKMANAGER_TOTP_ISSUER", "totp-issuer", "service name shown in authenticator apps", stringSetting(func(c *Config) *string { return &c.Auth.TOTPIssuer })},
+	{"TASKMANAGER_PASSWORD_MIN_LENGTH", "password-min-length", "minimum password length", intSetting(func(c *Config) *int { return &c.Password.MinLength })},
+	{"TASKMANAGER_PASSWORD_MAX_LENGTH", "password-max-length", "maximum password length", intSetting(func(c *Config) *int { return &c.Password.MaxLength })},
+	{"TASKMANAGER_PASSWORD_MIN_CLASSES", "password-min-classes", "character classes a password must mix", intSetting(func(c *Config) *int { return &c.Password.MinClasses })},
+	{"TASKMANAGER_PASSWORD_BLOCKLIST_FILE", "password-blocklist-file", "file of blocked passwords, one per line", stringSetting(func(c *Config) *string { return &c.Password.BlocklistFile })},
+	{"TASKMANAGER_PASSWORD_HASH", "password-hash", "algorithm for new password hashes: argon2id or bcrypt", stringSetting(func(c *Config) *string { return &c.Password.Hash })},
+	{"TASKMANAGER_BCRYPT_COST", "bcrypt-cost", "bcrypt cost for new password hashes", intSetting(func(c *Config) *int { return &c.Password.BcryptCost })},
+	{"TASKMANAGER_ARGON2_MEMORY_KIB", "argon2-memory-kib", "argon2id memory in KiB", intSetting(func(c *Config) *int { return &c.Password.Argon2MemoryKiB })},
+	{"TASKMANAGER_ARGON2_ITERATIONS", "argon2-iterations", "argon2id iterations", intSetting(func(c *Config) *int { return &c.Password.Argon2Iterations })},
+	{"TASKMANAGER_ARGON2_PARALLELISM", "argon2-parallelism", "argon2id threads", intSetting(func(c *Config) *int { return &c.Password.Argon2Parallelism })},
+	{"TASKMANAGER_ADMIN_USERNAME", "admin-username", "admin account to create if it does not exist", stringSetting(func(c *Config) *string { return &c.Admin.Username })},
+	{"TASKMANAGER_ADMIN_PASSWORD", "", "", stringSetting(func(c *Config) *string { return &c.Admin.Password })},
+	{"TASKMANAGER_ADMIN_PASSWORD_FILE", "admin-password-file", "file containing the password of that admin account", stringSetting(func(c *Config) *string { return &c.Admin.PasswordFile })},
+	{"TASKMANAGER_LOG_LEVEL", "log-level", "lowest level logged: debug, info, warn or error", stringSetting(func(c *Config) *string { return &c.Logging.Level })},
+	{"TASKMANAGER_LOG_REDACT", "log-redact", "comma-separated headers and fields to keep out of the log", listSetting(func(c *Config) *[]string { return &c.Logging.Redact })},
+}
+
+// LoadConfig builds and validates the configuration from the command-line
+// arguments (without the program name) and the environment lookup function.
+// The config file is named by -config or TASKMANAGER_CONFIG. The JWT secret
+// and the admin password are deliberately not accepted as flags, since
+// flags show up in ps.
+func LoadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("taskmanager", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("TASKMANAGER_CONFIG"), "path of a JSON config file")
+	flagValues := make(map[string]*string)
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.flag] = fs.String(s.flag, "", s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, s := range settings {
+		if s.flag != "" && explicit[s.flag] {
+			if err := s.set(&cfg, *flagValues[s.flag]); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if cfg.Auth.JWTSecretFile != "" {
+		secret, err := os.ReadFile(cfg.Auth.JWTSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT secret file: %w", err)
+		}
+		cfg.Auth.JWTSecret = strings.TrimSpace(string(secret))
+	}
+	if cfg.Admin.PasswordFile != "" {
+		password, err := os.ReadFile(cfg.Admin.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading admin password file: %w", err)
+		}
+		cfg.Admin.Password = strings.TrimRight(string(password), "\r\n")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem with the configuration at once.
+func (cfg *Config) Validate() error {
+	var problems []string
+	if cfg.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	if cfg.Server.MetricsAddr != "" && cfg.Server.MetricsAddr == cfg.Server.Addr {
+		problems = append(problems, "server.metrics_addr must differ from server.addr")
+	}
+	if cfg.Server.RequestTimeout <= 0 {
+		problems = append(problems, "server.request_timeout must be positive")
+	}
+	if cfg.Server.DrainDelay < 0 {
+		problems = append(problems, "server.drain_delay must not be negative")
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.shutdown_timeout must be positive")
+	}
+	if cfg.Storage.OperationTimeout <= 0 || cfg.Storage.OperationTimeout > cfg.Server.RequestTimeout {
+		problems = append(problems, "storage.operation_timeout must be positive and at most server.request_timeout")
+	}
+	switch cfg.Storage.Driver {
+	case "mongo":
+		if cfg.Storage.MongoURI == "" {
+			problems = append(problems, "storage.mongo_uri is required for the mongo driver")
+		}
+		if cfg.Storage.Database == "" {
+			problems = append(problems, "storage.database is required for the mongo driver")
+		}
+	case "file":
+		if cfg.Storage.DataDir == "" {
+			problems = append(problems, "storage.data_dir is required for the file driver")
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("storage.driver %q must be mongo, memory or file", cfg.Storage.Driver))
+	}
+	switch cfg.Auth.SigningAlgorithm {
+	case AlgHS256:
+		if len(cfg.Auth.JWTSecret) < minJWTSecretLength {
+			problems = append(problems, fmt.Sprintf("auth.jwt_secret must be at least %d characters", minJWTSecretLength))
+		}
+	case AlgEdDSA, AlgRS256:
+		if cfg.Auth.KeyRotation < 0 {
+			problems = append(problems, "auth.key_rotation must not be negative")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("auth.signing_algorithm %q must be EdDSA, RS256 or HS256", cfg.Auth.SigningAlgorithm))
+	}
+	if cfg.Auth.Issuer == "" || cfg.Auth.Audience == "" {
+		problems = append(problems, "auth.issuer and auth.audience are required")
+	}
+	if cfg.Auth.ClockSkew < 0 || cfg.Auth.ClockSkew > Duration(5*time.Minute) {
+		problems = append(problems, "auth.clock_skew must be between 0 and 5m")
+	}
+	if cfg.Auth.AccessTokenTTL <= 0 {
+		problems = append(problems, "auth.access_token_ttl must be positive")
+	}
+	if cfg.Auth.RefreshTokenTTL <= cfg.Auth.AccessTokenTTL {
+		problems = append(problems, "auth.refresh_token_ttl must be longer than auth.access_token_ttl")
+	}
+	if cfg.Auth.APIKeyTTL <= 0 || cfg.Auth.MaxAPIKeyTTL < cfg.Auth.APIKeyTTL {
+		problems = append(problems, "auth.api_key_ttl must be positive and at most auth.max_api_key_ttl")
+	}
+	// The otpauth URI uses a colon to separate the issuer from the account.
+	if strings.TrimSpace(cfg.Auth.TOTPIssuer) == "" || strings.Contains(cfg.Auth.TOTPIssuer, ":") {
+		problems = append(problems, "auth.totp_issuer must be non-empty and must not contain ':'")
+	}
+	if cfg.Password.MinLength < 1 {
+		problems = append(problems, "password.min_length must be positive")
+	}
+	// Long passwords are welcome, but hashing megabytes on every login is
+	// not.
+	if cfg.Password.MaxLength < cfg.Password.MinLength || cfg.Password.MaxLength > 1024 {
+		problems = append(problems, "password.max_length must be between password.min_length and 1024")
+	}
+	if cfg.Password.Hash == "bcrypt" && cfg.Password.MinLength > BcryptMaxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("password.min_length must be at most %d with bcrypt", BcryptMaxPasswordBytes))
+	}
+	if cfg.Password.MinClasses < 0 || cfg.Password.MinClasses > 4 {
+		problems = append(problems, "password.min_classes must be between 0 and 4")
+	}
+	switch cfg.Password.Hash {
+	case "argon2id", "bcrypt":
+	default:
+		problems = append(problems, fmt.Sprintf("password.hash %q must be argon2id or bcrypt", cfg.Password.Hash))
+	}
+	if cfg.Password.BcryptCost < bcrypt.MinCost || cfg.Password.BcryptCost > bcrypt.MaxCost {
+		problems = append(problems, fmt.Sprintf("password.bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	// Keep hashing from exhausting memory or stalling logins.
+	if cfg.Password.Argon2MemoryKiB < 8*1024 || cfg.Password.Argon2MemoryKiB > 1024*1024 {
+		problems = append(problems, "password.argon2_memory_kib must be between 8192 and 1048576")
+	}
+	if cfg.Password.Argon2Iterations < 1 || cfg.Password.Argon2Iterations > 10 {
+		problems = append(problems, "password.argon2_iterations must be between 1 and 10")
+	}
+	if cfg.Password.Argon2Parallelism < 1 || cfg.Password.Argon2Parallelism > 16 {
+		problems = append(problems, "password.argon2_parallelism must be between 1 and 16")
+	}
+	if (cfg.Admin.Username == "") != (cfg.Admin.Password == "") {
+		problems = append(problems, "admin.username and admin.password must be set together")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
+		problems = append(problems, fmt.Sprintf("logging.level %q must be debug, info, warn or error", cfg.Logging.Level))
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package Infrastructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(nil, env(map[string]string{"TASKMANAGER_JWT_SECRET": testSecret}))
+	require.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Server.Addr)
+	assert.Equal(t, "127.0.0.1:9090", cfg.Server.MetricsAddr, "metrics stay off the public listener")
+	assert.Equal(t, "mongo", cfg.Storage.Driver)
+	assert.True(t, cfg.Auth.KeyDir == "" || filepath.IsAbs(cfg.Auth.KeyDir), "keys are not written to the working directory")
+	assert.Equal(t, DefaultAccessTokenTTL, time.Duration(cfg.Auth.AccessTokenTTL))
+	assert.Equal(t, 30*time.Second, time.Duration(cfg.Server.RequestTimeout))
+	assert.Equal(t, 5*time.Second, time.Duration(cfg.Storage.OperationTimeout))
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	secretFile := writeFile(t, "secret", testSecret+"\n")
+	configFile := writeFile(t, "config.json", `{
+		"server": {"addr": ":9000"},
+		"storage": {"driver": "file", "data_dir": "/var/lib/tasks"},
+		"auth": {"jwt_secret_file": "`+secretFile+`", "access_token_ttl": "5m"}
+	}`)
+
+	cfg, err := LoadConfig(
+		[]string{"-config", configFile, "-storage", "memory"},
+		env(map[string]string{"TASKMANAGER_ADDR": ":9100", "TASKMANAGER_STORAGE": "mongo"}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9100", cfg.Server.Addr, "env overrides file")
+	assert.Equal(t, "memory", cfg.Storage.Driver, "flag overrides env")
+	assert.Equal(t, "/var/lib/tasks", cfg.Storage.DataDir)
+	assert.Equal(t, 5*time.Minute, time.Duration(cfg.Auth.AccessTokenTTL))
+	assert.Equal(t, testSecret, cfg.Auth.JWTSecret, "secret read from file and trimmed")
+}
+
+func TestLoadConfigTrustedProxies(t *testing.T) {
+	cfg, err := LoadConfig(nil, env(map[string]string{
+		"TASKMANAGER_JWT_SECRET":      testSecret,
+		"TASKMANAGER_TRUSTED_PROXIES": "10.0.0.1, 192.168.0.0/16,",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.0/16"}, cfg.Server.TrustedProxies)
+}
+
+func TestPasswordConfigPolicy(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.Zero(t, cfg.Password.Policy().MaxBytes, "argon2id hashes the whole password")
+	cfg.Password.Hash = "bcrypt"
+	assert.Equal(t, BcryptMaxPasswordBytes, cfg.Password.Policy().MaxBytes)
+	assert.Equal(t, cfg.Password.MaxLength, cfg.Password.Policy().MaxLength)
+}
+
+func TestLoadConfigAdmin(t *testing.T) {
+	passwordFile := writeFile(t, "admin-password", "correct horse battery\n")
+	cfg, err := LoadConfig([]string{"-admin-username", "root", "-admin-password-file", passwordFile}, env(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "root", cfg.Admin.Username)
+	assert.Equal(t, "correct horse battery", cfg.Admin.Password)
+
+	_, err = LoadConfig([]string{"-admin-username", "root"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "admin.username and admin.password")
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	_, err := LoadConfig([]string{"-storage", "postgres", "-access-token-ttl", "2h", "-refresh-token-ttl", "1h", "-jwt-algorithm", "HS256",
+		"-request-timeout", "2s", "-operation-timeout", "5s", "-shutdown-timeout", "0s", "-drain-delay", "-1s", "-log-level", "loud", "-metrics-addr", ":8080"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server.metrics_addr")
+	assert.Contains(t, err.Error(), "storage.driver")
+	assert.Contains(t, err.Error(), "storage.operation_timeout")
+	assert.Contains(t, err.Error(), "server.shutdown_timeout")
+	assert.Contains(t, err.Error(), "server.drain_delay")
+	assert.Contains(t, err.Error(), "logging.level")
+	assert.Contains(t, err.Error(), "auth.jwt_secret")
+	assert.Contains(t, err.Error(), "auth.refresh_token_ttl")
+
+	_, err = LoadConfig(nil, env(nil))
+	assert.NoError(t, err, "asymmetric signing needs no secret")
+	_, err = LoadConfig([]string{"-jwt-algorithm", "none", "-jwt-issuer", "", "-jwt-clock-skew", "1h",
+		"-api-key-ttl", "48h", "-max-api-key-ttl", "24h", "-totp-issuer", "Tasks: prod"}, env(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "auth.api_key_ttl")
+	assert.Contains(t, err.Error(), "auth.totp_issuer")
+	assert.Contains(t, err.Error(), "auth.signing_algorithm")
+	assert.Contains(t, err.Error(), "auth.issuer")
+	assert.Contains(t, err.Error(), "auth.clock_skew")
+
+	_, err = LoadConfig([]string{"-access-token-ttl", "soon"}, env(map[string]string{"TASKMANAGER_JWT_SECRET": testSecret}))
+	assert.Error(t, err)
+
+	configFile := writeFile(t, "config.json", `{"server": {"adr": ":9000"}}`)
+	_, err = LoadConfig([]string{"-config", configFile}, env(map[string]string{"TASKMANAGER_JWT_SECRET": testSecret}))
+	assert.Error(t, err, "unknown keys are rejected")
+
+	_, err = LoadConfig(nil, env(map[string]string{"TASKMANAGER_JWT_SECRET_FILE": "/does/not/exist"}))
+	assert.Error(t, err)
+
+	_, err = LoadConfig([]string{"-password-min-length", "0", "-password-max-length", "4096", "-password-min-classes", "5", "-bcrypt-cost", "99",
+		"-password-hash", "md5", "-argon2-memory-kib", "64", "-argon2-iterations", "0", "-argon2-parallelism", "0"},
+		env(map[string]string{"TASKMANAGER_JWT_SECRET": testSecret}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "password.hash")
+	assert.Contains(t, err.Error(), "password.argon2_memory_kib")
+	assert.Contains(t, err.Error(), "password.argon2_iterations")
+	assert.Contains(t, err.Error(), "password.argon2_parallelism")
+	assert.Contains(t, err.Error(), "password.min_length")
+	assert.Contains(t, err.Error(), "password.max_length")
+	assert.Contains(t, err.Error(), "password.min_classes")
+	assert.Contains(t, err.Error(), "password.bcrypt_cost")
+}
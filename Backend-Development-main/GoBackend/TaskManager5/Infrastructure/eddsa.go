@@ -0,0 +1,45 @@
+package Infrastructure
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519 (RFC 8037), which jwt-go
+// does not support itself.
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+type signingMethodEd25519 struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgEdDSA, func() jwt.SigningMethod { return SigningMethodEdDSA })
+}
+
+func (m *signingMethodEd25519) Alg() string {
+	return AlgEdDSA
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package Infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body. Code is the stable error
+// code clients should branch on; Type is derived from it. Errors lists the
+// invalid fields of a request that failed validation.
+type Problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Code     string              `json:"code"`
+	Errors   []Domain.FieldError `json:"errors,omitempty"`
+}
+
+var kindStatus = map[Domain.ErrorKind]int{
+	Domain.KindNotFound:             http.StatusNotFound,
+	Domain.KindInvalidInput:         http.StatusBadRequest,
+	Domain.KindValidation:           http.StatusUnprocessableEntity,
+	Domain.KindConflict:             http.StatusConflict,
+	Domain.KindPreconditionFailed:   http.StatusPreconditionFailed,
+	Domain.KindUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	Domain.KindUnauthorized:         http.StatusUnauthorized,
+	Domain.KindForbidden:            http.StatusForbidden,
+	Domain.KindTooManyRequests:      http.StatusTooManyRequests,
+	Domain.KindUnavailable:          http.StatusServiceUnavailable,
+}
+
+const internalErrorCode = "internal_error"
+
+// NewProblem translates err into a problem. Errors that aren't domain
+// errors are reported as a generic 500 so internals don't leak to clients,
+// except for running out of time, which is Domain.ErrTimeout.
+func NewProblem(err error, instance string) Problem {
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = Domain.ErrTimeout
+	}
+	var domainErr *Domain.Error
+	if !errors.As(err, &domainErr) {
+		return Problem{
+			Type:     problemType(internalErrorCode),
+			Title:    http.StatusText(http.StatusInternalServerError),
+			Status:   http.StatusInternalServerError,
+			Detail:   "an unexpected error occurred",
+			Instance: instance,
+			Code:     internalErrorCode,
+		}
+	}
+	status, ok := kindStatus[domainErr.Kind]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	problem := Problem{
+		Type:     problemType(domainErr.Code),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: instance,
+		Code:     domainErr.Code,
+	}
+	var invalid *Domain.ValidationError
+	if errors.As(err, &invalid) {
+		problem.Errors = invalid.Fields
+	}
+	return problem
+}
+
+func problemType(code string) string {
+	return "urn:taskmanager:problem:" + code
+}
+
+// ErrorMiddleware writes the last error a handler recorded with c.Error as
+// a problem+json response, with a Retry-After header for throttled
+// requests. It must run before any middleware or handler that reports
+// errors, and does nothing if a response was already written.
+func ErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil || c.Writer.Written() {
+			return
+		}
+		problem := NewProblem(last.Err, c.Request.URL.Path)
+		if problem.Status >= http.StatusInternalServerError {
+			slog.ErrorContext(c.Request.Context(), "request failed",
+				"method", c.Request.Method, "path", c.Request.URL.Path, "error", last.Err)
+		}
+		var throttled *Domain.ThrottledError
+		if errors.As(last.Err, &throttled) {
+			c.Header("Retry-After", retryAfterSeconds(throttled.RetryAfter))
+		}
+		c.Header("Content-Type", ProblemContentType)
+		c.JSON(problem.Status, problem)
+	}
+}
+
+// RecoveryMiddleware answers a handler's panic with a 500 problem and logs
+// it with its stack. It must run before ErrorMiddleware, whose own work a
+// panic skips.
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		slog.ErrorContext(c.Request.Context(), "panic", "error", recovered, "stack", string(debug.Stack()))
+		c.Header("Content-Type", ProblemContentType)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, NewProblem(fmt.Errorf("panic: %v", recovered), c.Request.URL.Path))
+	})
+}
+
+// retryAfterSeconds rounds up, so a client that waits as told is never early.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
@@ -0,0 +1,107 @@
+package Infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(ErrorMiddleware())
+	router.GET("/wrapped", func(c *gin.Context) {
+		c.Error(fmt.Errorf("%w: title is required", Domain.ErrInvalidTask))
+	})
+	router.GET("/transition", func(c *gin.Context) {
+		c.Error(&Domain.TransitionError{From: Domain.StatusDone, To: Domain.StatusBlocked})
+	})
+	router.GET("/internal", func(c *gin.Context) {
+		c.Error(errors.New("connection refused"))
+	})
+	router.GET("/throttled", func(c *gin.Context) {
+		c.Error(&Domain.ThrottledError{RetryAfter: 1500 * time.Millisecond})
+	})
+	router.GET("/timeout", func(c *gin.Context) {
+		c.Error(fmt.Errorf("finding tasks: %w", context.DeadlineExceeded))
+	})
+	router.GET("/written", func(c *gin.Context) {
+		c.Error(Domain.ErrTaskNotFound)
+		c.String(http.StatusTeapot, "already answered")
+	})
+
+	request := func(path string) (*httptest.ResponseRecorder, Problem) {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var problem Problem
+		if w.Header().Get("Content-Type") == ProblemContentType {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		}
+		return w, problem
+	}
+
+	w, problem := request("/wrapped")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, Problem{
+		Type:     "urn:taskmanager:problem:invalid_task",
+		Title:    "Unprocessable Entity",
+		Status:   http.StatusUnprocessableEntity,
+		Detail:   "invalid task: title is required",
+		Instance: "/wrapped",
+		Code:     "invalid_task",
+	}, problem)
+
+	w, problem = request("/transition")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "invalid_transition", problem.Code)
+	assert.Equal(t, "cannot move a task from done to blocked", problem.Detail)
+
+	w, problem = request("/internal")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "internal_error", problem.Code)
+	assert.NotContains(t, problem.Detail, "connection refused")
+
+	w, problem = request("/throttled")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "too_many_attempts", problem.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	w, problem = request("/timeout")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "timeout", problem.Code)
+	assert.NotContains(t, problem.Detail, "finding tasks")
+
+	w, _ = request("/written")
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "already answered", w.Body.String())
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(RecoveryMiddleware(), ErrorMiddleware())
+	router.GET("/panic", func(c *gin.Context) {
+		panic("nil map")
+	})
+
+	req, _ := http.NewRequest("GET", "/panic", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	var problem Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "internal_error", problem.Code)
+	assert.NotContains(t, problem.Detail, "nil map")
+}
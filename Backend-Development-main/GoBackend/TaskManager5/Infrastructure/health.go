@@ -0,0 +1,67 @@
+package Infrastructure
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessChecker reports why the service can't take traffic yet, such as
+// a Repositories.Store whose server is unreachable.
+type ReadinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// Drainable reports not ready once Drain is called, whatever the checker
+// it wraps says. Shutdown drains it first, so that the load balancer stops
+// sending requests before the server stops taking them.
+type Drainable struct {
+	next     ReadinessChecker
+	draining atomic.Bool
+}
+
+func NewDrainable(next ReadinessChecker) *Drainable {
+	return &Drainable{next: next}
+}
+
+// Drain marks the service as going away for good.
+func (d *Drainable) Drain() {
+	d.draining.Store(true)
+}
+
+func (d *Drainable) Ready(ctx context.Context) error {
+	if d.draining.Load() {
+		return errors.New("shutting down")
+	}
+	return d.next.Ready(ctx)
+}
+
+// LivenessHandler answers /healthz. It checks nothing but that the process
+// still serves requests, so a storage outage doesn't get it restarted.
+func LivenessHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// ReadinessHandler answers /readyz with 503 Domain.ErrNotReady while ready
+// reports a problem. The problem itself is only logged, since the endpoint
+// is public.
+func ReadinessHandler(ready ReadinessChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		if err := ready.Ready(c.Request.Context()); err != nil {
+			slog.WarnContext(c.Request.Context(), "not ready", "error", err)
+			c.Error(Domain.ErrNotReady)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
@@ -0,0 +1,52 @@
+package Infrastructure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type readiness struct{ err error }
+
+func (r *readiness) Ready(ctx context.Context) error { return r.err }
+
+func TestHealthHandlers(t *testing.T) {
+	ready := &readiness{err: errors.New("pinging storage: connection refused")}
+	router := gin.New()
+	router.Use(ErrorMiddleware())
+	router.GET("/healthz", LivenessHandler())
+	router.GET("/readyz", ReadinessHandler(ready))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("/healthz").Code)
+
+	w := request("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var problem Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, "not_ready", problem.Code)
+	assert.NotContains(t, problem.Detail, "connection refused")
+
+	ready.err = nil
+	assert.Equal(t, http.StatusOK, request("/readyz").Code)
+}
+
+func TestDrainable(t *testing.T) {
+	ready := NewDrainable(&readiness{})
+	assert.NoError(t, ready.Ready(context.Background()))
+	ready.Drain()
+	assert.Error(t, ready.Ready(context.Background()), "not ready once draining")
+}
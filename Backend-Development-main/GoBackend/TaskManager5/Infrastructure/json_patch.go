@@ -0,0 +1,319 @@
+package Infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"TaskManager5/Domain"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// ApplyPatch applies patch to the JSON document doc according to the patch
+// content type. Plain application/json is treated as a merge patch.
+func ApplyPatch(contentType string, doc, patch []byte) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, Domain.ErrUnsupportedPatch
+	}
+	switch mediaType {
+	case MergePatchContentType, "application/json":
+		return ApplyMergePatch(doc, patch)
+	case JSONPatchContentType:
+		return ApplyJSONPatch(doc, patch)
+	}
+	return nil, Domain.ErrUnsupportedPatch
+}
+
+// ApplyMergePatch implements RFC 7396: objects are merged recursively, null
+// removes a member and any other value replaces the target outright.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", Domain.ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = make(map[string]interface{})
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(object, key)
+			continue
+		}
+		object[key] = mergePatch(object[key], value)
+	}
+	return object
+}
+
+// patchOperation is one entry of an RFC 6902 JSON Patch document.
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch implements RFC 6902. The operations are applied in order
+// and the document is left untouched if any of them fails.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	var operations []patchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", Domain.ErrInvalidPatch, err)
+	}
+	for i, op := range operations {
+		var err error
+		if target, err = applyOperation(target, op); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: %q operation has no path", Domain.ErrInvalidPatch, op.Op)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %q operation has no value", Domain.ErrInvalidPatch, op.Op)
+		}
+		var value interface{}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: %v", Domain.ErrInvalidPatch, err)
+		}
+		switch op.Op {
+		case "add":
+			return addValue(doc, path, value)
+		case "replace":
+			if doc, err = removeValue(doc, path); err != nil {
+				return nil, err
+			}
+			return addValue(doc, path, value)
+		}
+		current, err := getValue(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w at %s", Domain.ErrPatchTestFailed, *op.Path)
+		}
+		return doc, nil
+	case "remove":
+		return removeValue(doc, path)
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: %q operation has no from", Domain.ErrInvalidPatch, op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move %s into itself", Domain.ErrInvalidPatch, *op.From)
+			}
+			if doc, err = removeValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return addValue(doc, path, value)
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", Domain.ErrInvalidPatch, op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: invalid pointer %q", Domain.ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("%w: invalid array index %q", Domain.ErrInvalidPatch, token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", Domain.ErrInvalidPatch, index)
+	}
+	return index, nil
+}
+
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: path /%s does not exist", Domain.ErrInvalidPatch, strings.Join(path, "/"))
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("%w: path /%s does not exist", Domain.ErrInvalidPatch, strings.Join(path, "/"))
+		}
+	}
+	return current, nil
+}
+
+// setValue overwrites the existing value at path.
+func setValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+	return doc, nil
+}
+
+// addValue returns the document with value added at path. Objects are
+// modified in place; arrays change length, so the new slice is written back
+// into the parent.
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		node[last] = value
+		return doc, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		grown := append(node[:index:index], append([]interface{}{value}, node[index:]...)...)
+		return setValue(doc, path[:len(path)-1], grown)
+	}
+	return nil, fmt.Errorf("%w: cannot add to a scalar at /%s", Domain.ErrInvalidPatch, strings.Join(path, "/"))
+}
+
+func removeValue(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	parent, err := getValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[last]; !ok {
+			return nil, fmt.Errorf("%w: path /%s does not exist", Domain.ErrInvalidPatch, strings.Join(path, "/"))
+		}
+		delete(node, last)
+		return doc, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		shrunk := append(node[:index:index], node[index+1:]...)
+		return setValue(doc, path[:len(path)-1], shrunk)
+	}
+	return nil, fmt.Errorf("%w: path /%s does not exist", Domain.ErrInvalidPatch, strings.Join(path, "/"))
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for key, child := range node {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, child := range node {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,96 @@
+package Infrastructure
+
+import (
+	"testing"
+
+	"TaskManager5/Domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Cases taken from the examples in RFC 7396 appendix A.
+func TestApplyMergePatch(t *testing.T) {
+	cases := []struct{ doc, patch, expected string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range cases {
+		result, err := ApplyMergePatch([]byte(tc.doc), []byte(tc.patch))
+		require.NoError(t, err, tc.patch)
+		assert.JSONEq(t, tc.expected, string(result), tc.patch)
+	}
+}
+
+// Cases taken from the examples in RFC 6902 appendix A.
+func TestApplyJSONPatch(t *testing.T) {
+	cases := []struct{ doc, patch, expected string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":[[1,2]]}`, `[{"op":"add","path":"/a/0/1","value":3}]`, `{"a":[[1,3,2]]}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+	}
+	for _, tc := range cases {
+		result, err := ApplyJSONPatch([]byte(tc.doc), []byte(tc.patch))
+		require.NoError(t, err, tc.patch)
+		assert.JSONEq(t, tc.expected, string(result), tc.patch)
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+		err        error
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, Domain.ErrPatchTestFailed},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, Domain.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, Domain.ErrInvalidPatch},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/5","value":1}]`, Domain.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"frobnicate","path":"/foo"}]`, Domain.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/foo"}]`, Domain.ErrInvalidPatch},
+		{`{"foo":{"a":1}}`, `[{"op":"move","from":"/foo","path":"/foo/b"}]`, Domain.ErrInvalidPatch},
+		{`{"foo":"bar"}`, `{"op":"remove","path":"/foo"}`, Domain.ErrInvalidPatch},
+	}
+	for _, tc := range cases {
+		_, err := ApplyJSONPatch([]byte(tc.doc), []byte(tc.patch))
+		assert.ErrorIs(t, err, tc.err, tc.patch)
+	}
+}
+
+func TestApplyPatchContentType(t *testing.T) {
+	doc := []byte(`{"title":"a"}`)
+
+	result, err := ApplyPatch("application/merge-patch+json; charset=utf-8", doc, []byte(`{"title":"b"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"b"}`, string(result))
+
+	result, err = ApplyPatch("application/json", doc, []byte(`{"title":"c"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"c"}`, string(result))
+
+	result, err = ApplyPatch("application/json-patch+json", doc, []byte(`[{"op":"replace","path":"/title","value":"d"}]`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"d"}`, string(result))
+
+	_, err = ApplyPatch("text/plain", doc, []byte(`title=e`))
+	assert.ErrorIs(t, err, Domain.ErrUnsupportedPatch)
+	_, err = ApplyPatch("", doc, []byte(`{}`))
+	assert.ErrorIs(t, err, Domain.ErrUnsupportedPatch)
+}
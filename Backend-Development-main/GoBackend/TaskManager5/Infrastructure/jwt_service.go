@@ -1,24 +1,173 @@
 package Infrastructure
 
 import (
-	"TaskManager5/Domain"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"time"
 
+	"TaskManager5/Domain"
+
 	"github.com/dgrijalva/jwt-go"
 )
 
-func GenerateJWT(user Domain.User, secretKey string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
-	})
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+	MFATokenType     = "mfa"
 
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+	DefaultMFATokenTTL     = 5 * time.Minute
+
+	DefaultIssuer    = "taskmanager"
+	DefaultAudience  = "taskmanager"
+	DefaultClockSkew = 30 * time.Second
+)
+
+// IssuedToken is a signed token together with the claims the server needs
+// to remember about it.
+type IssuedToken struct {
+	Token     string
+	ID        string
+	ExpiresAt time.Time
+}
+
+// TokenService signs and parses the access and refresh tokens with the keys
+// of a KeyRing. Both carry a "jti" and the "sid" of the login session they
+// belong to, and a "kid" header naming their key. ClockSkew is how far the
+// clocks of the issuer and a verifier may disagree. Rejected tokens are
+// reported to Metrics.
+//
+// An MFA token is the short-lived proof that a user got their password
+// right; it is only good for completing the login with a second factor.
+type TokenService struct {
+	keys       *KeyRing
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	MFATTL     time.Duration
+	Issuer     string
+	Audience   string
+	ClockSkew  time.Duration
+	Metrics    *Metrics
+}
+
+func NewTokenService(keys *KeyRing) *TokenService {
+	return &TokenService{
+		keys:       keys,
+		AccessTTL:  DefaultAccessTokenTTL,
+		RefreshTTL: DefaultRefreshTokenTTL,
+		MFATTL:     DefaultMFATokenTTL,
+		Issuer:     DefaultIssuer,
+		Audience:   DefaultAudience,
+		ClockSkew:  DefaultClockSkew,
+	}
+}
+
+func (s *TokenService) Keys() *KeyRing {
+	return s.keys
+}
+
+// NewTokenID returns a random identifier for a token or session.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IssueAccessToken issues an access token for the session. mfa records
+// whether the session was opened with a second factor.
+func (s *TokenService) IssueAccessToken(user Domain.User, sessionID string, mfa bool) (IssuedToken, error) {
+	return s.issue(&Claims{
+		Subject:  user.ID.Hex(),
+		Username: user.Username,
+		Role:     user.Role,
+		MFA:      mfa,
+	}, AccessTokenType, sessionID, s.AccessTTL)
+}
+
+func (s *TokenService) IssueRefreshToken(user Domain.User, sessionID string) (IssuedToken, error) {
+	return s.issue(&Claims{Subject: user.ID.Hex()}, RefreshTokenType, sessionID, s.RefreshTTL)
+}
+
+func (s *TokenService) IssueMFAToken(user Domain.User) (IssuedToken, error) {
+	return s.issue(&Claims{Subject: user.ID.Hex()}, MFATokenType, "", s.MFATTL)
+}
+
+func (s *TokenService) issue(claims *Claims, tokenType, sessionID string, ttl time.Duration) (IssuedToken, error) {
+	id, err := NewTokenID()
+	if err != nil {
+		return IssuedToken{}, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims.Issuer = s.Issuer
+	claims.Audience = Audience{s.Audience}
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.ExpiresAt = expiresAt.Unix()
+	claims.ID = id
+	claims.SessionID = sessionID
+	claims.Type = tokenType
+
+	tokenString, err := s.sign(claims)
+	if err != nil {
+		return IssuedToken{}, err
+	}
+	return IssuedToken{Token: tokenString, ID: id, ExpiresAt: expiresAt}, nil
+}
+
+func (s *TokenService) sign(claims *Claims) (string, error) {
+	key := s.keys.Current()
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.private)
+}
+
+// Parse validates the signature and the claims of a token of the given type
+// and returns the claims. The token must name a key of the ring that still
+// verifies, and its alg header must be that key's algorithm. It must come
+// from Issuer for Audience, and be current give or take ClockSkew.
+func (s *TokenService) Parse(tokenString, tokenType string) (*Claims, error) {
+	claims, reason, err := s.parse(tokenString, tokenType)
+	if err != nil {
+		s.Metrics.ObserveTokenRejected(tokenType, reason)
+	}
+	return claims, err
+}
 
-	return tokenString, nil
+// parse does the work of Parse, and says which check failed.
+func (s *TokenService) parse(tokenString, tokenType string) (*Claims, string, error) {
+	now := time.Now()
+	claims := &Claims{}
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.VerificationKey(kid, now)
+		if !ok {
+			return nil, errors.New("unknown or expired signing key")
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		return nil, "signature", Domain.ErrInvalidToken
+	}
+	if err := claims.validTimes(now, s.ClockSkew); err != nil {
+		return nil, "lifetime", fmt.Errorf("%w: %v", Domain.ErrInvalidToken, err)
+	}
+	if claims.Issuer != s.Issuer || !claims.Audience.Contains(s.Audience) {
+		return nil, "issuer_audience", fmt.Errorf("%w: wrong issuer or audience", Domain.ErrInvalidToken)
+	}
+	if claims.Type != tokenType || claims.ID == "" || claims.Subject == "" {
+		return nil, "type", Domain.ErrInvalidToken
+	}
+	return claims, "", nil
 }
@@ -0,0 +1,78 @@
+package Infrastructure
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTokenClaims(t *testing.T) {
+	tokens := NewTokenService(NewHMACKeyRing(testSecret))
+	tokens.Issuer = "https://tasks.example.com"
+	tokens.Audience = "tasks-api"
+	user := Domain.User{ID: primitive.NewObjectID(), Username: "alice", Role: Domain.RoleUser}
+
+	issued, err := tokens.IssueAccessToken(user, "session", false)
+	require.NoError(t, err)
+	payload, err := jwt.DecodeSegment(strings.Split(issued.Token, ".")[1])
+	require.NoError(t, err)
+	var raw map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &raw))
+	assert.Equal(t, user.ID.Hex(), raw["sub"])
+	assert.Equal(t, "https://tasks.example.com", raw["iss"])
+	assert.Equal(t, "tasks-api", raw["aud"])
+	for _, claim := range []string{"iat", "nbf", "exp"} {
+		assert.Contains(t, raw, claim)
+	}
+
+	claims, err := tokens.Parse(issued.Token, AccessTokenType)
+	require.NoError(t, err)
+	principal, err := claims.Principal()
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, principal.UserID)
+	assert.Equal(t, "alice", principal.Username)
+	assert.Equal(t, issued.ID, principal.TokenID)
+
+	other := NewTokenService(NewHMACKeyRing(testSecret))
+	_, err = other.Parse(issued.Token, AccessTokenType)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken, "wrong issuer and audience")
+}
+
+func TestTokenTimesAllowClockSkew(t *testing.T) {
+	tokens := NewTokenService(NewHMACKeyRing(testSecret))
+	tokens.ClockSkew = time.Minute
+	now := time.Now()
+	sign := func(notBefore, expiresAt time.Time, audience ...string) string {
+		token, err := tokens.sign(&Claims{
+			Subject:   primitive.NewObjectID().Hex(),
+			Issuer:    tokens.Issuer,
+			Audience:  audience,
+			IssuedAt:  notBefore.Unix(),
+			NotBefore: notBefore.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			ID:        "token",
+			Type:      AccessTokenType,
+		})
+		require.NoError(t, err)
+		return token
+	}
+	parse := func(token string) error {
+		_, err := tokens.Parse(token, AccessTokenType)
+		return err
+	}
+
+	assert.NoError(t, parse(sign(now.Add(30*time.Second), now.Add(time.Hour), tokens.Audience)), "issuer clock slightly ahead")
+	assert.ErrorIs(t, parse(sign(now.Add(2*time.Minute), now.Add(time.Hour), tokens.Audience)), Domain.ErrInvalidToken)
+	assert.NoError(t, parse(sign(now.Add(-time.Hour), now.Add(-30*time.Second), tokens.Audience)), "just expired")
+	assert.ErrorIs(t, parse(sign(now.Add(-time.Hour), now.Add(-2*time.Minute), tokens.Audience)), Domain.ErrInvalidToken)
+	assert.NoError(t, parse(sign(now, now.Add(time.Hour), "other-service", tokens.Audience)), "one of several audiences")
+	assert.ErrorIs(t, parse(sign(now, now.Add(time.Hour), "other-service")), Domain.ErrInvalidToken)
+}
@@ -0,0 +1,392 @@
+package Infrastructure
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// Token signing algorithms. HS256 needs the shared secret on every verifier;
+// the other two can be verified by anyone holding the published public key.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// SigningKey is one key of a KeyRing. It signs tokens until the next key is
+// created, which retires it, and verifies them until the ring's grace
+// period after that.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Created   time.Time
+	Retired   time.Time
+	private   interface{}
+	public    interface{}
+}
+
+// KeyRingOptions configures a KeyRing. Keys are kept in Dir, so that they
+// survive restarts and can be shared between instances; with no Dir they
+// only live in memory. A new key is made every RotateEvery, or never if it
+// is 0. Grace must cover the longest token lifetime, or tokens signed just
+// before a rotation stop verifying before they expire.
+type KeyRingOptions struct {
+	Algorithm   string
+	Dir         string
+	RotateEvery time.Duration
+	Grace       time.Duration
+}
+
+// KeyRing holds the current signing key and the retired keys that still
+// verify tokens.
+type KeyRing struct {
+	mu   sync.RWMutex
+	opts KeyRingOptions
+	keys []*SigningKey // newest first
+}
+
+// NewKeyRing loads the keys in opts.Dir and makes a new current key if
+// there is none with opts.Algorithm or it is due for rotation.
+func NewKeyRing(opts KeyRingOptions) (*KeyRing, error) {
+	if opts.Algorithm != AlgRS256 && opts.Algorithm != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported key ring algorithm %q", opts.Algorithm)
+	}
+	ring := &KeyRing{opts: opts}
+	if err := ring.RotateIfDue(time.Now()); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// NewHMACKeyRing returns a ring with the single shared HS256 secret. Its
+// tokens carry no kid and it publishes no keys.
+func NewHMACKeyRing(secret string) *KeyRing {
+	return &KeyRing{
+		opts: KeyRingOptions{Algorithm: AlgHS256},
+		keys: []*SigningKey{{Algorithm: AlgHS256, private: []byte(secret), public: []byte(secret)}},
+	}
+}
+
+// Current returns the key new tokens are signed with.
+func (r *KeyRing) Current() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys[0]
+}
+
+// VerificationKey returns the key with the given id if it may still verify
+// tokens at now.
+func (r *KeyRing) VerificationKey(kid string, now time.Time) (*SigningKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.keys {
+		if key.ID == kid {
+			return key, key.Retired.IsZero() || now.Before(key.Retired.Add(r.opts.Grace))
+		}
+	}
+	return nil, false
+}
+
+// RotateIfDue picks up keys other instances wrote to the key directory and
+// makes a new current key if the newest one is too old or uses another
+// algorithm than the configured one.
+func (r *KeyRing) RotateIfDue(now time.Time) error {
+	if r.opts.Algorithm == AlgHS256 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.opts.Dir != "" {
+		keys, err := loadSigningKeys(r.opts.Dir)
+		if err != nil {
+			return err
+		}
+		r.keys = keys
+	}
+	if len(r.keys) > 0 {
+		current := r.keys[0]
+		fresh := r.opts.RotateEvery <= 0 || now.Before(current.Created.Add(r.opts.RotateEvery))
+		if current.Algorithm == r.opts.Algorithm && fresh {
+			r.prune(now)
+			return nil
+		}
+	}
+	return r.rotate(now)
+}
+
+// Rotate makes a new current key and retires the old one.
+func (r *KeyRing) Rotate(now time.Time) error {
+	if r.opts.Algorithm == AlgHS256 {
+		return errors.New("an HS256 key ring cannot be rotated")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate(now)
+}
+
+func (r *KeyRing) rotate(now time.Time) error {
+	key, err := generateSigningKey(r.opts.Algorithm, now)
+	if err != nil {
+		return err
+	}
+	if r.opts.Dir != "" {
+		if err := saveSigningKey(r.opts.Dir, key); err != nil {
+			return err
+		}
+	}
+	if len(r.keys) > 0 {
+		r.keys[0].Retired = now
+	}
+	r.keys = append([]*SigningKey{key}, r.keys...)
+	r.prune(now)
+	return nil
+}
+
+// prune forgets keys whose grace period is over and removes their files.
+func (r *KeyRing) prune(now time.Time) {
+	kept := r.keys[:0]
+	for _, key := range r.keys {
+		if key.Retired.IsZero() || now.Before(key.Retired.Add(r.opts.Grace)) {
+			kept = append(kept, key)
+			continue
+		}
+		if r.opts.Dir != "" {
+			if err := os.Remove(filepath.Join(r.opts.Dir, key.ID+".pem")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Error("removing expired signing key", "key_id", key.ID, "error", err)
+			}
+		}
+	}
+	r.keys = kept
+}
+
+// RunRotation checks once a minute whether the key is due for rotation,
+// until ctx is done.
+func (r *KeyRing) RunRotation(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.RotateIfDue(now); err != nil {
+				slog.ErrorContext(ctx, "rotating signing key", "error", err)
+			}
+		}
+	}
+}
+
+func generateSigningKey(algorithm string, now time.Time) (*SigningKey, error) {
+	key := &SigningKey{Algorithm: algorithm, Created: now}
+	switch algorithm {
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, &private.PublicKey
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.private, key.public = private, public
+	default:
+		return nil, fmt.Errorf("unsupported key ring algorithm %q", algorithm)
+	}
+	id, err := keyID(key.public)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+	return key, nil
+}
+
+// keyID derives the kid from the public key, so that every instance sharing
+// a key directory names a key the same way.
+func keyID(public interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+// saveSigningKey writes the key as <kid>.pem, a PKCS #8 private key with
+// its algorithm and creation time in PEM headers. The file is renamed into
+// place so that other instances never read half of it.
+func saveSigningKey(dir string, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{
+		Type: "PRIVATE KEY",
+		Headers: map[string]string{
+			"Algorithm": key.Algorithm,
+			"Created":   key.Created.UTC().Format(time.RFC3339),
+		},
+		Bytes: der,
+	})
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("creating key directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".key-*")
+	if err != nil {
+		return fmt.Errorf("saving signing key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving signing key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving signing key: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, key.ID+".pem")); err != nil {
+		return fmt.Errorf("saving signing key: %w", err)
+	}
+	return nil
+}
+
+// loadSigningKeys reads every key in dir, newest first. Each key is retired
+// by the creation of the next one.
+func loadSigningKeys(dir string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	var keys []*SigningKey
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.After(keys[j].Created) })
+	for i := 1; i < len(keys); i++ {
+		keys[i].Retired = keys[i-1].Created
+	}
+	return keys, nil
+}
+
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PEM encoded private key")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	created, err := time.Parse(time.RFC3339, block.Headers["Created"])
+	if err != nil {
+		return nil, fmt.Errorf("bad Created header: %w", err)
+	}
+	key := &SigningKey{Algorithm: block.Headers["Algorithm"], Created: created, private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.public = &private.PublicKey
+		if key.Algorithm != AlgRS256 {
+			return nil, fmt.Errorf("RSA key marked as %q", key.Algorithm)
+		}
+	case ed25519.PrivateKey:
+		key.public = private.Public()
+		if key.Algorithm != AlgEdDSA {
+			return nil, fmt.Errorf("Ed25519 key marked as %q", key.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	if key.ID, err = keyID(key.public); err != nil {
+		return nil, err
+	}
+	if name := strings.TrimSuffix(filepath.Base(path), ".pem"); name != key.ID {
+		return nil, fmt.Errorf("file name does not match key id %s", key.ID)
+	}
+	return key, nil
+}
+
+// signingMethod returns the jwt-go method for the key's algorithm.
+func (k *SigningKey) signingMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// JSONWebKey is the public half of a signing key as published in a JWKS.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys that may verify tokens, the current one
+// first. Shared HMAC secrets are never published.
+func (r *KeyRing) JWKS() JSONWebKeySet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range r.keys {
+		jwk := JSONWebKey{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWKSHandler serves the ring's public keys at /.well-known/jwks.json. A new
+// key signs tokens as soon as it is made, so verifiers that meet an unknown
+// kid should fetch the set again rather than wait for their cache to expire.
+func JWKSHandler(keys *KeyRing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
@@ -0,0 +1,90 @@
+package Infrastructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeyRingRotation(t *testing.T) {
+	dir := t.TempDir()
+	opts := KeyRingOptions{Algorithm: AlgEdDSA, Dir: dir, RotateEvery: time.Hour, Grace: 2 * time.Hour}
+	ring, err := NewKeyRing(opts)
+	require.NoError(t, err)
+	first := ring.Current()
+	assert.FileExists(t, filepath.Join(dir, first.ID+".pem"))
+
+	// A restart, or another instance, picks up the same key.
+	reloaded, err := NewKeyRing(opts)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, reloaded.Current().ID)
+
+	tokens := NewTokenService(ring)
+	user := Domain.User{ID: primitive.NewObjectID(), Username: "alice"}
+	old, err := tokens.IssueAccessToken(user, "session", false)
+	require.NoError(t, err)
+
+	rotatedAt := first.Created.Add(time.Hour)
+	require.NoError(t, ring.RotateIfDue(rotatedAt.Add(-time.Second)))
+	assert.Equal(t, first.ID, ring.Current().ID, "not due yet")
+	require.NoError(t, ring.RotateIfDue(rotatedAt))
+	second := ring.Current()
+	assert.NotEqual(t, first.ID, second.ID)
+
+	_, err = tokens.Parse(old.Token, AccessTokenType)
+	assert.NoError(t, err, "retired keys verify during the grace period")
+	jwks := ring.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, second.ID, jwks.Keys[0].ID)
+
+	_, ok := ring.VerificationKey(first.ID, rotatedAt.Add(2*time.Hour))
+	assert.False(t, ok, "grace period is over")
+	require.NoError(t, ring.Rotate(rotatedAt.Add(3*time.Hour)))
+	assert.Len(t, ring.JWKS().Keys, 2, "the first key is pruned")
+	_, err = os.Stat(filepath.Join(dir, first.ID+".pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestKeyRingAlgorithms(t *testing.T) {
+	user := Domain.User{ID: primitive.NewObjectID(), Username: "alice"}
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			dir := t.TempDir()
+			ring, err := NewKeyRing(KeyRingOptions{Algorithm: alg, Dir: dir})
+			require.NoError(t, err)
+			tokens := NewTokenService(ring)
+			issued, err := tokens.IssueAccessToken(user, "session", false)
+			require.NoError(t, err)
+			_, err = tokens.Parse(issued.Token, AccessTokenType)
+			assert.NoError(t, err)
+
+			jwks := ring.JWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, alg, jwks.Keys[0].Algorithm)
+			assert.Equal(t, "sig", jwks.Keys[0].Use)
+
+			// Switching the algorithm makes a new key but keeps the old one
+			// for verification.
+			other := AlgEdDSA
+			if alg == AlgEdDSA {
+				other = AlgRS256
+			}
+			switched, err := NewKeyRing(KeyRingOptions{Algorithm: other, Dir: dir, Grace: time.Hour})
+			require.NoError(t, err)
+			assert.Equal(t, other, switched.Current().Algorithm)
+			_, err = NewTokenService(switched).Parse(issued.Token, AccessTokenType)
+			assert.NoError(t, err)
+		})
+	}
+
+	_, err := NewKeyRing(KeyRingOptions{Algorithm: "none"})
+	assert.Error(t, err)
+	assert.Empty(t, NewHMACKeyRing(testSecret).JWKS().Keys, "shared secrets are never published")
+}
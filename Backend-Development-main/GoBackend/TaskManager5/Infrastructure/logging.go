@@ -0,0 +1,157 @@
+package Infrastructure
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id that ties a request to its log lines. It
+// is taken from the client or a proxy when it looks sane, and is always
+// sent back.
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// DefaultRedactedKeys are the log attributes, compared without regard to
+// case, whose values are replaced by "[REDACTED]": the credential headers,
+// and the password and token fields of requests.
+var DefaultRedactedKeys = []string{
+	"Authorization", "Cookie", "Set-Cookie", APIKeyHeader,
+	"password", "current_password", "new_password", "refresh_token", "mfa_token",
+}
+
+const redactedValue = "[REDACTED]"
+
+// NewLogger returns a logger writing JSON lines to w, from level up. The
+// values of attributes named in redact are replaced, and lines logged with
+// the context of a request carry its request_id and, once authenticated,
+// the user_id and api_key_id of the caller.
+func NewLogger(w io.Writer, level slog.Level, redact []string) *slog.Logger {
+	redacted := make(map[string]bool, len(redact))
+	for _, key := range redact {
+		redacted[strings.ToLower(key)] = true
+	}
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if redacted[strings.ToLower(a.Key)] {
+				return slog.String(a.Key, redactedValue)
+			}
+			return a
+		},
+	})
+	return slog.New(requestHandler{handler})
+}
+
+// requestHandler adds the attributes of the request a record was logged
+// for.
+type requestHandler struct {
+	slog.Handler
+}
+
+func (h requestHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+		if info.userID != "" {
+			r.AddAttrs(slog.String("user_id", info.userID))
+		}
+		if info.apiKeyID != "" {
+			r.AddAttrs(slog.String("api_key_id", info.apiKeyID))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
+
+// requestInfo is what the log knows about a request. SetPrincipal fills in
+// the caller.
+type requestInfo struct {
+	id       string
+	userID   string
+	apiKeyID string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// RequestIDMiddleware gives the request an id, from its X-Request-ID header
+// if that is a plausible id and a fresh one otherwise, and records it on the
+// request's context for the logger. It must run first, so that every later
+// log line has the id.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = NewTokenID(); err != nil {
+				c.Error(err)
+				c.Abort()
+				return
+			}
+		}
+		c.Header(RequestIDHeader, id)
+		ctx := context.WithValue(c.Request.Context(), requestInfoKey{}, &requestInfo{id: id})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// validRequestID accepts the ids proxies and clients commonly send, such
+// as UUIDs, and nothing that could garble a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_.:/+=", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogMiddleware logs one line for every request once it is answered:
+// who asked for what, the outcome and how long it took. Request headers are
+// included with the credentials among them redacted by the logger. Bodies
+// are never logged.
+func AccessLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		headers := make([]interface{}, 0, len(c.Request.Header))
+		for name, values := range c.Request.Header {
+			headers = append(headers, slog.String(name, strings.Join(values, ", ")))
+		}
+		logger.LogAttrs(c.Request.Context(), slog.LevelInfo, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+			slog.Group("headers", headers...),
+		)
+	}
+}
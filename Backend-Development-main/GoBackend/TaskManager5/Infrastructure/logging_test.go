@@ -0,0 +1,81 @@
+package Infrastructure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewLogger(&out, slog.LevelInfo, DefaultRedactedKeys)
+	userID := primitive.NewObjectID()
+
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), AccessLogMiddleware(logger))
+	router.GET("/tasks/:id", func(c *gin.Context) {
+		SetPrincipal(c, Domain.Principal{UserID: userID})
+		logger.InfoContext(c.Request.Context(), "handling", "password", "hunter2")
+		c.Status(http.StatusNoContent)
+	})
+
+	request := func(requestID string) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		out.Reset()
+		req, _ := http.NewRequest("GET", "/tasks/1", nil)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var lines []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+			lines = append(lines, entry)
+		}
+		return w, lines
+	}
+
+	w, lines := request("3f2b6c1e-8d4a-4a47-9b8e-2f0a5c7d9e11")
+	assert.Equal(t, "3f2b6c1e-8d4a-4a47-9b8e-2f0a5c7d9e11", w.Header().Get(RequestIDHeader))
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Equal(t, "3f2b6c1e-8d4a-4a47-9b8e-2f0a5c7d9e11", line["request_id"])
+		assert.Equal(t, userID.Hex(), line["user_id"])
+	}
+	assert.Equal(t, "[REDACTED]", lines[0]["password"])
+	access := lines[1]
+	assert.Equal(t, "request", access["msg"])
+	assert.Equal(t, "/tasks/:id", access["route"])
+	assert.Equal(t, float64(http.StatusNoContent), access["status"])
+	assert.Equal(t, "[REDACTED]", access["headers"].(map[string]interface{})["Authorization"])
+	assert.NotContains(t, out.String(), "secret-token")
+
+	w, lines = request("bad id\n{}")
+	generated := w.Header().Get(RequestIDHeader)
+	assert.NotEmpty(t, generated)
+	assert.NotEqual(t, "bad id\n{}", generated)
+	assert.Equal(t, generated, lines[1]["request_id"])
+}
+
+func TestLoggerWithoutRequest(t *testing.T) {
+	var out bytes.Buffer
+	NewLogger(&out, slog.LevelWarn, nil).InfoContext(context.Background(), "dropped")
+	NewLogger(&out, slog.LevelWarn, nil).WarnContext(context.Background(), "kept")
+	assert.NotContains(t, out.String(), "dropped")
+	assert.Contains(t, out.String(), `"msg":"kept"`)
+	assert.NotContains(t, out.String(), "request_id")
+}
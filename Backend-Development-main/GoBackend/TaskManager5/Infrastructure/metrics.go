@@ -0,0 +1,238 @@
+package Infrastructure
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "taskmanager"
+
+// Login steps, as reported to Metrics.ObserveLogin.
+const (
+	LoginStepPassword = "password"
+	LoginStepMFA      = "mfa"
+)
+
+// Outcomes of a repository operation. Not finding what was asked for is an
+// answer rather than a failure, so it is kept apart from errors.
+const (
+	outcomeSuccess  = "success"
+	outcomeNotFound = "not_found"
+	outcomeError    = "error"
+)
+
+const (
+	// taskCountTimeout bounds the queries made to count tasks.
+	taskCountTimeout = 5 * time.Second
+	// taskCountMaxAge is how long task counts are reused, so that frequent
+	// scrapes don't each query the store.
+	taskCountMaxAge = 30 * time.Second
+)
+
+// Metrics holds the Prometheus collectors of the service, in a registry of
+// its own. Outcomes are labelled with the code of the error they ended
+// with, as in a problem response. A nil *Metrics records nothing, so the
+// services that report to it work without one.
+type Metrics struct {
+	registry           *prometheus.Registry
+	httpRequests       *prometheus.CounterVec
+	httpDuration       *prometheus.HistogramVec
+	logins             *prometheus.CounterVec
+	tokenFailures      *prometheus.CounterVec
+	repositoryDuration *prometheus.HistogramVec
+	repositoryOutcomes *prometheus.CounterVec
+	repositoryErrors   *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_total",
+			Help:      "Login attempts, by step and result: success, mfa_required or an error code.",
+		}, []string{"step", "result"}),
+		tokenFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "token_validation_failures_total",
+			Help:      "Tokens rejected, by token type and reason.",
+		}, []string{"type", "reason"}),
+		repositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "repository_operation_duration_seconds",
+			Help:      "Time taken by repository operations, by repository and method.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"repository", "method"}),
+		repositoryOutcomes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "repository_operations_total",
+			Help:      "Repository operations, by repository, method and outcome: success, not_found or error.",
+		}, []string{"repository", "method", "outcome"}),
+		repositoryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "repository_operation_errors_total",
+			Help:      "Repository operations that failed, by repository, method and error code. Not found is not a failure.",
+		}, []string{"repository", "method", "code"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.logins, m.tokenFailures, m.repositoryDuration, m.repositoryOutcomes, m.repositoryErrors,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format. It is meant for
+// the separate metrics listener rather than the public API.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware counts and times every request by its route pattern, so that
+// ids in paths don't each get a series. It must run before ErrorMiddleware
+// to see the status that writes.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveLogin records the outcome of one step of a login. challenged
+// means the password was right but a second factor is still needed.
+func (m *Metrics) ObserveLogin(step string, challenged bool, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	switch {
+	case err != nil:
+		result = errorCode(err)
+	case challenged:
+		result = "mfa_required"
+	}
+	m.logins.WithLabelValues(step, result).Inc()
+}
+
+// ObserveTokenRejected records a token of tokenType that failed validation.
+func (m *Metrics) ObserveTokenRejected(tokenType, reason string) {
+	if m == nil {
+		return
+	}
+	m.tokenFailures.WithLabelValues(tokenType, reason).Inc()
+}
+
+// ObserveOperation records a repository call, making Metrics a
+// Repositories.OperationObserver.
+func (m *Metrics) ObserveOperation(repository, method string, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.repositoryDuration.WithLabelValues(repository, method).Observe(duration.Seconds())
+	var domainErr *Domain.Error
+	switch {
+	case err == nil:
+		m.repositoryOutcomes.WithLabelValues(repository, method, outcomeSuccess).Inc()
+	case errors.As(err, &domainErr) && domainErr.Kind == Domain.KindNotFound:
+		m.repositoryOutcomes.WithLabelValues(repository, method, outcomeNotFound).Inc()
+	default:
+		m.repositoryOutcomes.WithLabelValues(repository, method, outcomeError).Inc()
+		m.repositoryErrors.WithLabelValues(repository, method, errorCode(err)).Inc()
+	}
+}
+
+func errorCode(err error) string {
+	return NewProblem(err, "").Code
+}
+
+// TaskCounter counts tasks through the Total of a page, such as a
+// Repositories.TaskRepository.
+type TaskCounter interface {
+	GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error)
+}
+
+// CountTasks exports the number of tasks in each status. The counts are
+// taken at most every taskCountMaxAge and reused in between.
+func (m *Metrics) CountTasks(tasks TaskCounter) {
+	m.registry.MustRegister(&taskCollector{
+		tasks: tasks,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "tasks"),
+			"Tasks stored, by status.", []string{"status"}, nil),
+	})
+}
+
+type taskCollector struct {
+	tasks TaskCounter
+	desc  *prometheus.Desc
+
+	mu        sync.Mutex
+	counts    map[Domain.TaskStatus]int64
+	countedAt time.Time
+}
+
+func (tc *taskCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tc.desc
+}
+
+func (tc *taskCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := tc.current()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(tc.desc, err)
+		return
+	}
+	for _, status := range Domain.TaskStatuses {
+		ch <- prometheus.MustNewConstMetric(tc.desc, prometheus.GaugeValue, float64(counts[status]), string(status))
+	}
+}
+
+// current returns the cached counts, counting again once they are older
+// than taskCountMaxAge. A failed count is not cached, so the next scrape
+// tries again.
+func (tc *taskCollector) current() (map[Domain.TaskStatus]int64, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.counts != nil && time.Since(tc.countedAt) < taskCountMaxAge {
+		return tc.counts, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), taskCountTimeout)
+	defer cancel()
+	counts := make(map[Domain.TaskStatus]int64, len(Domain.TaskStatuses))
+	for _, status := range Domain.TaskStatuses {
+		page, err := tc.tasks.GetTasks(ctx, Domain.TaskFilter{Status: status, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		counts[status] = page.Total
+	}
+	tc.counts, tc.countedAt = counts, time.Now()
+	return counts, nil
+}
@@ -0,0 +1,127 @@
+package Infrastructure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type taskTotals map[Domain.TaskStatus]int64
+
+func (t taskTotals) GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	return &Domain.TaskPage{Total: t[filter.Status]}, nil
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.CountTasks(taskTotals{Domain.StatusTodo: 2, Domain.StatusDone: 1})
+	router := gin.New()
+	router.Use(metrics.Middleware(), ErrorMiddleware())
+	router.GET("/metrics", metrics.Handler())
+	router.GET("/tasks/:id", func(c *gin.Context) { c.Error(Domain.ErrTaskNotFound) })
+
+	request := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	request("/tasks/1")
+	request("/tasks/2")
+	request("/nowhere")
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("GET", "/tasks/:id", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("GET", "unmatched", "404")))
+
+	metrics.ObserveLogin(LoginStepPassword, false, nil)
+	metrics.ObserveLogin(LoginStepPassword, true, nil)
+	metrics.ObserveLogin(LoginStepPassword, false, Domain.ErrInvalidCredentials)
+	metrics.ObserveLogin(LoginStepMFA, false, Domain.ErrInvalidMFACode)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logins.WithLabelValues("password", "success")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logins.WithLabelValues("password", "mfa_required")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logins.WithLabelValues("password", "invalid_credentials")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.logins.WithLabelValues("mfa", "invalid_mfa_code")))
+
+	metrics.ObserveOperation("tasks", "GetTask", time.Millisecond, nil)
+	metrics.ObserveOperation("tasks", "GetTask", time.Millisecond, Domain.ErrTaskNotFound)
+	metrics.ObserveOperation("tasks", "GetTask", time.Millisecond, errors.New("connection reset"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.repositoryOutcomes.WithLabelValues("tasks", "GetTask", "success")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.repositoryOutcomes.WithLabelValues("tasks", "GetTask", "not_found")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.repositoryOutcomes.WithLabelValues("tasks", "GetTask", "error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.repositoryErrors), "not found is not an error")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.repositoryErrors.WithLabelValues("tasks", "GetTask", "internal_error")))
+
+	w := request("/metrics")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `taskmanager_tasks{status="todo"} 2`)
+	assert.Contains(t, w.Body.String(), `taskmanager_tasks{status="blocked"} 0`)
+	assert.Contains(t, w.Body.String(), `taskmanager_repository_operation_duration_seconds_count{method="GetTask",repository="tasks"} 3`)
+}
+
+type countingTasks struct {
+	taskTotals
+	queries int
+}
+
+func (c *countingTasks) GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	c.queries++
+	return c.taskTotals.GetTasks(ctx, filter)
+}
+
+func TestTaskCountsCached(t *testing.T) {
+	tasks := &countingTasks{taskTotals: taskTotals{Domain.StatusTodo: 2}}
+	metrics := NewMetrics()
+	metrics.CountTasks(tasks)
+
+	_, err := metrics.registry.Gather()
+	require.NoError(t, err)
+	queries := tasks.queries
+	assert.Equal(t, len(Domain.TaskStatuses), queries)
+
+	tasks.taskTotals[Domain.StatusTodo] = 3
+	families, err := metrics.registry.Gather()
+	require.NoError(t, err)
+	assert.Equal(t, queries, tasks.queries, "a second scrape reuses the counts")
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if family.GetName() == "taskmanager_tasks" && metric.GetLabel()[0].GetValue() == "todo" {
+				assert.Equal(t, 2.0, metric.GetGauge().GetValue())
+			}
+		}
+	}
+}
+
+func TestTokenRejectionMetrics(t *testing.T) {
+	tokens := NewTokenService(NewHMACKeyRing(testSecret))
+	tokens.Metrics = NewMetrics()
+	user := Domain.User{ID: primitive.NewObjectID(), Username: "alice", Role: Domain.RoleUser}
+
+	_, err := tokens.Parse("not.a.token", AccessTokenType)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+	refresh, err := tokens.IssueRefreshToken(user, "session")
+	require.NoError(t, err)
+	_, err = tokens.Parse(refresh.Token, AccessTokenType)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tokens.Metrics.tokenFailures.WithLabelValues(AccessTokenType, "signature")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tokens.Metrics.tokenFailures.WithLabelValues(AccessTokenType, "type")))
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var metrics *Metrics
+	assert.NotPanics(t, func() {
+		metrics.ObserveLogin(LoginStepPassword, false, nil)
+		metrics.ObserveTokenRejected(AccessTokenType, "signature")
+		metrics.ObserveOperation("tasks", "GetTask", time.Millisecond, nil)
+	})
+}
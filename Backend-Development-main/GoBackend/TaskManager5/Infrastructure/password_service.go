@@ -1,15 +1,209 @@
 package Infrastructure
 
-import "golang.org/x/crypto/bcrypt"
+import (
+    "bufio"
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
 
-func HashPassword(password string) (string, error) {
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    "TaskManager5/Domain"
+
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/bcrypt"
+)
+
+// ErrPasswordMismatch is returned when a password does not match its hash.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// ErrUnknownPasswordHash is returned for a stored hash no hasher recognizes.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into a self-describing string that names
+// the algorithm and its parameters, so that it can be verified later even
+// after the configured parameters change.
+type PasswordHasher interface {
+    Hash(password string) (string, error)
+    // Recognizes reports whether hash is in this hasher's format.
+    Recognizes(hash string) bool
+    // Verify checks password against a hash in this hasher's format and
+    // returns ErrPasswordMismatch if it does not match.
+    Verify(password, hash string) error
+    // NeedsRehash reports whether hash was made with other parameters than
+    // the hasher's own.
+    NeedsRehash(hash string) bool
+}
+
+// BcryptMaxPasswordBytes is as much of a password as bcrypt looks at; the
+// rest would be silently ignored.
+const BcryptMaxPasswordBytes = 72
+
+// BcryptHasher produces the usual $2a$ bcrypt hashes.
+type BcryptHasher struct {
+    Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
     if err != nil {
         return "", err
     }
-    return string(hashedPassword), nil
+    return string(hash), nil
+}
+
+func (h BcryptHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h BcryptHasher) Verify(password, hash string) error {
+    err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+    if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+        return ErrPasswordMismatch
+    }
+    return err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+    cost, err := bcrypt.Cost([]byte(hash))
+    return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher produces hashes in the PHC string format, for example
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>. Memory is in KiB.
+type Argon2idHasher struct {
+    Memory      uint32
+    Iterations  uint32
+    Parallelism uint8
+    SaltLength  uint32
+    KeyLength   uint32
+}
+
+// DefaultArgon2idHasher uses the parameters OWASP recommends as a minimum.
+var DefaultArgon2idHasher = Argon2idHasher{
+    Memory:      19 * 1024,
+    Iterations:  2,
+    Parallelism: 1,
+    SaltLength:  16,
+    KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+    salt := make([]byte, h.SaltLength)
+    if _, err := rand.Read(salt); err != nil {
+        return "", err
+    }
+    key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+    return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+        h.Memory, h.Iterations, h.Parallelism,
+        base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
 }
 
-func CheckPasswordHash(password, hash string) error {
-    return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+func (h Argon2idHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (h Argon2idHasher) Verify(password, hash string) error {
+    params, salt, key, err := parseArgon2idHash(hash)
+    if err != nil {
+        return err
+    }
+    candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+    if subtle.ConstantTimeCompare(candidate, key) != 1 {
+        return ErrPasswordMismatch
+    }
+    return nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+    params, salt, key, err := parseArgon2idHash(hash)
+    return err != nil || params.Memory != h.Memory || params.Iterations != h.Iterations ||
+        params.Parallelism != h.Parallelism || uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+// parseArgon2idHash splits a PHC string into its parameters, salt and key.
+func parseArgon2idHash(hash string) (params Argon2idHasher, salt, key []byte, err error) {
+    parts := strings.Split(hash, "$")
+    if len(parts) != 6 || parts[1] != "argon2id" {
+        return params, nil, nil, ErrUnknownPasswordHash
+    }
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+        return params, nil, nil, fmt.Errorf("%w: argon2 version %q", ErrUnknownPasswordHash, parts[2])
+    }
+    if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+        return params, nil, nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnknownPasswordHash, parts[3])
+    }
+    if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+        return params, nil, nil, fmt.Errorf("%w: argon2 salt: %v", ErrUnknownPasswordHash, err)
+    }
+    if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+        return params, nil, nil, fmt.Errorf("%w: argon2 key", ErrUnknownPasswordHash)
+    }
+    return params, salt, key, nil
+}
+
+// PasswordService hashes new passwords with the preferred hasher and
+// verifies stored hashes with whichever hasher recognizes them, so that
+// changing the algorithm or its parameters never locks anybody out.
+type PasswordService struct {
+    preferred PasswordHasher
+    hashers   []PasswordHasher
+}
+
+// NewPasswordService prefers the given hasher. Hashes made by bcrypt or
+// argon2id with any parameters can always be verified; others lists any
+// further formats that should be.
+func NewPasswordService(preferred PasswordHasher, others ...PasswordHasher) *PasswordService {
+    hashers := append([]PasswordHasher{preferred}, others...)
+    hashers = append(hashers, BcryptHasher{Cost: bcrypt.DefaultCost}, DefaultArgon2idHasher)
+    return &PasswordService{preferred: preferred, hashers: hashers}
+}
+
+func (s *PasswordService) Hash(password string) (string, error) {
+    return s.preferred.Hash(password)
+}
+
+// Verify checks password against hash. On a match, rehash reports whether
+// the hash should be replaced by a fresh one from Hash because it was made
+// with another algorithm or other parameters than the preferred ones.
+func (s *PasswordService) Verify(password, hash string) (rehash bool, err error) {
+    for _, hasher := range s.hashers {
+        if !hasher.Recognizes(hash) {
+            continue
+        }
+        if err := hasher.Verify(password, hash); err != nil {
+            return false, err
+        }
+        return !s.preferred.Recognizes(hash) || s.preferred.NeedsRehash(hash), nil
+    }
+    return false, ErrUnknownPasswordHash
+}
+
+// LoadPasswordBlocklist reads a file with one blocked password per line.
+// Blank lines and lines starting with # are skipped.
+func LoadPasswordBlocklist(path string) (Domain.PasswordBlocklist, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("reading password blocklist: %w", err)
+    }
+    defer file.Close()
+
+    blocklist := make(Domain.PasswordBlocklist)
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        blocklist[strings.ToLower(line)] = struct{}{}
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("reading password blocklist: %w", err)
+    }
+    return blocklist, nil
 }
@@ -0,0 +1,82 @@
+package Infrastructure
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	cheapArgon2 := Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	for name, hasher := range map[string]PasswordHasher{
+		"bcrypt":   BcryptHasher{Cost: bcrypt.MinCost},
+		"argon2id": cheapArgon2,
+	} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := hasher.Hash("correct horse")
+			require.NoError(t, err)
+			assert.True(t, hasher.Recognizes(hash))
+			assert.NoError(t, hasher.Verify("correct horse", hash))
+			assert.ErrorIs(t, hasher.Verify("wrong horse", hash), ErrPasswordMismatch)
+			assert.False(t, hasher.NeedsRehash(hash))
+
+			other, err := hasher.Hash("correct horse")
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other, "hashes are salted")
+		})
+	}
+
+	hash, err := cheapArgon2.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=1,p=1$"), hash)
+	stronger := cheapArgon2
+	stronger.Iterations = 2
+	assert.True(t, stronger.NeedsRehash(hash))
+	assert.NoError(t, stronger.Verify("correct horse", hash), "parameters are read from the hash")
+	assert.ErrorIs(t, stronger.Verify("correct horse", "$argon2id$v=19$garbage"), ErrUnknownPasswordHash)
+	assert.True(t, BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(mustHash(t, BcryptHasher{Cost: bcrypt.MinCost}, "x")))
+}
+
+func TestPasswordServiceMigration(t *testing.T) {
+	bcryptHash := mustHash(t, BcryptHasher{Cost: bcrypt.MinCost}, "correct horse")
+	service := NewPasswordService(Argon2idHasher{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+
+	rehash, err := service.Verify("correct horse", bcryptHash)
+	assert.NoError(t, err)
+	assert.True(t, rehash, "bcrypt hashes move to the preferred argon2id")
+	_, err = service.Verify("wrong horse", bcryptHash)
+	assert.ErrorIs(t, err, ErrPasswordMismatch)
+
+	hash, err := service.Hash("correct horse")
+	require.NoError(t, err)
+	rehash, err = service.Verify("correct horse", hash)
+	assert.NoError(t, err)
+	assert.False(t, rehash)
+
+	_, err = service.Verify("correct horse", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownPasswordHash)
+}
+
+func mustHash(t *testing.T, hasher PasswordHasher, password string) string {
+	t.Helper()
+	hash, err := hasher.Hash(password)
+	require.NoError(t, err)
+	return hash
+}
+
+func TestLoadPasswordBlocklist(t *testing.T) {
+	path := writeFile(t, "blocklist.txt", "# common passwords\n123456\n\nPassword1\n  qwerty  \n")
+
+	blocklist, err := LoadPasswordBlocklist(path)
+	require.NoError(t, err)
+	assert.Len(t, blocklist, 3)
+	assert.True(t, blocklist.Contains("password1"))
+	assert.True(t, blocklist.Contains("QWERTY"))
+	assert.False(t, blocklist.Contains("# common passwords"))
+
+	_, err = LoadPasswordBlocklist("/does/not/exist")
+	assert.Error(t, err)
+}
@@ -0,0 +1,21 @@
+package Infrastructure
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeoutMiddleware gives the request's context a deadline of timeout from
+// now. Handlers pass that context down to the repositories, so a slow
+// database call is abandoned once the deadline passes, and as soon as the
+// client goes away.
+func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,27 @@
+package Infrastructure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(TimeoutMiddleware(time.Minute))
+	var deadline time.Time
+	var hasDeadline bool
+	router.GET("/", func(c *gin.Context) {
+		deadline, hasDeadline = c.Request.Context().Deadline()
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+}
@@ -0,0 +1,121 @@
+package Infrastructure
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+
+	DefaultTOTPIssuer = "TaskManager"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP generates and checks RFC 6238 one-time codes with the parameters
+// every authenticator app supports: HMAC-SHA1, six digits and 30-second
+// steps. Issuer names the service in the app. Skew is how many steps
+// either side of the current one are accepted, for clocks that drift.
+type TOTP struct {
+	Issuer string
+	Skew   int64
+}
+
+var DefaultTOTP = TOTP{Issuer: DefaultTOTPIssuer, Skew: 1}
+
+// NewTOTPSecret returns a random 160-bit secret in base32, the form
+// authenticator apps take it in.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI that authenticator apps read from a QR code.
+func (t TOTP) URI(secret, account string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {t.Issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(t.Issuer+":"+account) + "?" + query.Encode()
+}
+
+// Code returns the code for the time step containing at.
+func (t TOTP) Code(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, at.Unix()/totpPeriod), nil
+}
+
+// Verify checks code against the steps around now and returns the step it
+// matched. Steps up to lastStep have been used already and are refused, so
+// that each code works only once.
+func (t TOTP) Verify(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - t.Skew; step <= current+t.Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp is the RFC 4226 code for the counter.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns n one-time recovery codes, such as "k7qmx-2fd4a",
+// and the hashes to store for them.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(b)[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashSecret(code)
+}
@@ -0,0 +1,69 @@
+package Infrastructure
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The SHA-1 test vectors of RFC 6238, cut to six digits.
+func TestTOTPCodes(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := DefaultTOTP.Code(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "at %d", unix)
+	}
+}
+
+// A code is accepted one step either side of its own and only once.
+func TestTOTPVerify(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code, err := DefaultTOTP.Code(secret, now)
+	require.NoError(t, err)
+
+	step, ok := DefaultTOTP.Verify(secret, code, now.Add(totpPeriod*time.Second), 0)
+	require.True(t, ok)
+	assert.Equal(t, now.Unix()/totpPeriod, step)
+	_, ok = DefaultTOTP.Verify(secret, code, now, step)
+	assert.False(t, ok, "replayed code")
+	_, ok = DefaultTOTP.Verify(secret, code, now.Add(2*totpPeriod*time.Second), 0)
+	assert.False(t, ok, "code too old")
+	_, ok = DefaultTOTP.Verify(secret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTP{Issuer: "Task Manager"}.URI("JBSWY3DPEHPK3PXP", "alice"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Task Manager:alice", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Task Manager", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+	assert.NotEqual(t, codes[0], codes[1])
+	assert.Equal(t, hashes[0], HashRecoveryCode(codes[0]))
+	typed := strings.ToUpper(strings.Replace(codes[0], "-", " ", 1))
+	assert.Equal(t, hashes[0], HashRecoveryCode(typed), "case and separators don't matter")
+}
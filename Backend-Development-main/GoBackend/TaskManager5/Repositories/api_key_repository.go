@@ -0,0 +1,107 @@
+package Repositories
+
+import (
+	"context"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key Domain.APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*Domain.APIKey, error)
+	// ListAPIKeys returns the user's keys, including expired and revoked
+	// ones, newest first.
+	ListAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]Domain.APIKey, error)
+	// RevokeAPIKey revokes one of the user's keys. Revoking a key again
+	// keeps the time it was first revoked.
+	RevokeAPIKey(ctx context.Context, id string, userID primitive.ObjectID, at time.Time) error
+	// TouchAPIKey records when the key was last used.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+type apiKeyRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+func NewAPIKeyRepository(db *mongo.Database, timeout time.Duration) APIKeyRepository {
+	return &apiKeyRepository{collection: db.Collection("api_keys"), timeout: timeout}
+}
+
+// ensureAPIKeyIndexes indexes keys by owner for listing.
+func ensureAPIKeyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("api_keys").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+func (ar *apiKeyRepository) CreateAPIKey(ctx context.Context, key Domain.APIKey) error {
+	ctx, cancel := operationContext(ctx, ar.timeout)
+	defer cancel()
+	_, err := ar.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (ar *apiKeyRepository) GetAPIKey(ctx context.Context, id string) (*Domain.APIKey, error) {
+	ctx, cancel := operationContext(ctx, ar.timeout)
+	defer cancel()
+	var key Domain.APIKey
+	err := ar.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, Domain.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (ar *apiKeyRepository) ListAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]Domain.APIKey, error) {
+	ctx, cancel := operationContext(ctx, ar.timeout)
+	defer cancel()
+	cursor, err := ar.collection.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	keys := []Domain.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (ar *apiKeyRepository) RevokeAPIKey(ctx context.Context, id string, userID primitive.ObjectID, at time.Time) error {
+	ctx, cancel := operationContext(ctx, ar.timeout)
+	defer cancel()
+	result, err := ar.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID},
+		bson.A{bson.M{"$set": bson.M{"revoked_at": bson.M{"$ifNull": bson.A{"$revoked_at", at}}}}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return Domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (ar *apiKeyRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := operationContext(ctx, ar.timeout)
+	defer cancel()
+	result, err := ar.collection.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"last_used_at": at}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return Domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,806 @@
+package Repositories
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"TaskManager5/Domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every storage backend must pass these suites. The Mongo backend only runs
+// when MONGODB_URI points at a server that can be freely written to.
+
+func TestInMemoryRepositoriesConformance(t *testing.T) {
+	testTaskRepositoryConformance(t, func(t *testing.T) TaskRepository {
+		return NewInMemoryTaskRepository()
+	})
+	testUserRepositoryConformance(t, func(t *testing.T) UserRepository {
+		return NewInMemoryUserRepository()
+	})
+	testTokenRepositoryConformance(t, func(t *testing.T) TokenRepository {
+		return NewInMemoryTokenRepository()
+	})
+	testLoginAttemptRepositoryConformance(t, func(t *testing.T) LoginAttemptRepository {
+		return NewInMemoryLoginAttemptRepository()
+	})
+	testRoleRepositoryConformance(t, func(t *testing.T) RoleRepository {
+		return NewInMemoryRoleRepository()
+	})
+	testAPIKeyRepositoryConformance(t, func(t *testing.T) APIKeyRepository {
+		return NewInMemoryAPIKeyRepository()
+	})
+}
+
+// operationLog records the operations an instrumented repository reports.
+type operationLog struct {
+	operations []string
+}
+
+func (l *operationLog) ObserveOperation(repository, method string, duration time.Duration, err error) {
+	l.operations = append(l.operations, fmt.Sprintf("%s.%s %v", repository, method, err))
+}
+
+// The decorators must behave exactly like the repository they wrap.
+func TestInstrumentedRepositoriesConformance(t *testing.T) {
+	log := &operationLog{}
+	testTaskRepositoryConformance(t, func(t *testing.T) TaskRepository {
+		return NewInstrumentedTaskRepository(NewInMemoryTaskRepository(), log)
+	})
+	testUserRepositoryConformance(t, func(t *testing.T) UserRepository {
+		return NewInstrumentedUserRepository(NewInMemoryUserRepository(), log)
+	})
+	assert.Contains(t, log.operations, "tasks.CreateTask <nil>")
+	assert.Contains(t, log.operations, "users.GetUserByID user not found")
+}
+
+func TestFileRepositoriesConformance(t *testing.T) {
+	testTaskRepositoryConformance(t, func(t *testing.T) TaskRepository {
+		repo, err := NewFileTaskRepository(t.TempDir() + "/tasks.json")
+		require.NoError(t, err)
+		return repo
+	})
+	testUserRepositoryConformance(t, func(t *testing.T) UserRepository {
+		repo, err := NewFileUserRepository(t.TempDir() + "/users.json")
+		require.NoError(t, err)
+		return repo
+	})
+	testTokenRepositoryConformance(t, func(t *testing.T) TokenRepository {
+		repo, err := NewFileTokenRepository(t.TempDir() + "/tokens.json")
+		require.NoError(t, err)
+		return repo
+	})
+	testRoleRepositoryConformance(t, func(t *testing.T) RoleRepository {
+		repo, err := NewFileRoleRepository(t.TempDir() + "/roles.json")
+		require.NoError(t, err)
+		return repo
+	})
+	testAPIKeyRepositoryConformance(t, func(t *testing.T) APIKeyRepository {
+		repo, err := NewFileAPIKeyRepository(t.TempDir() + "/api_keys.json")
+		require.NoError(t, err)
+		return repo
+	})
+}
+
+func TestMongoRepositoriesConformance(t *testing.T) {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	newDB := func(t *testing.T) *mongo.Database {
+		db := client.Database(fmt.Sprintf("conformance_%s", primitive.NewObjectID().Hex()))
+		t.Cleanup(func() { db.Drop(context.Background()) })
+		return db
+	}
+	testTaskRepositoryConformance(t, func(t *testing.T) TaskRepository {
+		return NewTaskRepository(newDB(t), 0)
+	})
+	testUserRepositoryConformance(t, func(t *testing.T) UserRepository {
+		return NewUserRepository(newDB(t), 0)
+	})
+	testTokenRepositoryConformance(t, func(t *testing.T) TokenRepository {
+		return NewTokenRepository(newDB(t), 0)
+	})
+	testRoleRepositoryConformance(t, func(t *testing.T) RoleRepository {
+		db := newDB(t)
+		require.NoError(t, ensureDefaultRoles(context.Background(), db))
+		return NewRoleRepository(db, 0)
+	})
+	testAPIKeyRepositoryConformance(t, func(t *testing.T) APIKeyRepository {
+		return NewAPIKeyRepository(newDB(t), 0)
+	})
+}
+
+func TestMongoMigrateTaskStatuses(t *testing.T) {
+	uri := os.Getenv("MONGODB_URI")
+	if uri == "" {
+		t.Skip("MONGODB_URI not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	db := client.Database(fmt.Sprintf("conformance_%s", primitive.NewObjectID().Hex()))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+
+	owner := primitive.NewObjectID()
+	for _, status := range []string{"pending", "Completed", "in progress", "todo", "someday"} {
+		_, err := db.Collection("tasks").InsertOne(ctx, bson.M{"title": status, "status": status, "user_id": owner})
+		require.NoError(t, err)
+	}
+	require.NoError(t, migrateTaskStatuses(ctx, db))
+	require.NoError(t, migrateTaskStatuses(ctx, db), "running again changes nothing")
+
+	repo := NewTaskRepository(db, 0)
+	for status, want := range map[Domain.TaskStatus]int64{Domain.StatusTodo: 2, Domain.StatusDone: 1, Domain.StatusInProgress: 1, "someday": 1} {
+		page, err := repo.GetTasks(ctx, Domain.TaskFilter{Status: status})
+		require.NoError(t, err)
+		assert.Equal(t, want, page.Total, status)
+	}
+}
+
+func testTaskRepositoryConformance(t *testing.T, newRepo func(t *testing.T) TaskRepository) {
+	ctx := context.Background()
+	owner := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+	due := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("TaskCreateAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateTask(ctx, Domain.Task{Title: "Write report", Status: Domain.StatusTodo, UserID: owner, DueDate: due})
+		require.NoError(t, err)
+		assert.False(t, created.ID.IsZero())
+		assert.False(t, created.CreatedAt.IsZero())
+
+		fetched, err := repo.GetTask(ctx, created.ID.Hex(), owner.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, "Write report", fetched.Title)
+		assert.Equal(t, owner, fetched.UserID)
+		assert.True(t, due.Equal(fetched.DueDate))
+
+		fetched, err = repo.GetTask(ctx, created.ID.Hex(), "")
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, fetched.ID)
+	})
+
+	t.Run("TaskNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetTask(ctx, primitive.NewObjectID().Hex(), "")
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+		_, err = repo.GetTask(ctx, "not-an-id", "")
+		assert.ErrorIs(t, err, Domain.ErrInvalidID)
+		assert.ErrorIs(t, repo.DeleteTask(ctx, primitive.NewObjectID().Hex(), "", 0), Domain.ErrTaskNotFound)
+	})
+
+	t.Run("TaskOwnerScope", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateTask(ctx, Domain.Task{Title: "Private", UserID: owner})
+		require.NoError(t, err)
+
+		_, err = repo.GetTask(ctx, created.ID.Hex(), other.Hex())
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+		_, err = repo.UpdateTask(ctx, created.ID.Hex(), other.Hex(), 0, Domain.Task{Title: "Stolen", UserID: other})
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+		assert.ErrorIs(t, repo.DeleteTask(ctx, created.ID.Hex(), other.Hex(), 0), Domain.ErrTaskNotFound)
+
+		fetched, err := repo.GetTask(ctx, created.ID.Hex(), owner.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, "Private", fetched.Title)
+	})
+
+	t.Run("TaskUpdateAndDelete", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateTask(ctx, Domain.Task{Title: "Draft", Status: Domain.StatusTodo, UserID: owner})
+		require.NoError(t, err)
+
+		history := []Domain.StatusChange{{From: Domain.StatusTodo, To: Domain.StatusDone, Actor: owner, At: due}}
+		updated, err := repo.UpdateTask(ctx, created.ID.Hex(), owner.Hex(), created.Version, Domain.Task{
+			Title: "Final", Description: "Done", Status: Domain.StatusDone, UserID: owner, DueDate: due,
+			StatusHistory: history,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Final", updated.Title)
+		assert.Equal(t, Domain.StatusDone, updated.Status)
+		require.Len(t, updated.StatusHistory, 1)
+		assert.Equal(t, Domain.StatusDone, updated.StatusHistory[0].To)
+		assert.True(t, updated.StatusHistory[0].At.Equal(due))
+		assert.False(t, updated.UpdatedAt.Before(created.UpdatedAt))
+
+		require.NoError(t, repo.DeleteTask(ctx, created.ID.Hex(), owner.Hex(), 0))
+		_, err = repo.GetTask(ctx, created.ID.Hex(), "")
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+	})
+
+	t.Run("TaskPatch", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateTask(ctx, Domain.Task{
+			Title: "Draft", Description: "Keep me", Status: Domain.StatusTodo, UserID: owner, DueDate: due,
+			StatusHistory: []Domain.StatusChange{{To: Domain.StatusTodo, Actor: owner, At: due}},
+		})
+		require.NoError(t, err)
+
+		status := Domain.StatusInProgress
+		title := "Started"
+		patched, err := repo.PatchTask(ctx, created.ID.Hex(), owner.Hex(), 0, Domain.TaskPatch{
+			Title:        &title,
+			Status:       &status,
+			StatusChange: &Domain.StatusChange{From: Domain.StatusTodo, To: status, Actor: owner, At: due},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Started", patched.Title)
+		assert.Equal(t, "Keep me", patched.Description)
+		assert.Equal(t, owner, patched.UserID)
+		assert.True(t, patched.DueDate.Equal(due))
+		assert.Equal(t, status, patched.Status)
+		require.Len(t, patched.StatusHistory, 2)
+		assert.Equal(t, status, patched.StatusHistory[1].To)
+
+		_, err = repo.PatchTask(ctx, created.ID.Hex(), primitive.NewObjectID().Hex(), 0, Domain.TaskPatch{Title: &title})
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+	})
+
+	t.Run("TaskVersions", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateTask(ctx, Domain.Task{Title: "Draft", Status: Domain.StatusTodo, UserID: owner})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), created.Version)
+
+		updated, err := repo.UpdateTask(ctx, created.ID.Hex(), "", 1, Domain.Task{Title: "First", Status: Domain.StatusTodo})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), updated.Version)
+
+		_, err = repo.UpdateTask(ctx, created.ID.Hex(), "", 1, Domain.Task{Title: "Lost update", Status: Domain.StatusTodo})
+		assert.ErrorIs(t, err, Domain.ErrVersionMismatch)
+		title := "Lost patch"
+		_, err = repo.PatchTask(ctx, created.ID.Hex(), "", 1, Domain.TaskPatch{Title: &title})
+		assert.ErrorIs(t, err, Domain.ErrVersionMismatch)
+		assert.ErrorIs(t, repo.DeleteTask(ctx, created.ID.Hex(), "", 1), Domain.ErrVersionMismatch)
+		_, err = repo.PatchTask(ctx, created.ID.Hex(), primitive.NewObjectID().Hex(), 2, Domain.TaskPatch{Title: &title})
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+
+		title = "Second"
+		patched, err := repo.PatchTask(ctx, created.ID.Hex(), "", 2, Domain.TaskPatch{Title: &title})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), patched.Version)
+
+		fetched, err := repo.GetTask(ctx, created.ID.Hex(), "")
+		require.NoError(t, err)
+		assert.Equal(t, "Second", fetched.Title)
+		assert.NoError(t, repo.DeleteTask(ctx, created.ID.Hex(), "", 3))
+	})
+
+	t.Run("TasksByUserID", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, userID := range []primitive.ObjectID{owner, owner, other} {
+			_, err := repo.CreateTask(ctx, Domain.Task{Title: "Task", UserID: userID})
+			require.NoError(t, err)
+		}
+		page, err := repo.GetTasks(ctx, Domain.TaskFilter{UserID: owner.Hex()}.WithDefaults())
+		require.NoError(t, err)
+		assert.Len(t, page.Tasks, 2)
+		assert.EqualValues(t, 2, page.Total)
+	})
+
+	t.Run("TasksReassignAndDeleteByUser", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, userID := range []primitive.ObjectID{owner, owner, other} {
+			_, err := repo.CreateTask(ctx, Domain.Task{Title: "Task", UserID: userID})
+			require.NoError(t, err)
+		}
+
+		reassigned, err := repo.ReassignTasks(ctx, owner.Hex(), other.Hex())
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, reassigned)
+		page, err := repo.GetTasks(ctx, Domain.TaskFilter{UserID: other.Hex()}.WithDefaults())
+		require.NoError(t, err)
+		require.Len(t, page.Tasks, 3)
+		bumped := 0
+		for _, task := range page.Tasks {
+			if task.Version == 2 {
+				bumped++
+			}
+		}
+		assert.Equal(t, 2, bumped, "reassigning bumps the version")
+
+		deleted, err := repo.DeleteTasksByUserID(ctx, other.Hex())
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, deleted)
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{UserID: other.Hex()}.WithDefaults())
+		require.NoError(t, err)
+		assert.Empty(t, page.Tasks)
+	})
+
+	t.Run("TaskListFilters", func(t *testing.T) {
+		repo := newRepo(t)
+		seed := []Domain.Task{
+			{Title: "Buy milk", Status: Domain.StatusTodo, UserID: owner, DueDate: due},
+			{Title: "Buy bread", Status: Domain.StatusDone, UserID: owner, DueDate: due.Add(48 * time.Hour)},
+			{Title: "File taxes", Status: Domain.StatusTodo, UserID: other, DueDate: due.Add(24 * time.Hour)},
+		}
+		for _, task := range seed {
+			_, err := repo.CreateTask(ctx, task)
+			require.NoError(t, err)
+		}
+
+		page, err := repo.GetTasks(ctx, Domain.TaskFilter{Status: Domain.StatusTodo})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{UserID: owner.Hex()})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{Owners: []string{owner.Hex(), other.Hex()}, Status: Domain.StatusTodo})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{Owners: []string{other.Hex()}, UserID: owner.Hex()})
+		require.NoError(t, err)
+		assert.Zero(t, page.Total, "UserID can only narrow Owners")
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{Search: "BUY"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), page.Total)
+
+		after, before := due.Add(time.Hour), due.Add(48*time.Hour)
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{DueAfter: &after, DueBefore: &before})
+		require.NoError(t, err)
+		require.Len(t, page.Tasks, 1)
+		assert.Equal(t, "File taxes", page.Tasks[0].Title)
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{SortBy: "title"})
+		require.NoError(t, err)
+		require.Len(t, page.Tasks, 3)
+		assert.Equal(t, "Buy bread", page.Tasks[0].Title)
+
+		page, err = repo.GetTasks(ctx, Domain.TaskFilter{SortBy: "due_date", SortDesc: true})
+		require.NoError(t, err)
+		require.Len(t, page.Tasks, 3)
+		assert.Equal(t, "Buy bread", page.Tasks[0].Title)
+		assert.Equal(t, "Buy milk", page.Tasks[2].Title)
+	})
+
+	t.Run("TaskListPagination", func(t *testing.T) {
+		repo := newRepo(t)
+		for i := 0; i < 5; i++ {
+			_, err := repo.CreateTask(ctx, Domain.Task{Title: "Same title", UserID: owner, DueDate: due})
+			require.NoError(t, err)
+		}
+
+		for _, desc := range []bool{false, true} {
+			filter := Domain.TaskFilter{SortBy: "title", SortDesc: desc, Limit: 2}
+			seen := map[primitive.ObjectID]bool{}
+			pages := 0
+			for {
+				page, err := repo.GetTasks(ctx, filter)
+				require.NoError(t, err)
+				assert.Equal(t, int64(5), page.Total)
+				for _, task := range page.Tasks {
+					assert.False(t, seen[task.ID], "task %s returned twice", task.ID.Hex())
+					seen[task.ID] = true
+				}
+				pages++
+				if page.NextCursor == "" {
+					break
+				}
+				filter.Cursor = page.NextCursor
+			}
+			assert.Len(t, seen, 5)
+			assert.Equal(t, 3, pages)
+		}
+
+		_, err := repo.GetTasks(ctx, Domain.TaskFilter{Cursor: "garbage"})
+		assert.ErrorIs(t, err, Domain.ErrInvalidCursor)
+	})
+
+	t.Run("TaskListPagedByStatus", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, status := range []Domain.TaskStatus{Domain.StatusTodo, Domain.StatusDone, Domain.StatusBlocked, Domain.StatusInProgress, Domain.StatusTodo} {
+			_, err := repo.CreateTask(ctx, Domain.Task{Title: "Task", Status: status, UserID: owner})
+			require.NoError(t, err)
+		}
+
+		for _, desc := range []bool{false, true} {
+			filter := Domain.TaskFilter{SortBy: "status", SortDesc: desc, Limit: 2}
+			var statuses []string
+			// Bounded, so that a cursor leading back to the start fails
+			// rather than hangs.
+			for pages := 0; pages < 10; pages++ {
+				page, err := repo.GetTasks(ctx, filter)
+				require.NoError(t, err)
+				for _, task := range page.Tasks {
+					statuses = append(statuses, string(task.Status))
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				filter.Cursor = page.NextCursor
+			}
+			want := []string{"blocked", "done", "in_progress", "todo", "todo"}
+			if desc {
+				want = []string{"todo", "todo", "in_progress", "done", "blocked"}
+			}
+			assert.Equal(t, want, statuses)
+		}
+	})
+}
+
+func testUserRepositoryConformance(t *testing.T, newRepo func(t *testing.T) UserRepository) {
+	ctx := context.Background()
+	t.Run("UserCreate", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateUser(ctx, Domain.User{Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		assert.False(t, created.ID.IsZero())
+		assert.Equal(t, Domain.RoleUser, created.Role)
+		assert.Equal(t, "secret", created.Password, "passwords are stored as given")
+	})
+
+	t.Run("UserLookups", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateUser(ctx, Domain.User{Username: "bob", Password: "secret", Role: Domain.RoleAdmin})
+		require.NoError(t, err)
+		_, err = repo.CreateUser(ctx, Domain.User{Username: "carol", Password: "secret"})
+		require.NoError(t, err)
+
+		byID, err := repo.GetUserByID(ctx, created.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, "bob", byID.Username)
+		assert.Equal(t, Domain.RoleAdmin, byID.Role)
+
+		byName, err := repo.GetUserByUsername(ctx, "bob")
+		require.NoError(t, err)
+		assert.Equal(t, created.ID, byName.ID)
+
+		users, err := repo.GetAllUsers(ctx)
+		require.NoError(t, err)
+		assert.Len(t, users, 2)
+	})
+
+	t.Run("UserUniqueUsername", func(t *testing.T) {
+		repo := newRepo(t)
+		alice, err := repo.CreateUser(ctx, Domain.User{Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		_, err = repo.CreateUser(ctx, Domain.User{Username: "Alice", Password: "secret"})
+		assert.ErrorIs(t, err, Domain.ErrUsernameTaken)
+
+		byName, err := repo.GetUserByUsername(ctx, "ALICE")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, byName.ID)
+
+		bob, err := repo.CreateUser(ctx, Domain.User{Username: "bob", Password: "secret"})
+		require.NoError(t, err)
+		taken := "aLiCe"
+		_, err = repo.UpdateUser(ctx, bob.ID.Hex(), Domain.UserUpdate{Username: &taken})
+		assert.ErrorIs(t, err, Domain.ErrUsernameTaken)
+		// Renaming a user to a different case of their own name is fine.
+		_, err = repo.UpdateUser(ctx, alice.ID.Hex(), Domain.UserUpdate{Username: &taken})
+		assert.NoError(t, err)
+	})
+
+	t.Run("UserUpdateAndDelete", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateUser(ctx, Domain.User{Username: "dave", Password: "secret"})
+		require.NoError(t, err)
+
+		role, disabled := Domain.RoleAdmin, true
+		updated, err := repo.UpdateUser(ctx, created.ID.Hex(), Domain.UserUpdate{Role: &role, Disabled: &disabled})
+		require.NoError(t, err)
+		assert.Equal(t, "dave", updated.Username)
+		assert.Equal(t, Domain.RoleAdmin, updated.Role)
+		assert.True(t, updated.Disabled)
+
+		require.NoError(t, repo.UpdatePassword(ctx, created.ID.Hex(), "changed hash"))
+		fetched, err := repo.GetUserByID(ctx, created.ID.Hex())
+		require.NoError(t, err)
+		assert.True(t, fetched.Disabled)
+		assert.Equal(t, "changed hash", fetched.Password)
+
+		require.NoError(t, repo.DeleteUser(ctx, created.ID.Hex()))
+		_, err = repo.GetUserByID(ctx, created.ID.Hex())
+		assert.ErrorIs(t, err, Domain.ErrUserNotFound)
+		assert.ErrorIs(t, repo.DeleteUser(ctx, created.ID.Hex()), Domain.ErrUserNotFound)
+		_, err = repo.UpdateUser(ctx, created.ID.Hex(), Domain.UserUpdate{Role: &role})
+		assert.ErrorIs(t, err, Domain.ErrUserNotFound)
+		assert.ErrorIs(t, repo.UpdatePassword(ctx, created.ID.Hex(), "x"), Domain.ErrUserNotFound)
+		assert.ErrorIs(t, repo.UpdateMFA(ctx, created.ID.Hex(), Domain.MFA{}), Domain.ErrUserNotFound)
+	})
+
+	t.Run("UserMFA", func(t *testing.T) {
+		repo := newRepo(t)
+		created, err := repo.CreateUser(ctx, Domain.User{Username: "erin", Password: "secret"})
+		require.NoError(t, err)
+		assert.False(t, created.MFA.Enabled)
+
+		mfa := Domain.MFA{Enabled: true, TOTPSecret: "SECRET", LastStep: 42, RecoveryCodes: []string{"a", "b"}}
+		require.NoError(t, repo.UpdateMFA(ctx, created.ID.Hex(), mfa))
+		mfa.RecoveryCodes[0] = "changed after the call"
+		fetched, err := repo.GetUserByID(ctx, created.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, Domain.MFA{Enabled: true, TOTPSecret: "SECRET", LastStep: 42, RecoveryCodes: []string{"a", "b"}}, fetched.MFA)
+
+		require.NoError(t, repo.UpdateMFA(ctx, created.ID.Hex(), Domain.MFA{}))
+		fetched, err = repo.GetUserByID(ctx, created.ID.Hex())
+		require.NoError(t, err)
+		assert.Empty(t, fetched.MFA.RecoveryCodes)
+		assert.False(t, fetched.MFA.Enabled)
+	})
+
+	t.Run("UsersByTeam", func(t *testing.T) {
+		repo := newRepo(t)
+		alice, err := repo.CreateUser(ctx, Domain.User{Username: "alice", Password: "secret"})
+		require.NoError(t, err)
+		_, err = repo.CreateUser(ctx, Domain.User{Username: "bob", Password: "secret"})
+		require.NoError(t, err)
+
+		team := "ops"
+		updated, err := repo.UpdateUser(ctx, alice.ID.Hex(), Domain.UserUpdate{Team: &team})
+		require.NoError(t, err)
+		assert.Equal(t, "ops", updated.Team)
+
+		members, err := repo.GetUsersByTeam(ctx, "ops")
+		require.NoError(t, err)
+		require.Len(t, members, 1)
+		assert.Equal(t, alice.ID, members[0].ID)
+
+		members, err = repo.GetUsersByTeam(ctx, "dev")
+		require.NoError(t, err)
+		assert.Empty(t, members)
+	})
+
+	t.Run("UserNotFound", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.GetUserByID(ctx, primitive.NewObjectID().Hex())
+		assert.ErrorIs(t, err, Domain.ErrUserNotFound)
+		_, err = repo.GetUserByID(ctx, "not-an-id")
+		assert.ErrorIs(t, err, Domain.ErrInvalidID)
+		_, err = repo.GetUserByUsername(ctx, "nobody")
+		assert.ErrorIs(t, err, Domain.ErrUserNotFound)
+	})
+}
+
+func testRoleRepositoryConformance(t *testing.T, newRepo func(t *testing.T) RoleRepository) {
+	ctx := context.Background()
+	t.Run("RoleDefaults", func(t *testing.T) {
+		repo := newRepo(t)
+		roles, err := repo.ListRoles(ctx)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, Domain.DefaultRoles(), roles)
+	})
+
+	t.Run("RoleSaveAndDelete", func(t *testing.T) {
+		repo := newRepo(t)
+		auditor := Domain.Role{Name: "auditor", Permissions: []Domain.Permission{Domain.PermTasksReadAny}}
+		require.NoError(t, repo.SaveRole(ctx, auditor))
+		fetched, err := repo.GetRole(ctx, "auditor")
+		require.NoError(t, err)
+		assert.Equal(t, auditor, *fetched)
+
+		auditor.Permissions = append(auditor.Permissions, Domain.PermUsersRead)
+		require.NoError(t, repo.SaveRole(ctx, auditor))
+		fetched, err = repo.GetRole(ctx, "auditor")
+		require.NoError(t, err)
+		assert.Equal(t, auditor.Permissions, fetched.Permissions)
+
+		roles, err := repo.ListRoles(ctx)
+		require.NoError(t, err)
+		names := make([]string, len(roles))
+		for i, role := range roles {
+			names[i] = role.Name
+		}
+		assert.Equal(t, []string{"admin", "auditor", "manager", "user"}, names)
+
+		require.NoError(t, repo.DeleteRole(ctx, "auditor"))
+		_, err = repo.GetRole(ctx, "auditor")
+		assert.ErrorIs(t, err, Domain.ErrRoleNotFound)
+		assert.ErrorIs(t, repo.DeleteRole(ctx, "auditor"), Domain.ErrRoleNotFound)
+	})
+}
+
+func testAPIKeyRepositoryConformance(t *testing.T, newRepo func(t *testing.T) APIKeyRepository) {
+	ctx := context.Background()
+	owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+	created := time.Now().UTC().Truncate(time.Millisecond)
+
+	t.Run("APIKeyCreateAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		older := Domain.APIKey{ID: "older", UserID: owner, Name: "ci", SecretHash: "hash", Scopes: []Domain.Permission{Domain.PermTasksReadOwn},
+			CreatedAt: created, ExpiresAt: created.Add(time.Hour)}
+		newer := older
+		newer.ID, newer.CreatedAt = "newer", created.Add(time.Minute)
+		foreign := older
+		foreign.ID, foreign.UserID = "foreign", other
+		for _, key := range []Domain.APIKey{older, newer, foreign} {
+			require.NoError(t, repo.CreateAPIKey(ctx, key))
+		}
+
+		fetched, err := repo.GetAPIKey(ctx, "older")
+		require.NoError(t, err)
+		assert.Equal(t, "hash", fetched.SecretHash)
+		assert.Equal(t, older.Scopes, fetched.Scopes)
+		assert.True(t, older.ExpiresAt.Equal(fetched.ExpiresAt))
+
+		keys, err := repo.ListAPIKeys(ctx, owner)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.Equal(t, "newer", keys[0].ID)
+
+		_, err = repo.GetAPIKey(ctx, "missing")
+		assert.ErrorIs(t, err, Domain.ErrAPIKeyNotFound)
+	})
+
+	t.Run("APIKeyRevokeAndTouch", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.CreateAPIKey(ctx, Domain.APIKey{ID: "key", UserID: owner, CreatedAt: created, ExpiresAt: created.Add(time.Hour)}))
+
+		used := created.Add(time.Minute)
+		require.NoError(t, repo.TouchAPIKey(ctx, "key", used))
+		assert.ErrorIs(t, repo.TouchAPIKey(ctx, "missing", used), Domain.ErrAPIKeyNotFound)
+
+		assert.ErrorIs(t, repo.RevokeAPIKey(ctx, "key", other, used), Domain.ErrAPIKeyNotFound)
+		require.NoError(t, repo.RevokeAPIKey(ctx, "key", owner, used))
+		require.NoError(t, repo.RevokeAPIKey(ctx, "key", owner, used.Add(time.Hour)))
+
+		key, err := repo.GetAPIKey(ctx, "key")
+		require.NoError(t, err)
+		require.NotNil(t, key.LastUsedAt)
+		assert.True(t, used.Equal(*key.LastUsedAt))
+		require.NotNil(t, key.RevokedAt)
+		assert.True(t, used.Equal(*key.RevokedAt), "revoking again keeps the first time")
+	})
+}
+
+func testTokenRepositoryConformance(t *testing.T, newRepo func(t *testing.T) TokenRepository) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	newToken := func(id, sessionID string) Domain.RefreshToken {
+		return Domain.RefreshToken{
+			ID:        id,
+			SessionID: sessionID,
+			UserID:    userID,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(time.Hour),
+		}
+	}
+
+	t.Run("RefreshTokenCreateAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t1", "s1")))
+
+		token, err := repo.GetRefreshToken(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "s1", token.SessionID)
+		assert.Equal(t, userID, token.UserID)
+		assert.Empty(t, token.ReplacedBy)
+		assert.Nil(t, token.RevokedAt)
+
+		_, err = repo.GetRefreshToken(ctx, "missing")
+		assert.ErrorIs(t, err, Domain.ErrTokenNotFound)
+	})
+
+	t.Run("RefreshTokenRotatesOnce", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t1", "s1")))
+
+		require.NoError(t, repo.RotateRefreshToken(ctx, "t1", "t2"))
+		assert.ErrorIs(t, repo.RotateRefreshToken(ctx, "t1", "t3"), Domain.ErrTokenReused)
+
+		token, err := repo.GetRefreshToken(ctx, "t1")
+		require.NoError(t, err)
+		assert.Equal(t, "t2", token.ReplacedBy)
+	})
+
+	t.Run("RevokeSession", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t1", "s1")))
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t2", "s1")))
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t3", "s2")))
+
+		require.NoError(t, repo.RevokeSession(ctx, "s1", time.Now().Add(time.Hour)))
+
+		for id, revoked := range map[string]bool{"t1": true, "t2": true, "t3": false} {
+			token, err := repo.GetRefreshToken(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, revoked, token.RevokedAt != nil, id)
+		}
+		assert.ErrorIs(t, repo.RotateRefreshToken(ctx, "t2", "t4"), Domain.ErrTokenReused)
+
+		revoked, err := repo.IsRevoked(ctx, "any-access-token", "s1")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		revoked, err = repo.IsRevoked(ctx, "any-access-token", "s2")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+
+	t.Run("RevokeUserSessions", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t1", "s1")))
+		require.NoError(t, repo.CreateRefreshToken(ctx, newToken("t2", "s2")))
+		someoneElse := newToken("t3", "s3")
+		someoneElse.UserID = primitive.NewObjectID()
+		require.NoError(t, repo.CreateRefreshToken(ctx, someoneElse))
+
+		require.NoError(t, repo.RevokeUserSessions(ctx, userID, time.Now().Add(time.Hour)))
+
+		for session, want := range map[string]bool{"s1": true, "s2": true, "s3": false} {
+			revoked, err := repo.IsRevoked(ctx, "any-access-token", session)
+			require.NoError(t, err)
+			assert.Equal(t, want, revoked, session)
+		}
+		assert.ErrorIs(t, repo.RotateRefreshToken(ctx, "t1", "t4"), Domain.ErrTokenReused)
+	})
+
+	t.Run("RevokeAccessToken", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.RevokeAccessToken(ctx, "a1", time.Now().Add(time.Hour)))
+
+		revoked, err := repo.IsRevoked(ctx, "a1", "")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+		revoked, err = repo.IsRevoked(ctx, "a2", "s1")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+}
+
+func testLoginAttemptRepositoryConformance(t *testing.T, newRepo func(t *testing.T) LoginAttemptRepository) {
+	ctx := context.Background()
+	policy := Domain.LockoutPolicy{FreeFailures: 1, BaseDelay: time.Minute, MaxDelay: time.Minute, ResetAfter: time.Hour}
+
+	t.Run("LoginAttemptsRecordAndClear", func(t *testing.T) {
+		repo := newRepo(t)
+		empty, err := repo.GetLoginAttempts(ctx, "user:alice")
+		require.NoError(t, err)
+		assert.Equal(t, Domain.LoginAttempts{Key: "user:alice"}, *empty)
+
+		now := time.Now()
+		_, err = repo.RecordLoginFailure(ctx, "user:alice", now, policy)
+		require.NoError(t, err)
+		recorded, err := repo.RecordLoginFailure(ctx, "user:alice", now, policy)
+		require.NoError(t, err)
+		assert.Equal(t, 2, recorded.Failures)
+		assert.True(t, recorded.LockedUntil.After(now))
+		_, err = repo.RecordLoginFailure(ctx, "ip:192.0.2.1", now, policy)
+		require.NoError(t, err)
+
+		fetched, err := repo.GetLoginAttempts(ctx, "user:alice")
+		require.NoError(t, err)
+		assert.Equal(t, 2, fetched.Failures)
+		list, err := repo.ListLoginAttempts(ctx)
+		require.NoError(t, err)
+		assert.Len(t, list, 2)
+
+		require.NoError(t, repo.ClearLoginAttempts(ctx, "user:alice"))
+		require.NoError(t, repo.ClearLoginAttempts(ctx, "user:nobody"))
+		fetched, err = repo.GetLoginAttempts(ctx, "user:alice")
+		require.NoError(t, err)
+		assert.Zero(t, fetched.Failures)
+	})
+
+	t.Run("LoginAttemptsExpire", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.RecordLoginFailure(ctx, "user:alice", time.Now().Add(-2*time.Hour), policy)
+		require.NoError(t, err)
+
+		fetched, err := repo.GetLoginAttempts(ctx, "user:alice")
+		require.NoError(t, err)
+		assert.Zero(t, fetched.Failures)
+		list, err := repo.ListLoginAttempts(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, list)
+	})
+}
@@ -0,0 +1,62 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fileAPIKeyRepository is the API key counterpart of fileTaskRepository.
+type fileAPIKeyRepository struct {
+	*inMemoryAPIKeyRepository
+	mu   sync.Mutex
+	file snapshotFile
+}
+
+func NewFileAPIKeyRepository(path string) (APIKeyRepository, error) {
+	repo := &fileAPIKeyRepository{
+		inMemoryAPIKeyRepository: newInMemoryAPIKeyRepository(),
+		file:                     snapshotFile{path: path},
+	}
+	var keys []Domain.APIKey
+	if err := repo.file.read(&keys); err != nil {
+		return nil, err
+	}
+	repo.load(keys)
+	return repo, nil
+}
+
+func (r *fileAPIKeyRepository) commit(change func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := r.file.write(r.snapshot()); err != nil {
+		r.load(previous)
+		return err
+	}
+	return nil
+}
+
+func (r *fileAPIKeyRepository) CreateAPIKey(ctx context.Context, key Domain.APIKey) error {
+	return r.commit(func() error {
+		return r.inMemoryAPIKeyRepository.CreateAPIKey(ctx, key)
+	})
+}
+
+func (r *fileAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string, userID primitive.ObjectID, at time.Time) error {
+	return r.commit(func() error {
+		return r.inMemoryAPIKeyRepository.RevokeAPIKey(ctx, id, userID, at)
+	})
+}
+
+func (r *fileAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return r.commit(func() error {
+		return r.inMemoryAPIKeyRepository.TouchAPIKey(ctx, id, at)
+	})
+}
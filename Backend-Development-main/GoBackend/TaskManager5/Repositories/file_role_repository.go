@@ -0,0 +1,55 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+
+	"TaskManager5/Domain"
+)
+
+// fileRoleRepository is the role counterpart of fileTaskRepository. Until
+// the file exists it holds the default roles.
+type fileRoleRepository struct {
+	*inMemoryRoleRepository
+	mu   sync.Mutex
+	file snapshotFile
+}
+
+func NewFileRoleRepository(path string) (RoleRepository, error) {
+	repo := &fileRoleRepository{
+		inMemoryRoleRepository: newInMemoryRoleRepository(),
+		file:                   snapshotFile{path: path},
+	}
+	roles := Domain.DefaultRoles()
+	if err := repo.file.read(&roles); err != nil {
+		return nil, err
+	}
+	repo.load(roles)
+	return repo, nil
+}
+
+func (r *fileRoleRepository) commit(change func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := r.file.write(r.snapshot()); err != nil {
+		r.load(previous)
+		return err
+	}
+	return nil
+}
+
+func (r *fileRoleRepository) SaveRole(ctx context.Context, role Domain.Role) error {
+	return r.commit(func() error {
+		return r.inMemoryRoleRepository.SaveRole(ctx, role)
+	})
+}
+
+func (r *fileRoleRepository) DeleteRole(ctx context.Context, name string) error {
+	return r.commit(func() error {
+		return r.inMemoryRoleRepository.DeleteRole(ctx, name)
+	})
+}
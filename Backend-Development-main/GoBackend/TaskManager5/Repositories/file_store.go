@@ -0,0 +1,64 @@
+package Repositories
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// snapshotFile persists one collection as relaxed extended JSON, using the
+// same bson tags as the Mongo repositories. Writes go to a temporary file
+// that is renamed over the old one so a crash never leaves a torn file.
+type snapshotFile struct {
+	path string
+}
+
+// read decodes the stored documents into out, a pointer to a slice. A
+// missing file leaves out untouched.
+func (f snapshotFile) read(out interface{}) error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc bson.Raw
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return err
+	}
+	documents, err := doc.LookupErr("documents")
+	if err != nil {
+		return err
+	}
+	return documents.Unmarshal(out)
+}
+
+func (f snapshotFile) write(documents interface{}) error {
+	data, err := bson.MarshalExtJSON(bson.M{"documents": documents}, false, false)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,75 @@
+package Repositories
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"TaskManager5/Domain"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFileRepositoriesPersistAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	tasks, err := NewFileTaskRepository(dir + "/tasks.json")
+	require.NoError(t, err)
+	users, err := NewFileUserRepository(dir + "/users.json")
+	require.NoError(t, err)
+	roles, err := NewFileRoleRepository(dir + "/roles.json")
+	require.NoError(t, err)
+
+	require.NoError(t, roles.SaveRole(ctx, Domain.Role{Name: "auditor", Permissions: []Domain.Permission{Domain.PermTasksReadAny}}))
+	user, err := users.CreateUser(ctx, Domain.User{Username: "alice", Password: "secret"})
+	require.NoError(t, err)
+	kept, err := tasks.CreateTask(ctx, Domain.Task{Title: "Kept", UserID: user.ID})
+	require.NoError(t, err)
+	removed, err := tasks.CreateTask(ctx, Domain.Task{Title: "Removed", UserID: user.ID})
+	require.NoError(t, err)
+	require.NoError(t, tasks.DeleteTask(ctx, removed.ID.Hex(), "", 0))
+
+	tasks, err = NewFileTaskRepository(dir + "/tasks.json")
+	require.NoError(t, err)
+	users, err = NewFileUserRepository(dir + "/users.json")
+	require.NoError(t, err)
+	roles, err = NewFileRoleRepository(dir + "/roles.json")
+	require.NoError(t, err)
+
+	reloaded, err := tasks.GetTask(ctx, kept.ID.Hex(), user.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, "Kept", reloaded.Title)
+	_, err = tasks.GetTask(ctx, removed.ID.Hex(), "")
+	assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+
+	reloadedUser, err := users.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, reloadedUser.ID)
+	assert.Equal(t, user.Password, reloadedUser.Password)
+
+	auditor, err := roles.GetRole(ctx, "auditor")
+	require.NoError(t, err)
+	assert.Equal(t, []Domain.Permission{Domain.PermTasksReadAny}, auditor.Permissions)
+	_, err = roles.GetRole(ctx, Domain.RoleManager)
+	assert.NoError(t, err, "default roles are saved along with the new one")
+}
+
+func TestFileTaskRepositoryRollsBackFailedWrites(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/tasks.json"
+	repo, err := NewFileTaskRepository(path)
+	require.NoError(t, err)
+
+	// A directory where the snapshot file should be makes every write fail.
+	require.NoError(t, os.MkdirAll(path+"/blocker", 0o755))
+
+	_, err = repo.CreateTask(ctx, Domain.Task{Title: "Lost", UserID: primitive.NewObjectID()})
+	assert.Error(t, err)
+
+	page, err := repo.GetTasks(ctx, Domain.TaskFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Tasks)
+}
@@ -0,0 +1,105 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+
+	"TaskManager5/Domain"
+)
+
+// fileTaskRepository serves reads from an in-memory copy and rewrites the
+// snapshot file after every change. A failed write rolls the change back.
+type fileTaskRepository struct {
+	*inMemoryTaskRepository
+	mu   sync.Mutex
+	file snapshotFile
+}
+
+func NewFileTaskRepository(path string) (TaskRepository, error) {
+	repo := &fileTaskRepository{
+		inMemoryTaskRepository: newInMemoryTaskRepository(),
+		file:                   snapshotFile{path: path},
+	}
+	var tasks []Domain.Task
+	if err := repo.file.read(&tasks); err != nil {
+		return nil, err
+	}
+	repo.load(tasks)
+	return repo, nil
+}
+
+// commit runs change and persists the result, restoring the previous state
+// if either step fails.
+func (r *fileTaskRepository) commit(change func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := r.file.write(r.snapshot()); err != nil {
+		r.load(previous)
+		return err
+	}
+	return nil
+}
+
+func (r *fileTaskRepository) CreateTask(ctx context.Context, task Domain.Task) (*Domain.Task, error) {
+	var created *Domain.Task
+	err := r.commit(func() (err error) {
+		created, err = r.inMemoryTaskRepository.CreateTask(ctx, task)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *fileTaskRepository) UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	var updated *Domain.Task
+	err := r.commit(func() (err error) {
+		updated, err = r.inMemoryTaskRepository.UpdateTask(ctx, id, ownerID, version, updatedTask)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *fileTaskRepository) PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (*Domain.Task, error) {
+	var patched *Domain.Task
+	err := r.commit(func() (err error) {
+		patched, err = r.inMemoryTaskRepository.PatchTask(ctx, id, ownerID, version, patch)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+func (r *fileTaskRepository) DeleteTask(ctx context.Context, id, ownerID string, version int64) error {
+	return r.commit(func() error {
+		return r.inMemoryTaskRepository.DeleteTask(ctx, id, ownerID, version)
+	})
+}
+
+func (r *fileTaskRepository) DeleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	var deleted int64
+	err := r.commit(func() (err error) {
+		deleted, err = r.inMemoryTaskRepository.DeleteTasksByUserID(ctx, userID)
+		return err
+	})
+	return deleted, err
+}
+
+func (r *fileTaskRepository) ReassignTasks(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	var reassigned int64
+	err := r.commit(func() (err error) {
+		reassigned, err = r.inMemoryTaskRepository.ReassignTasks(ctx, fromUserID, toUserID)
+		return err
+	})
+	return reassigned, err
+}
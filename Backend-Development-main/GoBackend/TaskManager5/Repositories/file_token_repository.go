@@ -0,0 +1,74 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fileTokenRepository is the token counterpart of fileTaskRepository.
+type fileTokenRepository struct {
+	*inMemoryTokenRepository
+	mu   sync.Mutex
+	file snapshotFile
+}
+
+func NewFileTokenRepository(path string) (TokenRepository, error) {
+	repo := &fileTokenRepository{
+		inMemoryTokenRepository: newInMemoryTokenRepository(),
+		file:                    snapshotFile{path: path},
+	}
+	var snapshot tokenSnapshot
+	if err := repo.file.read(&snapshot); err != nil {
+		return nil, err
+	}
+	repo.load(snapshot)
+	return repo, nil
+}
+
+func (r *fileTokenRepository) commit(change func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := r.file.write(r.snapshot()); err != nil {
+		r.load(previous)
+		return err
+	}
+	return nil
+}
+
+func (r *fileTokenRepository) CreateRefreshToken(ctx context.Context, token Domain.RefreshToken) error {
+	return r.commit(func() error {
+		return r.inMemoryTokenRepository.CreateRefreshToken(ctx, token)
+	})
+}
+
+func (r *fileTokenRepository) RotateRefreshToken(ctx context.Context, id, replacedBy string) error {
+	return r.commit(func() error {
+		return r.inMemoryTokenRepository.RotateRefreshToken(ctx, id, replacedBy)
+	})
+}
+
+func (r *fileTokenRepository) RevokeSession(ctx context.Context, sessionID string, until time.Time) error {
+	return r.commit(func() error {
+		return r.inMemoryTokenRepository.RevokeSession(ctx, sessionID, until)
+	})
+}
+
+func (r *fileTokenRepository) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, until time.Time) error {
+	return r.commit(func() error {
+		return r.inMemoryTokenRepository.RevokeUserSessions(ctx, userID, until)
+	})
+}
+
+func (r *fileTokenRepository) RevokeAccessToken(ctx context.Context, tokenID string, until time.Time) error {
+	return r.commit(func() error {
+		return r.inMemoryTokenRepository.RevokeAccessToken(ctx, tokenID, until)
+	})
+}
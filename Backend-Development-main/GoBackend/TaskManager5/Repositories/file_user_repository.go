@@ -0,0 +1,84 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+
+	"TaskManager5/Domain"
+)
+
+// fileUserRepository is the user counterpart of fileTaskRepository.
+type fileUserRepository struct {
+	*inMemoryUserRepository
+	mu   sync.Mutex
+	file snapshotFile
+}
+
+func NewFileUserRepository(path string) (UserRepository, error) {
+	repo := &fileUserRepository{
+		inMemoryUserRepository: newInMemoryUserRepository(),
+		file:                   snapshotFile{path: path},
+	}
+	var users []Domain.User
+	if err := repo.file.read(&users); err != nil {
+		return nil, err
+	}
+	repo.load(users)
+	return repo, nil
+}
+
+func (r *fileUserRepository) commit(change func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.snapshot()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := r.file.write(r.snapshot()); err != nil {
+		r.load(previous)
+		return err
+	}
+	return nil
+}
+
+func (r *fileUserRepository) CreateUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
+	var created *Domain.User
+	err := r.commit(func() (err error) {
+		created, err = r.inMemoryUserRepository.CreateUser(ctx, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *fileUserRepository) UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (*Domain.User, error) {
+	var updated *Domain.User
+	err := r.commit(func() (err error) {
+		updated, err = r.inMemoryUserRepository.UpdateUser(ctx, userID, update)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *fileUserRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	return r.commit(func() error {
+		return r.inMemoryUserRepository.UpdatePassword(ctx, userID, passwordHash)
+	})
+}
+
+func (r *fileUserRepository) UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) error {
+	return r.commit(func() error {
+		return r.inMemoryUserRepository.UpdateMFA(ctx, userID, mfa)
+	})
+}
+
+func (r *fileUserRepository) DeleteUser(ctx context.Context, userID string) error {
+	return r.commit(func() error {
+		return r.inMemoryUserRepository.DeleteUser(ctx, userID)
+	})
+}
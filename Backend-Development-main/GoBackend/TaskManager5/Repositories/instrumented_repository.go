@@ -0,0 +1,135 @@
+package Repositories
+
+import (
+	"context"
+	"time"
+
+	"TaskManager5/Domain"
+)
+
+// OperationObserver is told how long each repository call took and the
+// error it ended with, if any. Infrastructure.Metrics is one.
+type OperationObserver interface {
+	ObserveOperation(repository, method string, duration time.Duration, err error)
+}
+
+// instrumentation reports the calls of one repository to an observer.
+type instrumentation struct {
+	repository string
+	observer   OperationObserver
+}
+
+func (i instrumentation) observe(method string, start time.Time, err *error) {
+	i.observer.ObserveOperation(i.repository, method, time.Since(start), *err)
+}
+
+// instrumentedTaskRepository decorates a TaskRepository, reporting every
+// call to an OperationObserver.
+type instrumentedTaskRepository struct {
+	next TaskRepository
+	instrumentation
+}
+
+// NewInstrumentedTaskRepository reports every call to next to observer as
+// an operation of the "tasks" repository.
+func NewInstrumentedTaskRepository(next TaskRepository, observer OperationObserver) TaskRepository {
+	return &instrumentedTaskRepository{next: next, instrumentation: instrumentation{"tasks", observer}}
+}
+
+func (r *instrumentedTaskRepository) GetTasks(ctx context.Context, filter Domain.TaskFilter) (page *Domain.TaskPage, err error) {
+	defer r.observe("GetTasks", time.Now(), &err)
+	return r.next.GetTasks(ctx, filter)
+}
+
+func (r *instrumentedTaskRepository) GetTask(ctx context.Context, id, ownerID string) (task *Domain.Task, err error) {
+	defer r.observe("GetTask", time.Now(), &err)
+	return r.next.GetTask(ctx, id, ownerID)
+}
+
+func (r *instrumentedTaskRepository) CreateTask(ctx context.Context, task Domain.Task) (created *Domain.Task, err error) {
+	defer r.observe("CreateTask", time.Now(), &err)
+	return r.next.CreateTask(ctx, task)
+}
+
+func (r *instrumentedTaskRepository) UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (task *Domain.Task, err error) {
+	defer r.observe("UpdateTask", time.Now(), &err)
+	return r.next.UpdateTask(ctx, id, ownerID, version, updatedTask)
+}
+
+func (r *instrumentedTaskRepository) PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (task *Domain.Task, err error) {
+	defer r.observe("PatchTask", time.Now(), &err)
+	return r.next.PatchTask(ctx, id, ownerID, version, patch)
+}
+
+func (r *instrumentedTaskRepository) DeleteTask(ctx context.Context, id, ownerID string, version int64) (err error) {
+	defer r.observe("DeleteTask", time.Now(), &err)
+	return r.next.DeleteTask(ctx, id, ownerID, version)
+}
+
+func (r *instrumentedTaskRepository) DeleteTasksByUserID(ctx context.Context, userID string) (deleted int64, err error) {
+	defer r.observe("DeleteTasksByUserID", time.Now(), &err)
+	return r.next.DeleteTasksByUserID(ctx, userID)
+}
+
+func (r *instrumentedTaskRepository) ReassignTasks(ctx context.Context, fromUserID, toUserID string) (reassigned int64, err error) {
+	defer r.observe("ReassignTasks", time.Now(), &err)
+	return r.next.ReassignTasks(ctx, fromUserID, toUserID)
+}
+
+// instrumentedUserRepository decorates a UserRepository, reporting every
+// call to an OperationObserver.
+type instrumentedUserRepository struct {
+	next UserRepository
+	instrumentation
+}
+
+// NewInstrumentedUserRepository reports every call to next to observer as
+// an operation of the "users" repository.
+func NewInstrumentedUserRepository(next UserRepository, observer OperationObserver) UserRepository {
+	return &instrumentedUserRepository{next: next, instrumentation: instrumentation{"users", observer}}
+}
+
+func (r *instrumentedUserRepository) CreateUser(ctx context.Context, user Domain.User) (created *Domain.User, err error) {
+	defer r.observe("CreateUser", time.Now(), &err)
+	return r.next.CreateUser(ctx, user)
+}
+
+func (r *instrumentedUserRepository) GetUserByUsername(ctx context.Context, username string) (user *Domain.User, err error) {
+	defer r.observe("GetUserByUsername", time.Now(), &err)
+	return r.next.GetUserByUsername(ctx, username)
+}
+
+func (r *instrumentedUserRepository) GetUserByID(ctx context.Context, userID string) (user *Domain.User, err error) {
+	defer r.observe("GetUserByID", time.Now(), &err)
+	return r.next.GetUserByID(ctx, userID)
+}
+
+func (r *instrumentedUserRepository) GetAllUsers(ctx context.Context) (users []Domain.User, err error) {
+	defer r.observe("GetAllUsers", time.Now(), &err)
+	return r.next.GetAllUsers(ctx)
+}
+
+func (r *instrumentedUserRepository) GetUsersByTeam(ctx context.Context, team string) (users []Domain.User, err error) {
+	defer r.observe("GetUsersByTeam", time.Now(), &err)
+	return r.next.GetUsersByTeam(ctx, team)
+}
+
+func (r *instrumentedUserRepository) UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (user *Domain.User, err error) {
+	defer r.observe("UpdateUser", time.Now(), &err)
+	return r.next.UpdateUser(ctx, userID, update)
+}
+
+func (r *instrumentedUserRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) (err error) {
+	defer r.observe("UpdatePassword", time.Now(), &err)
+	return r.next.UpdatePassword(ctx, userID, passwordHash)
+}
+
+func (r *instrumentedUserRepository) UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) (err error) {
+	defer r.observe("UpdateMFA", time.Now(), &err)
+	return r.next.UpdateMFA(ctx, userID, mfa)
+}
+
+func (r *instrumentedUserRepository) DeleteUser(ctx context.Context, userID string) (err error) {
+	defer r.observe("DeleteUser", time.Now(), &err)
+	return r.next.DeleteUser(ctx, userID)
+}
@@ -0,0 +1,22 @@
+package Repositories
+
+import (
+	"context"
+	"time"
+
+	"TaskManager5/Domain"
+)
+
+// LoginAttemptRepository counts failed logins per key. Records whose
+// ExpiresAt has passed are treated as absent.
+type LoginAttemptRepository interface {
+	// GetLoginAttempts returns the record for key, or an empty one.
+	GetLoginAttempts(ctx context.Context, key string) (*Domain.LoginAttempts, error)
+	// RecordLoginFailure applies policy to the record for key and returns
+	// the updated record. It must be atomic per key.
+	RecordLoginFailure(ctx context.Context, key string, at time.Time, policy Domain.LockoutPolicy) (*Domain.LoginAttempts, error)
+	// ClearLoginAttempts forgets the key; clearing an unknown key is not an
+	// error.
+	ClearLoginAttempts(ctx context.Context, key string) error
+	ListLoginAttempts(ctx context.Context) ([]Domain.LoginAttempts, error)
+}
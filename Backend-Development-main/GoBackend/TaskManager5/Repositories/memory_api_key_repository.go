@@ -0,0 +1,106 @@
+package Repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// inMemoryAPIKeyRepository is the map+mutex counterpart of apiKeyRepository.
+type inMemoryAPIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]Domain.APIKey
+}
+
+func NewInMemoryAPIKeyRepository() APIKeyRepository {
+	return newInMemoryAPIKeyRepository()
+}
+
+func newInMemoryAPIKeyRepository() *inMemoryAPIKeyRepository {
+	return &inMemoryAPIKeyRepository{keys: make(map[string]Domain.APIKey)}
+}
+
+func (r *inMemoryAPIKeyRepository) CreateAPIKey(ctx context.Context, key Domain.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[key.ID] = *copyAPIKey(key)
+	return nil
+}
+
+func (r *inMemoryAPIKeyRepository) GetAPIKey(ctx context.Context, id string) (*Domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, exists := r.keys[id]
+	if !exists {
+		return nil, Domain.ErrAPIKeyNotFound
+	}
+	return copyAPIKey(key), nil
+}
+
+func (r *inMemoryAPIKeyRepository) ListAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]Domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := []Domain.APIKey{}
+	for _, key := range r.keys {
+		if key.UserID == userID {
+			keys = append(keys, *copyAPIKey(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (r *inMemoryAPIKeyRepository) RevokeAPIKey(ctx context.Context, id string, userID primitive.ObjectID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, exists := r.keys[id]
+	if !exists || key.UserID != userID {
+		return Domain.ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		r.keys[id] = key
+	}
+	return nil
+}
+
+func (r *inMemoryAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, exists := r.keys[id]
+	if !exists {
+		return Domain.ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = &at
+	r.keys[id] = key
+	return nil
+}
+
+// copyAPIKey keeps callers from changing stored scopes through the slice.
+func copyAPIKey(key Domain.APIKey) *Domain.APIKey {
+	key.Scopes = append([]Domain.Permission(nil), key.Scopes...)
+	return &key
+}
+
+func (r *inMemoryAPIKeyRepository) snapshot() []Domain.APIKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]Domain.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, *copyAPIKey(key))
+	}
+	return keys
+}
+
+func (r *inMemoryAPIKeyRepository) load(keys []Domain.APIKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = make(map[string]Domain.APIKey, len(keys))
+	for _, key := range keys {
+		r.keys[key.ID] = key
+	}
+}
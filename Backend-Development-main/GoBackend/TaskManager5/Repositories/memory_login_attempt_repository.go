@@ -0,0 +1,79 @@
+package Repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+)
+
+// inMemoryLoginAttemptRepository is the only implementation so far and is
+// used with every storage driver, so lockouts reset when the server
+// restarts.
+type inMemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]Domain.LoginAttempts
+}
+
+func NewInMemoryLoginAttemptRepository() LoginAttemptRepository {
+	return &inMemoryLoginAttemptRepository{
+		attempts: make(map[string]Domain.LoginAttempts),
+	}
+}
+
+func (r *inMemoryLoginAttemptRepository) GetLoginAttempts(ctx context.Context, key string) (*Domain.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempts := r.current(key, time.Now())
+	return &attempts, nil
+}
+
+func (r *inMemoryLoginAttemptRepository) RecordLoginFailure(ctx context.Context, key string, at time.Time, policy Domain.LockoutPolicy) (*Domain.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.purgeExpired(at)
+	attempts := r.current(key, at)
+	policy.RecordFailure(&attempts, at)
+	r.attempts[key] = attempts
+	return &attempts, nil
+}
+
+func (r *inMemoryLoginAttemptRepository) ClearLoginAttempts(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) ListLoginAttempts(ctx context.Context) ([]Domain.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.purgeExpired(time.Now())
+	list := make([]Domain.LoginAttempts, 0, len(r.attempts))
+	for _, attempts := range r.attempts {
+		list = append(list, attempts)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list, nil
+}
+
+// current returns the live record for key. It must be called with r.mu
+// held.
+func (r *inMemoryLoginAttemptRepository) current(key string, now time.Time) Domain.LoginAttempts {
+	attempts, exists := r.attempts[key]
+	if !exists || !now.Before(attempts.ExpiresAt) {
+		return Domain.LoginAttempts{Key: key}
+	}
+	return attempts
+}
+
+// purgeExpired must be called with r.mu held.
+func (r *inMemoryLoginAttemptRepository) purgeExpired(now time.Time) {
+	for key, attempts := range r.attempts {
+		if !now.Before(attempts.ExpiresAt) {
+			delete(r.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package Repositories
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"TaskManager5/Domain"
+)
+
+// inMemoryRoleRepository is the map+mutex counterpart of roleRepository.
+type inMemoryRoleRepository struct {
+	mu    sync.RWMutex
+	roles map[string]Domain.Role
+}
+
+func NewInMemoryRoleRepository() RoleRepository {
+	return newInMemoryRoleRepository()
+}
+
+func newInMemoryRoleRepository() *inMemoryRoleRepository {
+	r := &inMemoryRoleRepository{}
+	r.load(Domain.DefaultRoles())
+	return r
+}
+
+func (r *inMemoryRoleRepository) GetRole(ctx context.Context, name string) (*Domain.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	role, exists := r.roles[name]
+	if !exists {
+		return nil, Domain.ErrRoleNotFound
+	}
+	return copyRole(role), nil
+}
+
+func (r *inMemoryRoleRepository) ListRoles(ctx context.Context) ([]Domain.Role, error) {
+	roles := r.snapshot()
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (r *inMemoryRoleRepository) SaveRole(ctx context.Context, role Domain.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roles[role.Name] = *copyRole(role)
+	return nil
+}
+
+func (r *inMemoryRoleRepository) DeleteRole(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.roles[name]; !exists {
+		return Domain.ErrRoleNotFound
+	}
+	delete(r.roles, name)
+	return nil
+}
+
+// copyRole keeps callers from changing stored permissions through the slice.
+func copyRole(role Domain.Role) *Domain.Role {
+	role.Permissions = append([]Domain.Permission(nil), role.Permissions...)
+	return &role
+}
+
+func (r *inMemoryRoleRepository) snapshot() []Domain.Role {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	roles := make([]Domain.Role, 0, len(r.roles))
+	for _, role := range r.roles {
+		roles = append(roles, *copyRole(role))
+	}
+	return roles
+}
+
+func (r *inMemoryRoleRepository) load(roles []Domain.Role) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roles = make(map[string]Domain.Role, len(roles))
+	for _, role := range roles {
+		r.roles[role.Name] = role
+	}
+}
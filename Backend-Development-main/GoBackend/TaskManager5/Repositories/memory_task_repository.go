@@ -0,0 +1,330 @@
+package Repositories
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// inMemoryTaskRepository keeps tasks in a map guarded by a mutex. It is
+// meant for local development and tests; nothing survives a restart.
+type inMemoryTaskRepository struct {
+	mu    sync.RWMutex
+	tasks map[primitive.ObjectID]Domain.Task
+}
+
+func NewInMemoryTaskRepository() TaskRepository {
+	return newInMemoryTaskRepository()
+}
+
+func newInMemoryTaskRepository() *inMemoryTaskRepository {
+	return &inMemoryTaskRepository{
+		tasks: make(map[primitive.ObjectID]Domain.Task),
+	}
+}
+
+func (r *inMemoryTaskRepository) GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	filter = filter.WithDefaults()
+	after, err := decodeTaskCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+	var ownerID primitive.ObjectID
+	if filter.UserID != "" {
+		if ownerID, err = primitive.ObjectIDFromHex(filter.UserID); err != nil {
+			return nil, Domain.ErrInvalidID
+		}
+	}
+	owners, err := ownerSet(filter.Owners)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	matched := make([]Domain.Task, 0)
+	for _, task := range r.tasks {
+		if filter.UserID != "" && task.UserID != ownerID {
+			continue
+		}
+		if owners != nil && !owners[task.UserID] {
+			continue
+		}
+		if taskMatchesFilter(task, filter) {
+			matched = append(matched, task)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareTasks(matched[i], matched[j], filter.SortBy) < 0
+	})
+	if filter.SortDesc {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	page := &Domain.TaskPage{Total: int64(len(matched))}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return taskIsAfterCursor(matched[i], after)
+		})
+	}
+	end := start + filter.Limit
+	if end < len(matched) {
+		page.Tasks = matched[start:end]
+		page.NextCursor = encodeTaskCursor(filter, matched[end-1])
+	} else {
+		page.Tasks = matched[start:]
+	}
+	return page, nil
+}
+
+func (r *inMemoryTaskRepository) GetTask(ctx context.Context, id, ownerID string) (*Domain.Task, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	task, err := r.ownedTask(objID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *inMemoryTaskRepository) CreateTask(ctx context.Context, task Domain.Task) (*Domain.Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task.ID = primitive.NewObjectID()
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	task.Version = 1
+	r.tasks[task.ID] = task
+	return &task, nil
+}
+
+func (r *inMemoryTaskRepository) UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, err := r.versionedTask(objID, ownerID, version)
+	if err != nil {
+		return nil, err
+	}
+	task.Title = updatedTask.Title
+	task.Description = updatedTask.Description
+	task.DueDate = updatedTask.DueDate
+	task.Status = updatedTask.Status
+	task.StatusHistory = updatedTask.StatusHistory
+	task.UpdatedAt = time.Now()
+	task.Version++
+	r.tasks[objID] = task
+	return &task, nil
+}
+
+func (r *inMemoryTaskRepository) PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (*Domain.Task, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, err := r.versionedTask(objID, ownerID, version)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Title != nil {
+		task.Title = *patch.Title
+	}
+	if patch.Description != nil {
+		task.Description = *patch.Description
+	}
+	if patch.DueDate != nil {
+		task.DueDate = *patch.DueDate
+	}
+	if patch.Status != nil {
+		task.Status = *patch.Status
+	}
+	if patch.StatusChange != nil {
+		history := make([]Domain.StatusChange, len(task.StatusHistory), len(task.StatusHistory)+1)
+		copy(history, task.StatusHistory)
+		task.StatusHistory = append(history, *patch.StatusChange)
+	}
+	task.UpdatedAt = time.Now()
+	task.Version++
+	r.tasks[objID] = task
+	return &task, nil
+}
+
+func (r *inMemoryTaskRepository) DeleteTask(ctx context.Context, id, ownerID string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.versionedTask(objID, ownerID, version); err != nil {
+		return err
+	}
+	delete(r.tasks, objID)
+	return nil
+}
+
+func (r *inMemoryTaskRepository) DeleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deleted int64
+	for id, task := range r.tasks {
+		if task.UserID == objID {
+			delete(r.tasks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *inMemoryTaskRepository) ReassignTasks(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	fromID, err := primitive.ObjectIDFromHex(fromUserID)
+	if err != nil {
+		return 0, Domain.ErrInvalidID
+	}
+	toID, err := primitive.ObjectIDFromHex(toUserID)
+	if err != nil {
+		return 0, Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var reassigned int64
+	for id, task := range r.tasks {
+		if task.UserID == fromID {
+			task.UserID = toID
+			task.UpdatedAt = now
+			task.Version++
+			r.tasks[id] = task
+			reassigned++
+		}
+	}
+	return reassigned, nil
+}
+
+// ownedTask must be called with r.mu held.
+func (r *inMemoryTaskRepository) ownedTask(id primitive.ObjectID, ownerID string) (Domain.Task, error) {
+	task, exists := r.tasks[id]
+	if !exists || (ownerID != "" && task.UserID.Hex() != ownerID) {
+		return Domain.Task{}, Domain.ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// versionedTask is ownedTask plus the compare-and-swap version check.
+func (r *inMemoryTaskRepository) versionedTask(id primitive.ObjectID, ownerID string, version int64) (Domain.Task, error) {
+	task, err := r.ownedTask(id, ownerID)
+	if err != nil {
+		return Domain.Task{}, err
+	}
+	if version != 0 && task.Version != version {
+		return Domain.Task{}, Domain.ErrVersionMismatch
+	}
+	return task, nil
+}
+
+// snapshot returns a copy of every stored task.
+func (r *inMemoryTaskRepository) snapshot() []Domain.Task {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks := make([]Domain.Task, 0, len(r.tasks))
+	for _, task := range r.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// load replaces the stored tasks.
+func (r *inMemoryTaskRepository) load(tasks []Domain.Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks = make(map[primitive.ObjectID]Domain.Task, len(tasks))
+	for _, task := range tasks {
+		r.tasks[task.ID] = task
+	}
+}
+
+// ownerSet parses TaskFilter.Owners, keeping nil apart from empty.
+func ownerSet(owners []string) (map[primitive.ObjectID]bool, error) {
+	if owners == nil {
+		return nil, nil
+	}
+	set := make(map[primitive.ObjectID]bool, len(owners))
+	for _, owner := range owners {
+		id, err := primitive.ObjectIDFromHex(owner)
+		if err != nil {
+			return nil, Domain.ErrInvalidID
+		}
+		set[id] = true
+	}
+	return set, nil
+}
+
+// taskMatchesFilter applies every filter field except the owner and cursor,
+// mirroring taskFilterQuery.
+func taskMatchesFilter(task Domain.Task, filter Domain.TaskFilter) bool {
+	if filter.Status != "" && task.Status != filter.Status {
+		return false
+	}
+	if filter.DueAfter != nil && task.DueDate.Before(*filter.DueAfter) {
+		return false
+	}
+	if filter.DueBefore != nil && !task.DueDate.Before(*filter.DueBefore) {
+		return false
+	}
+	if filter.Search != "" && !strings.Contains(strings.ToLower(task.Title), strings.ToLower(filter.Search)) {
+		return false
+	}
+	return true
+}
+
+// compareTasks orders tasks by field and then by id, ascending.
+func compareTasks(a, b Domain.Task, field string) int {
+	if c := compareSortValues(taskSortValue(a, field), taskSortValue(b, field)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.Hex(), b.ID.Hex())
+}
+
+func compareSortValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case time.Time:
+		return av.Compare(b.(time.Time))
+	case string:
+		return strings.Compare(av, b.(string))
+	}
+	return 0
+}
+
+func taskIsAfterCursor(task Domain.Task, after *taskCursor) bool {
+	value, _ := after.sortValue()
+	c := compareSortValues(taskSortValue(task, after.SortBy), value)
+	if c == 0 {
+		c = strings.Compare(task.ID.Hex(), after.ID)
+	}
+	if after.Desc {
+		return c < 0
+	}
+	return c > 0
+}
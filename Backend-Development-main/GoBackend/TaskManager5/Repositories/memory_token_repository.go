@@ -0,0 +1,160 @@
+package Repositories
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type inMemoryTokenRepository struct {
+	mu            sync.RWMutex
+	refreshTokens map[string]Domain.RefreshToken
+	revocations   map[string]time.Time
+}
+
+func NewInMemoryTokenRepository() TokenRepository {
+	return newInMemoryTokenRepository()
+}
+
+func newInMemoryTokenRepository() *inMemoryTokenRepository {
+	return &inMemoryTokenRepository{
+		refreshTokens: make(map[string]Domain.RefreshToken),
+		revocations:   make(map[string]time.Time),
+	}
+}
+
+func (r *inMemoryTokenRepository) CreateRefreshToken(ctx context.Context, token Domain.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.purgeExpired()
+	r.refreshTokens[token.ID] = token
+	return nil
+}
+
+func (r *inMemoryTokenRepository) GetRefreshToken(ctx context.Context, id string) (*Domain.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	token, exists := r.refreshTokens[id]
+	if !exists {
+		return nil, Domain.ErrTokenNotFound
+	}
+	return &token, nil
+}
+
+func (r *inMemoryTokenRepository) RotateRefreshToken(ctx context.Context, id, replacedBy string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, exists := r.refreshTokens[id]
+	if !exists || token.ReplacedBy != "" || token.RevokedAt != nil {
+		return Domain.ErrTokenReused
+	}
+	token.ReplacedBy = replacedBy
+	r.refreshTokens[id] = token
+	return nil
+}
+
+func (r *inMemoryTokenRepository) RevokeSession(ctx context.Context, sessionID string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, token := range r.refreshTokens {
+		if token.SessionID == sessionID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.refreshTokens[id] = token
+		}
+	}
+	r.revocations[sessionRevocationID(sessionID)] = until
+	return nil
+}
+
+func (r *inMemoryTokenRepository) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for id, token := range r.refreshTokens {
+		if token.UserID != userID {
+			continue
+		}
+		if token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.refreshTokens[id] = token
+		}
+		r.revocations[sessionRevocationID(token.SessionID)] = until
+	}
+	return nil
+}
+
+func (r *inMemoryTokenRepository) RevokeAccessToken(ctx context.Context, tokenID string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.revocations[tokenRevocationID(tokenID)] = until
+	return nil
+}
+
+func (r *inMemoryTokenRepository) IsRevoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, revoked := r.revocations[tokenRevocationID(tokenID)]; revoked {
+		return true, nil
+	}
+	if sessionID == "" {
+		return false, nil
+	}
+	_, revoked := r.revocations[sessionRevocationID(sessionID)]
+	return revoked, nil
+}
+
+// purgeExpired drops entries that can no longer matter. It must be called
+// with r.mu held for writing.
+func (r *inMemoryTokenRepository) purgeExpired() {
+	now := time.Now()
+	for id, token := range r.refreshTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(r.refreshTokens, id)
+		}
+	}
+	for id, until := range r.revocations {
+		if until.Before(now) {
+			delete(r.revocations, id)
+		}
+	}
+}
+
+// tokenSnapshot is the persisted form of an inMemoryTokenRepository.
+type tokenSnapshot struct {
+	RefreshTokens []Domain.RefreshToken `bson:"refresh_tokens"`
+	Revocations   []revocation          `bson:"revocations"`
+}
+
+func (r *inMemoryTokenRepository) snapshot() tokenSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshot := tokenSnapshot{
+		RefreshTokens: make([]Domain.RefreshToken, 0, len(r.refreshTokens)),
+		Revocations:   make([]revocation, 0, len(r.revocations)),
+	}
+	for _, token := range r.refreshTokens {
+		snapshot.RefreshTokens = append(snapshot.RefreshTokens, token)
+	}
+	for id, until := range r.revocations {
+		snapshot.Revocations = append(snapshot.Revocations, revocation{ID: id, ExpiresAt: until})
+	}
+	return snapshot
+}
+
+func (r *inMemoryTokenRepository) load(snapshot tokenSnapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshTokens = make(map[string]Domain.RefreshToken, len(snapshot.RefreshTokens))
+	r.revocations = make(map[string]time.Time, len(snapshot.Revocations))
+	for _, token := range snapshot.RefreshTokens {
+		r.refreshTokens[token.ID] = token
+	}
+	for _, entry := range snapshot.Revocations {
+		r.revocations[entry.ID] = entry.ExpiresAt
+	}
+	r.purgeExpired()
+}
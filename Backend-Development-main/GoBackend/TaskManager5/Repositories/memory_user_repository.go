@@ -0,0 +1,197 @@
+package Repositories
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// inMemoryUserRepository is the map+mutex counterpart of userRepository.
+type inMemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]Domain.User
+}
+
+func NewInMemoryUserRepository() UserRepository {
+	return newInMemoryUserRepository()
+}
+
+func newInMemoryUserRepository() *inMemoryUserRepository {
+	return &inMemoryUserRepository{
+		users: make(map[primitive.ObjectID]Domain.User),
+	}
+}
+
+func (r *inMemoryUserRepository) CreateUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
+	user.ID = primitive.NewObjectID()
+	if user.Role == "" {
+		user.Role = Domain.RoleUser
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.usernameTaken(user.Username, user.ID) {
+		return nil, Domain.ErrUsernameTaken
+	}
+	r.users[user.ID] = user
+	return &user, nil
+}
+
+func (r *inMemoryUserRepository) GetUserByUsername(ctx context.Context, username string) (*Domain.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	username = Domain.NormalizeUsername(username)
+	for _, user := range r.users {
+		if Domain.NormalizeUsername(user.Username) == username {
+			return &user, nil
+		}
+	}
+	return nil, Domain.ErrUserNotFound
+}
+
+// usernameTaken reports whether a user other than except has the username,
+// ignoring case. It must be called with r.mu held.
+func (r *inMemoryUserRepository) usernameTaken(username string, except primitive.ObjectID) bool {
+	username = Domain.NormalizeUsername(username)
+	for id, user := range r.users {
+		if id != except && Domain.NormalizeUsername(user.Username) == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *inMemoryUserRepository) GetUserByID(ctx context.Context, userID string) (*Domain.User, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, exists := r.users[objID]
+	if !exists {
+		return nil, Domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *inMemoryUserRepository) GetAllUsers(ctx context.Context) ([]Domain.User, error) {
+	users := r.snapshot()
+	sort.Slice(users, func(i, j int) bool {
+		return strings.Compare(users[i].ID.Hex(), users[j].ID.Hex()) < 0
+	})
+	return users, nil
+}
+
+func (r *inMemoryUserRepository) GetUsersByTeam(ctx context.Context, team string) ([]Domain.User, error) {
+	users, err := r.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := []Domain.User{}
+	for _, user := range users {
+		if user.Team == team {
+			members = append(members, user)
+		}
+	}
+	return members, nil
+}
+
+func (r *inMemoryUserRepository) UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (*Domain.User, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, exists := r.users[objID]
+	if !exists {
+		return nil, Domain.ErrUserNotFound
+	}
+	if update.Username != nil {
+		if r.usernameTaken(*update.Username, objID) {
+			return nil, Domain.ErrUsernameTaken
+		}
+		user.Username = *update.Username
+	}
+	if update.Role != nil {
+		user.Role = *update.Role
+	}
+	if update.Team != nil {
+		user.Team = *update.Team
+	}
+	if update.Disabled != nil {
+		user.Disabled = *update.Disabled
+	}
+	r.users[objID] = user
+	return &user, nil
+}
+
+func (r *inMemoryUserRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, exists := r.users[objID]
+	if !exists {
+		return Domain.ErrUserNotFound
+	}
+	user.Password = passwordHash
+	r.users[objID] = user
+	return nil
+}
+
+func (r *inMemoryUserRepository) UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, exists := r.users[objID]
+	if !exists {
+		return Domain.ErrUserNotFound
+	}
+	mfa.RecoveryCodes = append([]string(nil), mfa.RecoveryCodes...)
+	user.MFA = mfa
+	r.users[objID] = user
+	return nil
+}
+
+func (r *inMemoryUserRepository) DeleteUser(ctx context.Context, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.users[objID]; !exists {
+		return Domain.ErrUserNotFound
+	}
+	delete(r.users, objID)
+	return nil
+}
+
+func (r *inMemoryUserRepository) snapshot() []Domain.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	users := make([]Domain.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+func (r *inMemoryUserRepository) load(users []Domain.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users = make(map[primitive.ObjectID]Domain.User, len(users))
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+}
@@ -0,0 +1,96 @@
+package Repositories
+
+import (
+	"context"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RoleRepository stores the role definitions. A new store holds
+// Domain.DefaultRoles.
+type RoleRepository interface {
+	GetRole(ctx context.Context, name string) (*Domain.Role, error)
+	// ListRoles returns the roles sorted by name.
+	ListRoles(ctx context.Context) ([]Domain.Role, error)
+	// SaveRole creates or replaces the role.
+	SaveRole(ctx context.Context, role Domain.Role) error
+	DeleteRole(ctx context.Context, name string) error
+}
+
+type roleRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+func NewRoleRepository(db *mongo.Database, timeout time.Duration) RoleRepository {
+	return &roleRepository{collection: db.Collection("roles"), timeout: timeout}
+}
+
+// ensureDefaultRoles creates the default roles that are missing, leaving
+// any that admins have changed alone.
+func ensureDefaultRoles(ctx context.Context, db *mongo.Database) error {
+	roles := db.Collection("roles")
+	for _, role := range Domain.DefaultRoles() {
+		_, err := roles.UpdateOne(ctx, bson.M{"_id": role.Name},
+			bson.M{"$setOnInsert": bson.M{"permissions": role.Permissions}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rr *roleRepository) GetRole(ctx context.Context, name string) (*Domain.Role, error) {
+	ctx, cancel := operationContext(ctx, rr.timeout)
+	defer cancel()
+	var role Domain.Role
+	err := rr.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, Domain.ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (rr *roleRepository) ListRoles(ctx context.Context) ([]Domain.Role, error) {
+	ctx, cancel := operationContext(ctx, rr.timeout)
+	defer cancel()
+	cursor, err := rr.collection.Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	roles := []Domain.Role{}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (rr *roleRepository) SaveRole(ctx context.Context, role Domain.Role) error {
+	ctx, cancel := operationContext(ctx, rr.timeout)
+	defer cancel()
+	_, err := rr.collection.ReplaceOne(ctx, bson.M{"_id": role.Name}, role,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+func (rr *roleRepository) DeleteRole(ctx context.Context, name string) error {
+	ctx, cancel := operationContext(ctx, rr.timeout)
+	defer cancel()
+	result, err := rr.collection.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return Domain.ErrRoleNotFound
+	}
+	return nil
+}
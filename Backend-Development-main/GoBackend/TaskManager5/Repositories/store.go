@@ -0,0 +1,176 @@
+package Repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	DriverMongo  = "mongo"
+	DriverMemory = "memory"
+	DriverFile   = "file"
+)
+
+// StoreOptions selects and configures a storage backend. MongoURI and
+// Database are used by the mongo driver, DataDir by the file driver.
+// OperationTimeout bounds each MongoDB operation, on top of any deadline the
+// caller's context has; zero means no bound of its own.
+type StoreOptions struct {
+	Driver           string
+	MongoURI         string
+	Database         string
+	DataDir          string
+	OperationTimeout time.Duration
+}
+
+// Store bundles the repositories of one storage backend. Login attempts
+// are kept in memory whatever the driver.
+type Store struct {
+	Tasks         TaskRepository
+	Users         UserRepository
+	Tokens        TokenRepository
+	LoginAttempts LoginAttemptRepository
+	Roles         RoleRepository
+	APIKeys       APIKeyRepository
+	close         func(ctx context.Context) error
+	ping          func(ctx context.Context) error
+	migrate       func(ctx context.Context) error
+	migrated      atomic.Bool
+}
+
+// OpenStore connects the backend named by opts.Driver. The MongoDB backend
+// connects lazily, so it opens even while the server is unreachable; call
+// Migrate before relying on it.
+func OpenStore(ctx context.Context, opts StoreOptions) (*Store, error) {
+	switch opts.Driver {
+	case DriverMongo:
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(opts.MongoURI))
+		if err != nil {
+			return nil, err
+		}
+		db := client.Database(opts.Database)
+		return &Store{
+			Tasks:         NewTaskRepository(db, opts.OperationTimeout),
+			Users:         NewUserRepository(db, opts.OperationTimeout),
+			Tokens:        NewTokenRepository(db, opts.OperationTimeout),
+			LoginAttempts: NewInMemoryLoginAttemptRepository(),
+			Roles:         NewRoleRepository(db, opts.OperationTimeout),
+			APIKeys:       NewAPIKeyRepository(db, opts.OperationTimeout),
+			close:         client.Disconnect,
+			ping: func(ctx context.Context) error {
+				return client.Ping(ctx, readpref.Primary())
+			},
+			migrate: func(ctx context.Context) error {
+				return migrateMongo(ctx, db)
+			},
+		}, nil
+	case DriverMemory:
+		return &Store{
+			Tasks:         NewInMemoryTaskRepository(),
+			Users:         NewInMemoryUserRepository(),
+			Tokens:        NewInMemoryTokenRepository(),
+			LoginAttempts: NewInMemoryLoginAttemptRepository(),
+			Roles:         NewInMemoryRoleRepository(),
+			APIKeys:       NewInMemoryAPIKeyRepository(),
+		}, nil
+	case DriverFile:
+		tasks, err := NewFileTaskRepository(filepath.Join(opts.DataDir, "tasks.json"))
+		if err != nil {
+			return nil, err
+		}
+		users, err := NewFileUserRepository(filepath.Join(opts.DataDir, "users.json"))
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := NewFileTokenRepository(filepath.Join(opts.DataDir, "tokens.json"))
+		if err != nil {
+			return nil, err
+		}
+		roles, err := NewFileRoleRepository(filepath.Join(opts.DataDir, "roles.json"))
+		if err != nil {
+			return nil, err
+		}
+		apiKeys, err := NewFileAPIKeyRepository(filepath.Join(opts.DataDir, "api_keys.json"))
+		if err != nil {
+			return nil, err
+		}
+		return &Store{
+			Tasks:         tasks,
+			Users:         users,
+			Tokens:        tokens,
+			LoginAttempts: NewInMemoryLoginAttemptRepository(),
+			Roles:         roles,
+			APIKeys:       apiKeys,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", opts.Driver)
+}
+
+// migrateMongo creates the indexes and default roles the repositories rely
+// on. Each step is idempotent, so it runs on every start.
+func migrateMongo(ctx context.Context, db *mongo.Database) error {
+	if err := ensureTokenIndexes(ctx, db); err != nil {
+		return err
+	}
+	if err := ensureUserIndexes(ctx, db); err != nil {
+		return err
+	}
+	if err := ensureDefaultRoles(ctx, db); err != nil {
+		return err
+	}
+	if err := migrateTaskStatuses(ctx, db); err != nil {
+		return err
+	}
+	return ensureAPIKeyIndexes(ctx, db)
+}
+
+// operationContext bounds one storage operation by timeout. A zero timeout
+// leaves ctx as it is.
+func operationContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Migrate brings the backend's schema up to date. It is safe to call again
+// after a failure.
+func (s *Store) Migrate(ctx context.Context) error {
+	if s.migrate != nil {
+		if err := s.migrate(ctx); err != nil {
+			return fmt.Errorf("migrating storage: %w", err)
+		}
+	}
+	s.migrated.Store(true)
+	return nil
+}
+
+// Ready reports why the store can't serve requests yet, if it can't: its
+// migrations haven't been applied, or its server doesn't answer.
+func (s *Store) Ready(ctx context.Context) error {
+	if !s.migrated.Load() {
+		return errors.New("storage migrations not applied")
+	}
+	if s.ping != nil {
+		if err := s.ping(ctx); err != nil {
+			return fmt.Errorf("pinging storage: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases the backend's connections, if it holds any.
+func (s *Store) Close(ctx context.Context) error {
+	if s.close == nil {
+		return nil
+	}
+	return s.close(ctx)
+}
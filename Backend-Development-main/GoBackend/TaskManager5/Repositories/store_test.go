@@ -0,0 +1,25 @@
+package Repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreReadyOnceMigrated(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []StoreOptions{
+		{Driver: DriverMemory},
+		{Driver: DriverFile, DataDir: t.TempDir()},
+	} {
+		store, err := OpenStore(ctx, opts)
+		require.NoError(t, err)
+
+		assert.Error(t, store.Ready(ctx), "%s: not ready before migrating", opts.Driver)
+		require.NoError(t, store.Migrate(ctx))
+		assert.NoError(t, store.Ready(ctx), opts.Driver)
+		assert.NoError(t, store.Close(ctx))
+	}
+}
@@ -0,0 +1,89 @@
+package Repositories
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// taskCursor is the decoded form of the opaque next-page token. It records
+// the sort it was issued for so a token can't be replayed against another order.
+type taskCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+func encodeTaskCursor(filter Domain.TaskFilter, last Domain.Task) string {
+	c := taskCursor{
+		SortBy: filter.SortBy,
+		Desc:   filter.SortDesc,
+		ID:     last.ID.Hex(),
+	}
+	switch v := taskSortValue(last, filter.SortBy).(type) {
+	case time.Time:
+		c.Value = v.UTC().Format(time.RFC3339Nano)
+	case string:
+		c.Value = v
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeTaskCursor returns nil when the filter has no cursor.
+func decodeTaskCursor(filter Domain.TaskFilter) (*taskCursor, error) {
+	if filter.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(filter.Cursor)
+	if err != nil {
+		return nil, Domain.ErrInvalidCursor
+	}
+	var c taskCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, Domain.ErrInvalidCursor
+	}
+	if c.SortBy != filter.SortBy || c.Desc != filter.SortDesc {
+		return nil, Domain.ErrInvalidCursor
+	}
+	if _, err := primitive.ObjectIDFromHex(c.ID); err != nil {
+		return nil, Domain.ErrInvalidCursor
+	}
+	if _, err := c.sortValue(); err != nil {
+		return nil, Domain.ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (c *taskCursor) objectID() primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(c.ID)
+	return id
+}
+
+func (c *taskCursor) sortValue() (interface{}, error) {
+	switch c.SortBy {
+	case "created_at", "updated_at", "due_date":
+		return time.Parse(time.RFC3339Nano, c.Value)
+	case "title", "status":
+		return c.Value, nil
+	}
+	return nil, Domain.ErrInvalidCursor
+}
+
+func taskSortValue(task Domain.Task, field string) interface{} {
+	switch field {
+	case "updated_at":
+		return task.UpdatedAt
+	case "due_date":
+		return task.DueDate
+	case "title":
+		return task.Title
+	case "status":
+		return string(task.Status)
+	}
+	return task.CreatedAt
+}
@@ -2,127 +2,381 @@ package Repositories
 
 import (
 	"context"
-	"errors"
+	"regexp"
 	"time"
 
 	"TaskManager5/Domain"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// TaskRepository stores tasks. The write methods take the version the
+// caller expects the task to be at and fail with Domain.ErrVersionMismatch
+// if it has moved on; version 0 skips the check. Every write bumps the
+// version.
 type TaskRepository interface {
-	GetTasks() ([]Domain.Task, error)
-	GetTask(id string) (*Domain.Task, error)
-	CreateTask(task Domain.Task) (*Domain.Task, error)
-	UpdateTask(id string, updatedTask Domain.Task) (*Domain.Task, error)
-	DeleteTask(id string) error
-	GetTasksByUserID(userID string) ([]Domain.Task, error)
+	GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error)
+	GetTask(ctx context.Context, id, ownerID string) (*Domain.Task, error)
+	CreateTask(ctx context.Context, task Domain.Task) (*Domain.Task, error)
+	UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (*Domain.Task, error)
+	PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (*Domain.Task, error)
+	DeleteTask(ctx context.Context, id, ownerID string, version int64) error
+	// DeleteTasksByUserID and ReassignTasks act on every task a user owns
+	// and return how many tasks they touched.
+	DeleteTasksByUserID(ctx context.Context, userID string) (int64, error)
+	ReassignTasks(ctx context.Context, fromUserID, toUserID string) (int64, error)
+}
+
+// taskCollection is the subset of *mongo.Collection the repository uses.
+type taskCollection interface {
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
 }
 
 type taskRepository struct {
-	collection *mongo.Collection
+	collection taskCollection
+	timeout    time.Duration
 }
 
-func NewTaskRepository(db *mongo.Database) TaskRepository {
+func NewTaskRepository(db *mongo.Database, timeout time.Duration) TaskRepository {
 	return &taskRepository{
 		collection: db.Collection("tasks"),
+		timeout:    timeout,
 	}
 }
 
-func (tr *taskRepository) GetTasks() ([]Domain.Task, error) {
-	var tasks []Domain.Task
-	cursor, err := tr.collection.Find(context.Background(), bson.D{})
+// migrateTaskStatuses rewrites the statuses stored before they were
+// validated, such as "pending" or "Completed", to the values they are read
+// as, so that filtering and counting by status finds those tasks. Values
+// that aren't statuses at all are left for somebody to look at.
+func migrateTaskStatuses(ctx context.Context, db *mongo.Database) error {
+	tasks := db.Collection("tasks")
+	stored, err := tasks.Distinct(ctx, "status", bson.M{})
+	if err != nil {
+		return err
+	}
+	for _, value := range stored {
+		old, ok := value.(string)
+		if !ok {
+			continue
+		}
+		status, err := Domain.ParseTaskStatus(old)
+		if err != nil || string(status) == old {
+			continue
+		}
+		if _, err := tasks.UpdateMany(ctx, bson.M{"status": old}, bson.M{"$set": bson.M{"status": status}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tr *taskRepository) GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	filter = filter.WithDefaults()
+	query, err := taskFilterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	after, err := decodeTaskCursor(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := tr.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	direction := 1
+	if filter.SortDesc {
+		direction = -1
+	}
+	if after != nil {
+		query = bson.M{"$and": bson.A{query, taskCursorQuery(after, direction)}}
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: filter.SortBy, Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(filter.Limit + 1))
+
+	cursor, err := tr.collection.Find(ctx, query, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
-	for cursor.Next(context.Background()) {
+	defer cursor.Close(ctx)
+	tasks := []Domain.Task{}
+	for cursor.Next(ctx) {
 		var task Domain.Task
 		if err := cursor.Decode(&task); err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
 	}
-	return tasks, nil
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &Domain.TaskPage{Tasks: tasks, Total: total}
+	if len(tasks) > filter.Limit {
+		page.Tasks = tasks[:filter.Limit]
+		page.NextCursor = encodeTaskCursor(filter, page.Tasks[filter.Limit-1])
+	}
+	return page, nil
 }
 
-func (tr *taskRepository) GetTask(id string) (*Domain.Task, error) {
+// taskFilterQuery translates everything but the cursor into a Mongo query.
+func taskFilterQuery(filter Domain.TaskFilter) (bson.M, error) {
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.UserID != "" {
+		userID, err := primitive.ObjectIDFromHex(filter.UserID)
+		if err != nil {
+			return nil, Domain.ErrInvalidID
+		}
+		query["user_id"] = userID
+	}
+	if filter.Owners != nil {
+		owners := make([]primitive.ObjectID, 0, len(filter.Owners))
+		for _, owner := range filter.Owners {
+			id, err := primitive.ObjectIDFromHex(owner)
+			if err != nil {
+				return nil, Domain.ErrInvalidID
+			}
+			owners = append(owners, id)
+		}
+		condition := bson.M{"$in": owners}
+		if userID, ok := query["user_id"]; ok {
+			condition["$eq"] = userID
+		}
+		query["user_id"] = condition
+	}
+	if filter.DueAfter != nil || filter.DueBefore != nil {
+		due := bson.M{}
+		if filter.DueAfter != nil {
+			due["$gte"] = *filter.DueAfter
+		}
+		if filter.DueBefore != nil {
+			due["$lt"] = *filter.DueBefore
+		}
+		query["due_date"] = due
+	}
+	if filter.Search != "" {
+		query["title"] = primitive.Regex{Pattern: regexp.QuoteMeta(filter.Search), Options: "i"}
+	}
+	return query, nil
+}
+
+// taskCursorQuery matches the documents that sort strictly after the cursor,
+// using _id to break ties between equal sort values.
+func taskCursorQuery(after *taskCursor, direction int) bson.M {
+	op := "$gt"
+	if direction < 0 {
+		op = "$lt"
+	}
+	value, _ := after.sortValue()
+	return bson.M{"$or": bson.A{
+		bson.M{after.SortBy: bson.M{op: value}},
+		bson.M{after.SortBy: value, "_id": bson.M{op: after.objectID()}},
+	}}
+}
+
+// taskQuery matches the task with the given id, restricted to ownerID's
+// tasks unless ownerID is empty.
+func taskQuery(id, ownerID string) (bson.M, error) {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, errors.New("invalid id")
+		return nil, Domain.ErrInvalidID
+	}
+	query := bson.M{"_id": objID}
+	if ownerID != "" {
+		ownerObjID, err := primitive.ObjectIDFromHex(ownerID)
+		if err != nil {
+			return nil, Domain.ErrInvalidID
+		}
+		query["user_id"] = ownerObjID
+	}
+	return query, nil
+}
+
+func (tr *taskRepository) GetTask(ctx context.Context, id, ownerID string) (*Domain.Task, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	query, err := taskQuery(id, ownerID)
+	if err != nil {
+		return nil, err
 	}
 	var task Domain.Task
-	err = tr.collection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&task)
+	err = tr.collection.FindOne(ctx, query).Decode(&task)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, Domain.ErrTaskNotFound
+		}
 		return nil, err
 	}
 	return &task, nil
 }
 
-func (tr *taskRepository) CreateTask(task Domain.Task) (*Domain.Task, error) {
+func (tr *taskRepository) CreateTask(ctx context.Context, task Domain.Task) (*Domain.Task, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
 	task.ID = primitive.NewObjectID()
 	task.CreatedAt = time.Now()
 	task.UpdatedAt = time.Now()
-	_, err := tr.collection.InsertOne(context.Background(), task)
+	task.Version = 1
+	_, err := tr.collection.InsertOne(ctx, task)
 	if err != nil {
-		return nil, errors.New("failed to create task")
+		return nil, err
 	}
 	return &task, nil
 }
 
-func (tr *taskRepository) UpdateTask(id string, updatedTask Domain.Task) (*Domain.Task, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+// versionedTaskQuery is taskQuery plus the expected version, so the write
+// is a compare-and-swap.
+func versionedTaskQuery(id, ownerID string, version int64) (bson.M, error) {
+	query, err := taskQuery(id, ownerID)
 	if err != nil {
-		return nil, errors.New("invalid id")
+		return nil, err
+	}
+	if version != 0 {
+		query["version"] = version
+	}
+	return query, nil
+}
+
+// writeMissed explains why a write matched no document: either the task
+// isn't there for this owner or it is at another version.
+func (tr *taskRepository) writeMissed(ctx context.Context, id, ownerID string, version int64) error {
+	if version == 0 {
+		return Domain.ErrTaskNotFound
+	}
+	if _, err := tr.GetTask(ctx, id, ownerID); err != nil {
+		return err
+	}
+	return Domain.ErrVersionMismatch
+}
+
+func (tr *taskRepository) UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	filter, err := versionedTaskQuery(id, ownerID, version)
+	if err != nil {
+		return nil, err
 	}
-	filter := bson.M{"_id": objID}
 	update := bson.M{
 		"$set": bson.M{
-			"title":       updatedTask.Title,
-			"description": updatedTask.Description,
-			"due_date":    updatedTask.DueDate,
-			"status":      updatedTask.Status,
-			"user_id":     updatedTask.UserID,
-			"updated_at":  time.Now(),
+			"title":          updatedTask.Title,
+			"description":    updatedTask.Description,
+			"due_date":       updatedTask.DueDate,
+			"status":         updatedTask.Status,
+			"status_history": updatedTask.StatusHistory,
+			"updated_at":     time.Now(),
 		},
+		"$inc": bson.M{"version": 1},
 	}
-	_, err = tr.collection.UpdateOne(context.Background(), filter, update)
+	result, err := tr.collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return nil, err
 	}
-	return tr.GetTask(id)
+	if result.MatchedCount == 0 {
+		return nil, tr.writeMissed(ctx, id, ownerID, version)
+	}
+	return tr.GetTask(ctx, id, "")
 }
 
-func (tr *taskRepository) DeleteTask(id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+// PatchTask sets only the fields present in the patch.
+func (tr *taskRepository) PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (*Domain.Task, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	filter, err := versionedTaskQuery(id, ownerID, version)
 	if err != nil {
-		return errors.New("invalid id")
+		return nil, err
+	}
+	set := bson.M{"updated_at": time.Now()}
+	if patch.Title != nil {
+		set["title"] = *patch.Title
+	}
+	if patch.Description != nil {
+		set["description"] = *patch.Description
+	}
+	if patch.DueDate != nil {
+		set["due_date"] = *patch.DueDate
+	}
+	if patch.Status != nil {
+		set["status"] = *patch.Status
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	if patch.StatusChange != nil {
+		update["$push"] = bson.M{"status_history": *patch.StatusChange}
 	}
-	_, err = tr.collection.DeleteOne(context.Background(), bson.M{"_id": objID})
+	result, err := tr.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, tr.writeMissed(ctx, id, ownerID, version)
+	}
+	return tr.GetTask(ctx, id, "")
+}
+
+func (tr *taskRepository) DeleteTask(ctx context.Context, id, ownerID string, version int64) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	filter, err := versionedTaskQuery(id, ownerID, version)
 	if err != nil {
 		return err
 	}
+	result, err := tr.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return tr.writeMissed(ctx, id, ownerID, version)
+	}
 	return nil
 }
 
-func (tr *taskRepository) GetTasksByUserID(userID string) ([]Domain.Task, error) {
-	var tasks []Domain.Task
+func (tr *taskRepository) DeleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
 	objID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return nil, err
+		return 0, Domain.ErrInvalidID
 	}
-	cursor, err := tr.collection.Find(context.Background(), bson.M{"user_id": objID})
+	result, err := tr.collection.DeleteMany(ctx, bson.M{"user_id": objID})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer cursor.Close(context.Background())
-	for cursor.Next(context.Background()) {
-		var task Domain.Task
-		if err := cursor.Decode(&task); err != nil {
-			return nil, err
-		}
-		tasks = append(tasks, task)
+	return result.DeletedCount, nil
+}
+
+func (tr *taskRepository) ReassignTasks(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	fromID, err := primitive.ObjectIDFromHex(fromUserID)
+	if err != nil {
+		return 0, Domain.ErrInvalidID
+	}
+	toID, err := primitive.ObjectIDFromHex(toUserID)
+	if err != nil {
+		return 0, Domain.ErrInvalidID
+	}
+	result, err := tr.collection.UpdateMany(ctx, bson.M{"user_id": fromID}, bson.M{
+		"$set": bson.M{"user_id": toID, "updated_at": time.Now()},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return 0, err
 	}
-	return tasks, nil
+	return result.ModifiedCount, nil
 }
@@ -8,6 +8,7 @@ import (
 	"TaskManager5/Domain"
 
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 	"github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -47,6 +48,13 @@ func (m *MockCollection) UpdateOne(ctx context.Context, filter interface{},
 	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
 }
 
+// Mock UpdateMany method
+func (m *MockCollection) UpdateMany(ctx context.Context, filter interface{},
+	update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	args := m.Called(ctx, filter, update, opts)
+	return args.Get(0).(*mongo.UpdateResult), args.Error(1)
+}
+
 // Mock DeleteOne method
 func (m *MockCollection) DeleteOne(ctx context.Context, filter interface{},
 	opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
@@ -54,8 +62,23 @@ func (m *MockCollection) DeleteOne(ctx context.Context, filter interface{},
 	return args.Get(0).(*mongo.DeleteResult), args.Error(1)
 }
 
+// Mock DeleteMany method
+func (m *MockCollection) DeleteMany(ctx context.Context, filter interface{},
+	opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	args := m.Called(ctx, filter, opts)
+	return args.Get(0).(*mongo.DeleteResult), args.Error(1)
+}
+
+// Mock CountDocuments method
+func (m *MockCollection) CountDocuments(ctx context.Context, filter interface{},
+	opts ...*options.CountOptions) (int64, error) {
+	args := m.Called(ctx, filter, opts)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // TestTaskRepository tests the taskRepository methods
 func TestTaskRepository(t *testing.T) {
+	ctx := context.Background()
 	mockCollection := new(MockCollection)
 	repo := &taskRepository{collection: mockCollection}
 
@@ -65,13 +88,13 @@ func TestTaskRepository(t *testing.T) {
 			Title:       "Test Task",
 			Description: "This is a test task",
 			DueDate:     time.Now().Add(24 * time.Hour),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 		}
 		mockCollection.On("InsertOne", mock.Anything, mock.Anything, mock.Anything).
 			Return(&mongo.InsertOneResult{InsertedID: task.ID}, nil)
 
-		createdTask, err := repo.CreateTask(task)
+		createdTask, err := repo.CreateTask(ctx, task)
 		assert.NoError(t, err)
 		assert.NotNil(t, createdTask)
 		assert.Equal(t, task.Title, createdTask.Title)
@@ -82,17 +105,57 @@ func TestTaskRepository(t *testing.T) {
 
 	// Test GetTasks
 	t.Run("GetTasks", func(t *testing.T) {
-		mockCursor := new(mongo.Cursor)
+		docs := []interface{}{
+			Domain.Task{ID: primitive.NewObjectID(), Title: "First", CreatedAt: time.Now()},
+			Domain.Task{ID: primitive.NewObjectID(), Title: "Second", CreatedAt: time.Now()},
+			Domain.Task{ID: primitive.NewObjectID(), Title: "Third", CreatedAt: time.Now()},
+		}
+		mockCursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+		assert.NoError(t, err)
+		mockCollection.On("CountDocuments", mock.Anything, mock.Anything, mock.Anything).
+			Return(int64(3), nil).Once()
 		mockCollection.On("Find", mock.Anything, mock.Anything, mock.Anything).
-			Return(mockCursor, nil)
+			Return(mockCursor, nil).Once()
+
+		page, err := repo.GetTasks(ctx, Domain.TaskFilter{Limit: 2})
+		assert.NoError(t, err)
+		assert.NotNil(t, page)
+		assert.Len(t, page.Tasks, 2)
+		assert.Equal(t, int64(3), page.Total)
+		assert.NotEmpty(t, page.NextCursor)
+
+		mockCollection.AssertExpectations(t)
+	})
+
+	// Test that the next-page cursor narrows the query past the last task
+	t.Run("GetTasksNextPage", func(t *testing.T) {
+		last := Domain.Task{ID: primitive.NewObjectID(), Title: "Second", CreatedAt: time.Now()}
+		filter := Domain.TaskFilter{}.WithDefaults()
+		filter.Cursor = encodeTaskCursor(filter, last)
 
-		tasks, err := repo.GetTasks()
+		emptyCursor, err := mongo.NewCursorFromDocuments(nil, nil, nil)
 		assert.NoError(t, err)
-		assert.NotNil(t, tasks)
+		mockCollection.On("CountDocuments", mock.Anything, bson.M{}, mock.Anything).
+			Return(int64(2), nil).Once()
+		mockCollection.On("Find", mock.Anything, mock.MatchedBy(func(query bson.M) bool {
+			_, ok := query["$and"]
+			return ok
+		}), mock.Anything).Return(emptyCursor, nil).Once()
+
+		page, err := repo.GetTasks(ctx, filter)
+		assert.NoError(t, err)
+		assert.Empty(t, page.Tasks)
+		assert.Empty(t, page.NextCursor)
 
 		mockCollection.AssertExpectations(t)
 	})
 
+	// Test GetTasks with a cursor issued for another sort order
+	t.Run("GetTasksInvalidCursor", func(t *testing.T) {
+		_, err := repo.GetTasks(ctx, Domain.TaskFilter{SortBy: "title", Cursor: "not-a-cursor"})
+		assert.ErrorIs(t, err, Domain.ErrInvalidCursor)
+	})
+
 	// Test GetTask
 	t.Run("GetTask", func(t *testing.T) {
 		task := Domain.Task{
@@ -100,14 +163,14 @@ func TestTaskRepository(t *testing.T) {
 			Title:       "Unique Task",
 			Description: "Unique Description",
 			DueDate:     time.Now().Add(24 * time.Hour),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 		}
 		mockSingleResult := mongo.NewSingleResultFromDocument(task, nil, nil)
 		mockCollection.On("FindOne", mock.Anything, mock.Anything, mock.Anything).
-			Return(mockSingleResult)
+			Return(mockSingleResult).Once()
 
-		fetchedTask, err := repo.GetTask(task.ID.Hex())
+		fetchedTask, err := repo.GetTask(ctx, task.ID.Hex(), task.UserID.Hex())
 		assert.NoError(t, err)
 		assert.NotNil(t, fetchedTask)
 		assert.Equal(t, task.Title, fetchedTask.Title)
@@ -122,22 +185,22 @@ func TestTaskRepository(t *testing.T) {
 			Title:       "Task to Update",
 			Description: "Description",
 			DueDate:     time.Now().Add(24 * time.Hour),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 		}
 		updatedTask := Domain.Task{
 			Title:       "Updated Title",
 			Description: "Updated Description",
 			DueDate:     time.Now().Add(48 * time.Hour),
-			Status:      "Completed",
+			Status:      Domain.StatusDone,
 			UserID:      task.UserID,
 		}
 		mockCollection.On("UpdateOne", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
-			Return(&mongo.UpdateResult{}, nil)
+			Return(&mongo.UpdateResult{MatchedCount: 1}, nil).Once()
 		mockCollection.On("FindOne", mock.Anything, mock.Anything, mock.Anything).
-			Return(mongo.NewSingleResultFromDocument(updatedTask, nil, nil))
+			Return(mongo.NewSingleResultFromDocument(updatedTask, nil, nil)).Once()
 
-		result, err := repo.UpdateTask(task.ID.Hex(), updatedTask)
+		result, err := repo.UpdateTask(ctx, task.ID.Hex(), "", 0, updatedTask)
 		assert.NoError(t, err)
 		assert.NotNil(t, result)
 		assert.Equal(t, updatedTask.Title, result.Title)
@@ -145,6 +208,20 @@ func TestTaskRepository(t *testing.T) {
 		mockCollection.AssertExpectations(t)
 	})
 
+	// Test UpdateTask against a task that has moved on to another version
+	t.Run("UpdateTaskVersionMismatch", func(t *testing.T) {
+		task := Domain.Task{ID: primitive.NewObjectID(), Title: "Task", Version: 5}
+		mockCollection.On("UpdateOne", mock.Anything, bson.M{"_id": task.ID, "version": int64(4)}, mock.Anything, mock.Anything).
+			Return(&mongo.UpdateResult{MatchedCount: 0}, nil).Once()
+		mockCollection.On("FindOne", mock.Anything, bson.M{"_id": task.ID}, mock.Anything).
+			Return(mongo.NewSingleResultFromDocument(task, nil, nil)).Once()
+
+		_, err := repo.UpdateTask(ctx, task.ID.Hex(), "", 4, Domain.Task{Title: "Stale"})
+		assert.ErrorIs(t, err, Domain.ErrVersionMismatch)
+
+		mockCollection.AssertExpectations(t)
+	})
+
 	// Test DeleteTask
 	t.Run("DeleteTask", func(t *testing.T) {
 		task := Domain.Task{
@@ -152,16 +229,27 @@ func TestTaskRepository(t *testing.T) {
 			Title:       "Task to Delete",
 			Description: "Description",
 			DueDate:     time.Now().Add(24 * time.Hour),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 		}
-		mockCollection.On("DeleteOne", mock.Anything, mock.Anything, mock.Anything).
-			Return(&mongo.DeleteResult{}, nil)
+		mockCollection.On("DeleteOne", mock.Anything, bson.M{"_id": task.ID, "user_id": task.UserID}, mock.Anything).
+			Return(&mongo.DeleteResult{DeletedCount: 1}, nil).Once()
 
-		err := repo.DeleteTask(task.ID.Hex())
+		err := repo.DeleteTask(ctx, task.ID.Hex(), task.UserID.Hex(), 0)
 		assert.NoError(t, err)
 
 		mockCollection.AssertExpectations(t)
 	})
+
+	// Test DeleteTask on a task owned by someone else
+	t.Run("DeleteTaskOtherOwner", func(t *testing.T) {
+		mockCollection.On("DeleteOne", mock.Anything, mock.Anything, mock.Anything).
+			Return(&mongo.DeleteResult{DeletedCount: 0}, nil).Once()
+
+		err := repo.DeleteTask(ctx, primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex(), 0)
+		assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+
+		mockCollection.AssertExpectations(t)
+	})
 }
 
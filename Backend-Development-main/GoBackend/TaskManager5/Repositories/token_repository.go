@@ -0,0 +1,160 @@
+package Repositories
+
+import (
+	"context"
+	"time"
+
+	"TaskManager5/Domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token Domain.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*Domain.RefreshToken, error)
+	// RotateRefreshToken marks the token as replaced by another one. It fails
+	// with Domain.ErrTokenReused if the token was already replaced or revoked.
+	RotateRefreshToken(ctx context.Context, id, replacedBy string) error
+	// RevokeSession revokes every refresh token of the session and rejects
+	// its access tokens until the given time.
+	RevokeSession(ctx context.Context, sessionID string, until time.Time) error
+	// RevokeUserSessions revokes every session the user has refresh tokens
+	// for, as RevokeSession does.
+	RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, until time.Time) error
+	RevokeAccessToken(ctx context.Context, tokenID string, until time.Time) error
+	IsRevoked(ctx context.Context, tokenID, sessionID string) (bool, error)
+}
+
+// revocation is a denylist entry for an access token or a whole session. It
+// only needs to outlive the tokens it covers.
+type revocation struct {
+	ID        string    `bson:"_id"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func tokenRevocationID(tokenID string) string     { return "token:" + tokenID }
+func sessionRevocationID(sessionID string) string { return "session:" + sessionID }
+
+type tokenRepository struct {
+	refreshTokens *mongo.Collection
+	revocations   *mongo.Collection
+	timeout       time.Duration
+}
+
+func NewTokenRepository(db *mongo.Database, timeout time.Duration) TokenRepository {
+	return &tokenRepository{
+		refreshTokens: db.Collection("refresh_tokens"),
+		revocations:   db.Collection("revoked_tokens"),
+		timeout:       timeout,
+	}
+}
+
+// ensureTokenIndexes lets Mongo expire stale refresh tokens and revocations.
+func ensureTokenIndexes(ctx context.Context, db *mongo.Database) error {
+	ttl := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := db.Collection("refresh_tokens").Indexes().CreateOne(ctx, ttl); err != nil {
+		return err
+	}
+	_, err := db.Collection("revoked_tokens").Indexes().CreateOne(ctx, ttl)
+	return err
+}
+
+func (tr *tokenRepository) CreateRefreshToken(ctx context.Context, token Domain.RefreshToken) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	_, err := tr.refreshTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (tr *tokenRepository) GetRefreshToken(ctx context.Context, id string) (*Domain.RefreshToken, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	var token Domain.RefreshToken
+	err := tr.refreshTokens.FindOne(ctx, bson.M{"_id": id}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, Domain.ErrTokenNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (tr *tokenRepository) RotateRefreshToken(ctx context.Context, id, replacedBy string) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	filter := bson.M{"_id": id, "replaced_by": "", "revoked_at": bson.M{"$exists": false}}
+	result, err := tr.refreshTokens.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"replaced_by": replacedBy},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return Domain.ErrTokenReused
+	}
+	return nil
+}
+
+func (tr *tokenRepository) RevokeSession(ctx context.Context, sessionID string, until time.Time) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	_, err := tr.refreshTokens.UpdateMany(ctx,
+		bson.M{"session_id": sessionID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	return tr.revoke(ctx, sessionRevocationID(sessionID), until)
+}
+
+func (tr *tokenRepository) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, until time.Time) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	sessionIDs, err := tr.refreshTokens.Distinct(ctx, "session_id", bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	for _, id := range sessionIDs {
+		sessionID, ok := id.(string)
+		if !ok {
+			continue
+		}
+		if err := tr.RevokeSession(ctx, sessionID, until); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tr *tokenRepository) RevokeAccessToken(ctx context.Context, tokenID string, until time.Time) error {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	return tr.revoke(ctx, tokenRevocationID(tokenID), until)
+}
+
+func (tr *tokenRepository) revoke(ctx context.Context, id string, until time.Time) error {
+	_, err := tr.revocations.ReplaceOne(ctx, bson.M{"_id": id},
+		revocation{ID: id, ExpiresAt: until}, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (tr *tokenRepository) IsRevoked(ctx context.Context, tokenID, sessionID string) (bool, error) {
+	ctx, cancel := operationContext(ctx, tr.timeout)
+	defer cancel()
+	ids := bson.A{tokenRevocationID(tokenID)}
+	if sessionID != "" {
+		ids = append(ids, sessionRevocationID(sessionID))
+	}
+	count, err := tr.revocations.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
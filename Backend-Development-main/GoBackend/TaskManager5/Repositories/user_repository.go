@@ -2,87 +2,112 @@ package Repositories
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"time"
 
 	"TaskManager5/Domain"
-	"TaskManager5/Infrastructure"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// UserRepository stores users. Passwords arrive already hashed and are
+// stored as given. Usernames are unique regardless of case: CreateUser and
+// UpdateUser fail with Domain.ErrUsernameTaken on a clash, and
+// GetUserByUsername matches case-insensitively.
 type UserRepository interface {
-	CreateUser(user Domain.User) (*Domain.User, error)
-	AuthenticateUser(username, password string) (string, error)
-	GetUserByID(userID string) (*Domain.User, error)
-	GetAllUsers() ([]Domain.User, error)
+	CreateUser(ctx context.Context, user Domain.User) (*Domain.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*Domain.User, error)
+	GetUserByID(ctx context.Context, userID string) (*Domain.User, error)
+	GetAllUsers(ctx context.Context) ([]Domain.User, error)
+	GetUsersByTeam(ctx context.Context, team string) ([]Domain.User, error)
+	// UpdateUser sets the non-nil fields of update and returns the user.
+	UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (*Domain.User, error)
+	// UpdatePassword replaces the user's password hash.
+	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	// UpdateMFA replaces the user's second-factor settings.
+	UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) error
+	DeleteUser(ctx context.Context, userID string) error
 }
 
 type userRepository struct {
 	collection *mongo.Collection
-	secretKey string
+	timeout    time.Duration
 }
 
-func NewUserRepository(db *mongo.Database, secretKey string) UserRepository {
+func NewUserRepository(db *mongo.Database, timeout time.Duration) UserRepository {
 	return &userRepository{
 		collection: db.Collection("users"),
-		secretKey: secretKey,
+		timeout:    timeout,
 	}
 }
 
-func (ur *userRepository) CreateUser(user Domain.User) (*Domain.User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+// usernameCollation compares strings case-insensitively. The unique index
+// and username lookups share it, so accounts stored before usernames were
+// normalized are still found and still block look-alike registrations.
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// ensureUserIndexes creates the unique username index. It fails if the
+// collection already holds usernames that differ only in case; those have
+// to be renamed before the server can start.
+func ensureUserIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true).SetCollation(usernameCollation),
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("creating unique username index: %w", err)
 	}
+	return nil
+}
+
+func (ur *userRepository) CreateUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
 	user.ID = primitive.NewObjectID()
-	user.Password = string(hashedPassword)
 	if user.Role == "" {
-		user.Role = "user"
+		user.Role = Domain.RoleUser
 	}
-	_, err = ur.collection.InsertOne(context.Background(), user)
+	_, err := ur.collection.InsertOne(ctx, user)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, Domain.ErrUsernameTaken
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (ur *userRepository) AuthenticateUser(username, password string) (string, error) {
+func (ur *userRepository) GetUserByUsername(ctx context.Context, username string) (*Domain.User, error) {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
 	var user Domain.User
-	err := ur.collection.FindOne(context.Background(), bson.M{"username": username}).Decode(&user)
+	err := ur.collection.FindOne(ctx, bson.M{"username": username},
+		options.FindOne().SetCollation(usernameCollation)).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return "", errors.New("invalid username or password")
+			return nil, Domain.ErrUserNotFound
 		}
-		return "", err
-	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
-		return "", errors.New("invalid username or password")
-	}
-
-	token, err := Infrastructure.GenerateJWT(user, ur.secretKey)
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return token, nil
+	return &user, nil
 }
 
-func (ur *userRepository) GetUserByID(userID string) (*Domain.User, error) {
+func (ur *userRepository) GetUserByID(ctx context.Context, userID string) (*Domain.User, error) {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return nil, err
+		return nil, Domain.ErrInvalidID
 	}
 
 	var user Domain.User
-	err = ur.collection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user)
+	err = ur.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("user not found")
+			return nil, Domain.ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -90,15 +115,25 @@ func (ur *userRepository) GetUserByID(userID string) (*Domain.User, error) {
 	return &user, nil
 }
 
-func (ur *userRepository) GetAllUsers() ([]Domain.User, error) {
-	cursor, err := ur.collection.Find(context.Background(), bson.M{})
+func (ur *userRepository) GetAllUsers(ctx context.Context) ([]Domain.User, error) {
+	return ur.findUsers(ctx, bson.M{})
+}
+
+func (ur *userRepository) GetUsersByTeam(ctx context.Context, team string) ([]Domain.User, error) {
+	return ur.findUsers(ctx, bson.M{"team": team})
+}
+
+func (ur *userRepository) findUsers(ctx context.Context, filter bson.M) ([]Domain.User, error) {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
+	cursor, err := ur.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
 	var users []Domain.User
-	for cursor.Next(context.Background()) {
+	for cursor.Next(ctx) {
 		var user Domain.User
 		if err := cursor.Decode(&user); err != nil {
 			return nil, err
@@ -112,3 +147,91 @@ func (ur *userRepository) GetAllUsers() ([]Domain.User, error) {
 
 	return users, nil
 }
+
+func (ur *userRepository) UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (*Domain.User, error) {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, Domain.ErrInvalidID
+	}
+	set := bson.M{}
+	if update.Username != nil {
+		set["username"] = *update.Username
+	}
+	if update.Role != nil {
+		set["role"] = *update.Role
+	}
+	if update.Team != nil {
+		set["team"] = *update.Team
+	}
+	if update.Disabled != nil {
+		set["disabled"] = *update.Disabled
+	}
+	if len(set) > 0 {
+		result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": set})
+		if err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return nil, Domain.ErrUsernameTaken
+			}
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, Domain.ErrUserNotFound
+		}
+	}
+	return ur.GetUserByID(ctx, userID)
+}
+
+func (ur *userRepository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"password": passwordHash}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return Domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (ur *userRepository) UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) error {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	result, err := ur.collection.UpdateOne(ctx, bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"mfa": mfa}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return Domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (ur *userRepository) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := operationContext(ctx, ur.timeout)
+	defer cancel()
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return Domain.ErrInvalidID
+	}
+	result, err := ur.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return Domain.ErrUserNotFound
+	}
+	return nil
+}
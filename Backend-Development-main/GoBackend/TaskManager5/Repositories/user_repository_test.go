@@ -1,96 +1,106 @@
 package Repositories
 
 import (
+	"context"
 	"testing"
 
 	"TaskManager5/Domain"
-	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
 func TestUserRepository(t *testing.T) {
-	// Setup mtest with MongoDB
-	mt := mtest.New(t, mtest.NewOptions().DatabaseName("testdb"))
-	defer mt.Close()
-
-	// Create UserRepository instance
-	secretKey := "supersecretkey"
-	repo := NewUserRepository(mt.DB, secretKey)
+	ctx := context.Background()
+	// Setup mtest against a mock deployment
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock).DatabaseName("testdb"))
 
 	// Test CreateUser
-	t.Run("CreateUser", func(t *testing.T) {
+	mt.Run("CreateUser", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
 		user := Domain.User{
 			Username: "testuser",
-			Password: "password123",
+			Password: "$argon2id$v=19$m=19456,t=2,p=1$c2FsdA$a2V5",
 			Role:     "user",
 		}
 
-		createdUser, err := repo.CreateUser(user)
+		createdUser, err := repo.CreateUser(ctx, user)
 		assert.NoError(t, err)
 		assert.NotNil(t, createdUser)
 		assert.Equal(t, user.Username, createdUser.Username)
-		assert.NotEmpty(t, createdUser.Password)
 
-		// Check if password is hashed
-		err = bcrypt.CompareHashAndPassword([]byte(createdUser.Password), []byte(user.Password))
-		assert.NoError(t, err)
+		// The password arrives hashed and is stored as given
+		assert.Equal(t, user.Password, createdUser.Password)
 	})
 
-	// Test AuthenticateUser
-	t.Run("AuthenticateUser", func(t *testing.T) {
-		user := Domain.User{
-			Username: "authuser",
-			Password: "authpassword",
-		}
+	// Test CreateUser with a username the unique index rejects
+	mt.Run("CreateUserDuplicate", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
 
-		_, err := repo.CreateUser(user)
-		assert.NoError(t, err)
+		_, err := repo.CreateUser(ctx, Domain.User{Username: "testuser", Password: "password123"})
+		assert.ErrorIs(t, err, Domain.ErrUsernameTaken)
+	})
 
-		token, err := repo.AuthenticateUser(user.Username, user.Password)
+	// Test GetUserByUsername
+	mt.Run("GetUserByUsername", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "testdb.users", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "username", Value: "authuser"},
+			{Key: "password", Value: "hash"},
+			{Key: "role", Value: "user"},
+		}))
+
+		user, err := repo.GetUserByUsername(ctx, "authuser")
 		assert.NoError(t, err)
-		assert.NotEmpty(t, token)
+		assert.Equal(t, "authuser", user.Username)
+		assert.Equal(t, "hash", user.Password)
 	})
 
 	// Test GetUserByID
-	t.Run("GetUserByID", func(t *testing.T) {
-		user := Domain.User{
-			Username: "getuser",
-			Password: "getpassword",
-		}
-		createdUser, err := repo.CreateUser(user)
-		assert.NoError(t, err)
+	mt.Run("GetUserByID", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "testdb.users", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: id},
+			{Key: "username", Value: "getuser"},
+		}))
 
-		fetchedUser, err := repo.GetUserByID(createdUser.ID.Hex())
+		fetchedUser, err := repo.GetUserByID(ctx, id.Hex())
 		assert.NoError(t, err)
 		assert.NotNil(t, fetchedUser)
-		assert.Equal(t, user.Username, fetchedUser.Username)
+		assert.Equal(t, "getuser", fetchedUser.Username)
 	})
 
 	// Test GetAllUsers
-	t.Run("GetAllUsers", func(t *testing.T) {
-		user1 := Domain.User{
-			Username: "user1",
-			Password: "password1",
-		}
-		user2 := Domain.User{
-			Username: "user2",
-			Password: "password2",
-		}
-		_, err := repo.CreateUser(user1)
-		assert.NoError(t, err)
-		_, err = repo.CreateUser(user2)
-		assert.NoError(t, err)
+	mt.Run("GetAllUsers", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		first := mtest.CreateCursorResponse(1, "testdb.users", mtest.FirstBatch,
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "username", Value: "user1"}},
+			bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "username", Value: "user2"}},
+		)
+		end := mtest.CreateCursorResponse(0, "testdb.users", mtest.NextBatch)
+		mt.AddMockResponses(first, end)
 
-		users, err := repo.GetAllUsers()
+		users, err := repo.GetAllUsers(ctx)
 		assert.NoError(t, err)
 		assert.Len(t, users, 2)
 	})
 
-	// Test AuthenticateUser with invalid credentials
-	t.Run("AuthenticateUserInvalid", func(t *testing.T) {
-		_, err := repo.AuthenticateUser("nonexistentuser", "wrongpassword")
-		assert.Error(t, err)
-		assert.Equal(t, "invalid username or password", err.Error())
+	// Test GetUserByUsername with an unknown username
+	mt.Run("GetUserByUsernameNotFound", func(mt *mtest.T) {
+		repo := NewUserRepository(mt.DB, 0)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "testdb.users", mtest.FirstBatch))
+
+		_, err := repo.GetUserByUsername(ctx, "nonexistentuser")
+		assert.ErrorIs(t, err, Domain.ErrUserNotFound)
 	})
 }
@@ -1,39 +1,282 @@
 package Usecases
 
-
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
 	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
 	"TaskManager5/Repositories"
 )
 
+type TaskUsecase interface {
+	GetTasks(ctx context.Context, principal Domain.Principal, filter Domain.TaskFilter) (*Domain.TaskPage, error)
+	GetTask(ctx context.Context, principal Domain.Principal, id string) (*Domain.Task, error)
+	CreateTask(ctx context.Context, principal Domain.Principal, task Domain.Task) (*Domain.Task, error)
+	UpdateTask(ctx context.Context, principal Domain.Principal, id string, version int64, updatedTask Domain.Task) (*Domain.Task, error)
+	PatchTask(ctx context.Context, principal Domain.Principal, id string, version int64, contentType string, patch []byte) (*Domain.Task, error)
+	DeleteTask(ctx context.Context, principal Domain.Principal, id string, version int64) error
+}
+
 type TaskService struct {
-	repo Repositories.TaskRepository
+	repo  Repositories.TaskRepository
+	users Repositories.UserRepository
+}
+
+func NewTaskService(repo Repositories.TaskRepository, users Repositories.UserRepository) *TaskService {
+	return &TaskService{repo: repo, users: users}
+}
+
+// readScope returns the users whose tasks the principal may read, or nil
+// when it may read everybody's.
+func (ts *TaskService) readScope(ctx context.Context, principal Domain.Principal) ([]string, error) {
+	switch {
+	case principal.Can(Domain.PermTasksReadAny):
+		return nil, nil
+	case principal.Can(Domain.PermTasksReadTeam):
+		return ts.teamOf(ctx, principal)
+	case principal.Can(Domain.PermTasksReadOwn):
+		return []string{principal.UserID.Hex()}, nil
+	}
+	return nil, fmt.Errorf("%w: role %q can't read tasks", Domain.ErrForbidden, principal.Role)
+}
+
+// teamOf returns the principal and everybody else in its team. Somebody
+// without a team only has themselves.
+func (ts *TaskService) teamOf(ctx context.Context, principal Domain.Principal) ([]string, error) {
+	owners := []string{principal.UserID.Hex()}
+	user, err := ts.users.GetUserByID(ctx, principal.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if user.Team == "" {
+		return owners, nil
+	}
+	members, err := ts.users.GetUsersByTeam(ctx, user.Team)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.ID != principal.UserID {
+			owners = append(owners, member.ID.Hex())
+		}
+	}
+	return owners, nil
+}
+
+// writeScope returns the owner id that task writes must be restricted to,
+// or "" when the principal may change every user's tasks.
+func (ts *TaskService) writeScope(ctx context.Context, principal Domain.Principal) (string, error) {
+	switch {
+	case principal.Can(Domain.PermTasksWriteAny):
+		return "", nil
+	case principal.Can(Domain.PermTasksWriteOwn):
+		return principal.UserID.Hex(), nil
+	}
+	return "", fmt.Errorf("%w: role %q can't change tasks", Domain.ErrForbidden, principal.Role)
+}
+
+// GetTasks lists the tasks visible to the principal. Callers who can only
+// read their own tasks only ever see those, whatever owner the filter asks
+// for; team readers can narrow the listing to one member of the team.
+func (ts *TaskService) GetTasks(ctx context.Context, principal Domain.Principal, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	scope, err := ts.readScope(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(scope) == 1:
+		filter.UserID = scope[0]
+	case scope != nil:
+		filter.Owners = scope
+	}
+	return ts.repo.GetTasks(ctx, filter.WithDefaults())
 }
 
-func NewTaskService(repo Repositories.TaskRepository) *TaskService {
-	return &TaskService{repo: repo}
+// GetTask returns the task if the principal may read it. Tasks outside the
+// principal's scope are reported as not found.
+func (ts *TaskService) GetTask(ctx context.Context, principal Domain.Principal, id string) (*Domain.Task, error) {
+	scope, err := ts.readScope(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope) == 1 {
+		return ts.repo.GetTask(ctx, id, scope[0])
+	}
+	task, err := ts.repo.GetTask(ctx, id, "")
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil && !contains(scope, task.UserID.Hex()) {
+		return nil, Domain.ErrTaskNotFound
+	}
+	return task, nil
 }
 
-func (ts *TaskService) GetTasks() ([]Domain.Task, error) {
-	return ts.repo.GetTasks()
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
 }
 
-func (ts *TaskService) GetTask(id string) (*Domain.Task, error) {
-	return ts.repo.GetTask(id)
+// CreateTask always assigns the new task to the principal. A task without a
+// status starts as todo, and the initial status opens its history.
+func (ts *TaskService) CreateTask(ctx context.Context, principal Domain.Principal, task Domain.Task) (*Domain.Task, error) {
+	if _, err := ts.writeScope(ctx, principal); err != nil {
+		return nil, err
+	}
+	status := Domain.StatusTodo
+	if task.Status != "" {
+		parsed, err := Domain.ParseTaskStatus(string(task.Status))
+		if err != nil {
+			return nil, err
+		}
+		status = parsed
+	}
+	task.UserID = principal.UserID
+	task.Status = status
+	task.StatusHistory = []Domain.StatusChange{{To: status, Actor: principal.UserID, At: time.Now()}}
+	return ts.repo.CreateTask(ctx, task)
 }
 
-func (ts *TaskService) CreateTask(task Domain.Task) (*Domain.Task, error) {
-	return ts.repo.CreateTask(task)
+// readForWrite fetches the task about to be written and checks it is at the
+// version the caller expects; version 0 means the caller didn't ask. It
+// also returns the owner scope the write must be made under. A task the
+// principal can see but not change is forbidden rather than not found.
+func (ts *TaskService) readForWrite(ctx context.Context, principal Domain.Principal, id string, version int64) (*Domain.Task, string, error) {
+	owner, err := ts.writeScope(ctx, principal)
+	if err != nil {
+		return nil, "", err
+	}
+	existing, err := ts.repo.GetTask(ctx, id, owner)
+	if errors.Is(err, Domain.ErrTaskNotFound) && owner != "" {
+		if _, readErr := ts.GetTask(ctx, principal, id); readErr == nil {
+			return nil, "", fmt.Errorf("%w: task belongs to another user", Domain.ErrForbidden)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if version != 0 && existing.Version != version {
+		return nil, "", Domain.ErrVersionMismatch
+	}
+	return existing, owner, nil
 }
 
-func (ts *TaskService) UpdateTask(id string, updatedTask Domain.Task) (*Domain.Task, error) {
-	return ts.repo.UpdateTask(id, updatedTask)
+// UpdateTask keeps the task's current owner; ownership can't be changed
+// through an update. A status change must be allowed by the task state
+// machine and is appended to the task's history. An empty status leaves the
+// status as it is.
+//
+// The write is conditional on the version that was read, so an update that
+// races with another one fails with Domain.ErrVersionMismatch instead of
+// overwriting it.
+func (ts *TaskService) UpdateTask(ctx context.Context, principal Domain.Principal, id string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	existing, owner, err := ts.readForWrite(ctx, principal, id, version)
+	if err != nil {
+		return nil, err
+	}
+	if updatedTask.Status != "" {
+		next, err := Domain.ParseTaskStatus(string(updatedTask.Status))
+		if err != nil {
+			return nil, err
+		}
+		if err := existing.TransitionTo(next, principal.UserID, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	updatedTask.UserID = existing.UserID
+	updatedTask.Status = existing.Status
+	updatedTask.StatusHistory = existing.StatusHistory
+	return ts.repo.UpdateTask(ctx, id, owner, existing.Version, updatedTask)
 }
 
-func (ts *TaskService) DeleteTask(id string) error {
-	return ts.repo.DeleteTask(id)
+// patchableTask is the view of a task that PATCH documents are applied to.
+// Fields outside it, such as the owner and timestamps, can't be patched.
+type patchableTask struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	DueDate     time.Time `json:"due_date"`
+	Status      string    `json:"status"`
+}
+
+// PatchTask applies a merge patch or JSON Patch, chosen by contentType, to
+// the task and persists only the fields that changed. The patched task is
+// validated, including its status transition, before anything is written.
+// Like UpdateTask, the write is conditional on the version that was read.
+func (ts *TaskService) PatchTask(ctx context.Context, principal Domain.Principal, id string, version int64, contentType string, patch []byte) (*Domain.Task, error) {
+	existing, owner, err := ts.readForWrite(ctx, principal, id, version)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.Marshal(patchableTask{
+		Title:       existing.Title,
+		Description: existing.Description,
+		DueDate:     existing.DueDate,
+		Status:      string(existing.Status),
+	})
+	if err != nil {
+		return nil, err
+	}
+	patchedDoc, err := Infrastructure.ApplyPatch(contentType, doc, patch)
+	if err != nil {
+		return nil, err
+	}
+	var patched patchableTask
+	decoder := json.NewDecoder(bytes.NewReader(patchedDoc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, fmt.Errorf("%w: %v", Domain.ErrInvalidPatch, err)
+	}
+
+	// The same rules as for POST and PUT, applied to the merged result.
+	if err := Domain.ValidateTask(Domain.Task{Title: patched.Title, Description: patched.Description, DueDate: patched.DueDate}); err != nil {
+		return nil, err
+	}
+
+	var changes Domain.TaskPatch
+	if patched.Title != existing.Title {
+		changes.Title = &patched.Title
+	}
+	if patched.Description != existing.Description {
+		changes.Description = &patched.Description
+	}
+	if !patched.DueDate.Equal(existing.DueDate) {
+		changes.DueDate = &patched.DueDate
+	}
+	if patched.Status != string(existing.Status) {
+		next, err := Domain.ParseTaskStatus(patched.Status)
+		if err != nil {
+			return nil, err
+		}
+		history := len(existing.StatusHistory)
+		if err := existing.TransitionTo(next, principal.UserID, time.Now()); err != nil {
+			return nil, err
+		}
+		if len(existing.StatusHistory) > history {
+			changes.Status = &existing.Status
+			changes.StatusChange = &existing.StatusHistory[history]
+		}
+	}
+	if changes.IsEmpty() {
+		return existing, nil
+	}
+	return ts.repo.PatchTask(ctx, id, owner, existing.Version, changes)
 }
 
-func (ts *TaskService) GetTasksByUserID(userID string) ([]Domain.Task, error) {
-	return ts.repo.GetTasksByUserID(userID)
+// DeleteTask checks the task like UpdateTask and PatchTask do, so a task
+// the caller can see but not change is forbidden rather than not found.
+func (ts *TaskService) DeleteTask(ctx context.Context, principal Domain.Principal, id string, version int64) error {
+	existing, owner, err := ts.readForWrite(ctx, principal, id, version)
+	if err != nil {
+		return err
+	}
+	return ts.repo.DeleteTask(ctx, id, owner, existing.Version)
 }
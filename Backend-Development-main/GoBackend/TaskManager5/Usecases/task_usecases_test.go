@@ -1,11 +1,15 @@
 package Usecases
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"TaskManager5/Domain"
+	"TaskManager5/Repositories"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -14,40 +18,67 @@ type MockTaskRepository struct {
 	mock.Mock
 }
 
-func (m *MockTaskRepository) GetTasks() ([]Domain.Task, error) {
-	args := m.Called()
-	return args.Get(0).([]Domain.Task), args.Error(1)
+func (m *MockTaskRepository) GetTasks(ctx context.Context, filter Domain.TaskFilter) (*Domain.TaskPage, error) {
+	args := m.Called(filter)
+	return args.Get(0).(*Domain.TaskPage), args.Error(1)
 }
 
-func (m *MockTaskRepository) GetTask(id string) (*Domain.Task, error) {
-	args := m.Called(id)
-	return args.Get(0).(*Domain.Task), args.Error(1)
+func (m *MockTaskRepository) GetTask(ctx context.Context, id, ownerID string) (*Domain.Task, error) {
+	args := m.Called(id, ownerID)
+	task, _ := args.Get(0).(*Domain.Task)
+	return task, args.Error(1)
 }
 
-func (m *MockTaskRepository) CreateTask(task Domain.Task) (*Domain.Task, error) {
+func (m *MockTaskRepository) CreateTask(ctx context.Context, task Domain.Task) (*Domain.Task, error) {
 	args := m.Called(task)
 	return args.Get(0).(*Domain.Task), args.Error(1)
 }
 
-func (m *MockTaskRepository) UpdateTask(id string, updatedTask Domain.Task) (*Domain.Task, error) {
-	args := m.Called(id, updatedTask)
+func (m *MockTaskRepository) UpdateTask(ctx context.Context, id, ownerID string, version int64, updatedTask Domain.Task) (*Domain.Task, error) {
+	args := m.Called(id, ownerID, version, updatedTask)
 	return args.Get(0).(*Domain.Task), args.Error(1)
 }
 
-func (m *MockTaskRepository) DeleteTask(id string) error {
-	args := m.Called(id)
+func (m *MockTaskRepository) PatchTask(ctx context.Context, id, ownerID string, version int64, patch Domain.TaskPatch) (*Domain.Task, error) {
+	args := m.Called(id, ownerID, version, patch)
+	task, _ := args.Get(0).(*Domain.Task)
+	return task, args.Error(1)
+}
+
+func (m *MockTaskRepository) DeleteTask(ctx context.Context, id, ownerID string, version int64) error {
+	args := m.Called(id, ownerID, version)
 	return args.Error(0)
 }
 
-func (m *MockTaskRepository) GetTasksByUserID(userID string) ([]Domain.Task, error) {
+var (
+	admin = Domain.Principal{UserID: primitive.NewObjectID(), Username: "admin", Role: Domain.RoleAdmin, Permissions: defaultPermissions(Domain.RoleAdmin)}
+	owner = Domain.Principal{UserID: primitive.NewObjectID(), Username: "owner", Role: Domain.RoleUser, Permissions: defaultPermissions(Domain.RoleUser)}
+)
+
+func defaultPermissions(role string) []Domain.Permission {
+	for _, r := range Domain.DefaultRoles() {
+		if r.Name == role {
+			return r.Permissions
+		}
+	}
+	return nil
+}
+
+func (m *MockTaskRepository) DeleteTasksByUserID(ctx context.Context, userID string) (int64, error) {
 	args := m.Called(userID)
-	return args.Get(0).([]Domain.Task), args.Error(1)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTaskRepository) ReassignTasks(ctx context.Context, fromUserID, toUserID string) (int64, error) {
+	args := m.Called(fromUserID, toUserID)
+	return args.Get(0).(int64), args.Error(1)
 }
 
 // Test for GetTasks
 func TestGetTasks(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
 
 	tasks := []Domain.Task{
 		{
@@ -55,7 +86,7 @@ func TestGetTasks(t *testing.T) {
 			Title:       "Task 1",
 			Description: "Description 1",
 			DueDate:     time.Now(),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
@@ -65,39 +96,65 @@ func TestGetTasks(t *testing.T) {
 			Title:       "Task 2",
 			Description: "Description 2",
 			DueDate:     time.Now(),
-			Status:      "Completed",
+			Status:      Domain.StatusDone,
 			UserID:      primitive.NewObjectID(),
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
 		},
 	}
-	mockRepo.On("GetTasks").Return(tasks, nil)
+	page := &Domain.TaskPage{Tasks: tasks, Total: int64(len(tasks))}
+	expectedFilter := Domain.TaskFilter{
+		Status: Domain.StatusTodo,
+		SortBy: Domain.DefaultTaskSort,
+		Limit:  Domain.DefaultTaskPageSize,
+	}
+	mockRepo.On("GetTasks", expectedFilter).Return(page, nil)
 
-	result, err := service.GetTasks()
+	result, err := service.GetTasks(ctx, admin, Domain.TaskFilter{Status: Domain.StatusTodo})
+
+	assert.NoError(t, err)
+	assert.Equal(t, page, result)
+	mockRepo.AssertExpectations(t)
+}
+
+// Test that GetTasks caps the page size
+func TestGetTasksCapsLimit(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	expectedFilter := Domain.TaskFilter{
+		SortBy:   "due_date",
+		SortDesc: true,
+		Limit:    Domain.MaxTaskPageSize,
+	}
+	mockRepo.On("GetTasks", expectedFilter).Return(&Domain.TaskPage{}, nil)
+
+	_, err := service.GetTasks(ctx, admin, Domain.TaskFilter{SortBy: "due_date", SortDesc: true, Limit: 10000})
 
 	assert.NoError(t, err)
-	assert.Equal(t, tasks, result)
 	mockRepo.AssertExpectations(t)
 }
 
 // Test for GetTask
 func TestGetTask(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
 
 	task := &Domain.Task{
 		ID:          primitive.NewObjectID(),
 		Title:       "Task 1",
 		Description: "Description 1",
 		DueDate:     time.Now(),
-		Status:      "Pending",
+		Status:      Domain.StatusTodo,
 		UserID:      primitive.NewObjectID(),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-	mockRepo.On("GetTask", task.ID.Hex()).Return(task, nil)
+	mockRepo.On("GetTask", task.ID.Hex(), owner.UserID.Hex()).Return(task, nil)
 
-	result, err := service.GetTask(task.ID.Hex())
+	result, err := service.GetTask(ctx, owner, task.ID.Hex())
 
 	assert.NoError(t, err)
 	assert.Equal(t, task, result)
@@ -106,70 +163,320 @@ func TestGetTask(t *testing.T) {
 
 // Test for CreateTask
 func TestCreateTask(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
 
 	task := Domain.Task{
 		ID:          primitive.NewObjectID(),
 		Title:       "New Task",
 		Description: "New Task Description",
 		DueDate:     time.Now(),
-		Status:      "Pending",
-		UserID:      primitive.NewObjectID(),
+		UserID:      owner.UserID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-	mockRepo.On("CreateTask", task).Return(&task, nil)
-
-	result, err := service.CreateTask(task)
+	mockRepo.On("CreateTask", mock.MatchedBy(func(created Domain.Task) bool {
+		return created.UserID == owner.UserID &&
+			created.Status == Domain.StatusTodo &&
+			len(created.StatusHistory) == 1 &&
+			created.StatusHistory[0].To == Domain.StatusTodo &&
+			created.StatusHistory[0].Actor == owner.UserID
+	})).Return(&task, nil)
+
+	submitted := task
+	submitted.UserID = primitive.NewObjectID()
+	result, err := service.CreateTask(ctx, owner, submitted)
 
 	assert.NoError(t, err)
 	assert.Equal(t, &task, result)
 	mockRepo.AssertExpectations(t)
 }
 
+// Test that CreateTask rejects unknown statuses
+func TestCreateTaskInvalidStatus(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	_, err := service.CreateTask(ctx, owner, Domain.Task{Title: "New Task", Status: "someday"})
+
+	assert.ErrorIs(t, err, Domain.ErrInvalidStatus)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
 // Test for UpdateTask
 func TestUpdateTask(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
 
-	updatedTask := Domain.Task{
+	existing := Domain.Task{
 		ID:          primitive.NewObjectID(),
-		Title:       "Updated Task",
-		Description: "Updated Description",
+		Title:       "Task",
+		Description: "Description",
 		DueDate:     time.Now(),
-		Status:      "In Progress",
+		Status:      Domain.StatusTodo,
 		UserID:      primitive.NewObjectID(),
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		Version:     3,
 	}
-	mockRepo.On("UpdateTask", updatedTask.ID.Hex(), updatedTask).Return(&updatedTask, nil)
-
-	result, err := service.UpdateTask(updatedTask.ID.Hex(), updatedTask)
+	updatedTask := existing
+	updatedTask.Title = "Updated Task"
+	updatedTask.Description = "Updated Description"
+	updatedTask.Status = Domain.StatusInProgress
+	mockRepo.On("GetTask", existing.ID.Hex(), "").Return(&existing, nil)
+	mockRepo.On("UpdateTask", existing.ID.Hex(), "", int64(3), mock.MatchedBy(func(task Domain.Task) bool {
+		return task.Title == "Updated Task" &&
+			task.UserID == existing.UserID &&
+			task.Status == Domain.StatusInProgress &&
+			len(task.StatusHistory) == 1 &&
+			task.StatusHistory[0].From == Domain.StatusTodo &&
+			task.StatusHistory[0].To == Domain.StatusInProgress &&
+			task.StatusHistory[0].Actor == admin.UserID
+	})).Return(&updatedTask, nil)
+
+	submitted := updatedTask
+	submitted.UserID = primitive.NilObjectID
+	submitted.Status = "In Progress"
+	result, err := service.UpdateTask(ctx, admin, existing.ID.Hex(), 0, submitted)
 
 	assert.NoError(t, err)
 	assert.Equal(t, &updatedTask, result)
 	mockRepo.AssertExpectations(t)
 }
 
+// Test that UpdateTask rejects transitions the state machine doesn't allow
+func TestUpdateTaskInvalidTransition(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	existing := &Domain.Task{ID: primitive.NewObjectID(), Status: Domain.StatusArchived, UserID: owner.UserID}
+	mockRepo.On("GetTask", existing.ID.Hex(), owner.UserID.Hex()).Return(existing, nil)
+
+	_, err := service.UpdateTask(ctx, owner, existing.ID.Hex(), 0, Domain.Task{Title: "Revived", Status: Domain.StatusTodo})
+
+	var transitionErr *Domain.TransitionError
+	assert.ErrorAs(t, err, &transitionErr)
+	assert.ErrorIs(t, err, Domain.ErrInvalidTransition)
+	assert.Equal(t, Domain.StatusArchived, transitionErr.From)
+	mockRepo.AssertNotCalled(t, "UpdateTask", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test that a merge patch only writes the fields it changes
+func TestPatchTaskMergePatch(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	existing := &Domain.Task{
+		ID:          primitive.NewObjectID(),
+		Title:       "Task",
+		Description: "Keep me",
+		Status:      Domain.StatusTodo,
+		UserID:      owner.UserID,
+	}
+	description := "Changed"
+	mockRepo.On("GetTask", existing.ID.Hex(), owner.UserID.Hex()).Return(existing, nil)
+	mockRepo.On("PatchTask", existing.ID.Hex(), owner.UserID.Hex(), int64(0), Domain.TaskPatch{Description: &description}).
+		Return(&Domain.Task{ID: existing.ID, Title: "Task", Description: description}, nil)
+
+	result, err := service.PatchTask(ctx, owner, existing.ID.Hex(), 0, "application/merge-patch+json", []byte(`{"description":"Changed"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Task", result.Title)
+	mockRepo.AssertExpectations(t)
+}
+
+// Test that a JSON Patch status change goes through the state machine
+func TestPatchTaskJSONPatchStatus(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	existing := &Domain.Task{ID: primitive.NewObjectID(), Title: "Task", Status: Domain.StatusTodo, UserID: owner.UserID}
+	mockRepo.On("GetTask", existing.ID.Hex(), owner.UserID.Hex()).Return(existing, nil)
+	mockRepo.On("PatchTask", existing.ID.Hex(), owner.UserID.Hex(), int64(0), mock.MatchedBy(func(patch Domain.TaskPatch) bool {
+		return patch.Title == nil && patch.Description == nil &&
+			patch.Status != nil && *patch.Status == Domain.StatusBlocked &&
+			patch.StatusChange != nil && patch.StatusChange.From == Domain.StatusTodo &&
+			patch.StatusChange.Actor == owner.UserID
+	})).Return(existing, nil)
+
+	_, err := service.PatchTask(ctx, owner, existing.ID.Hex(), 0, "application/json-patch+json",
+		[]byte(`[{"op":"test","path":"/status","value":"todo"},{"op":"replace","path":"/status","value":"blocked"}]`))
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+// Test that invalid patches are rejected before anything is written
+func TestPatchTaskRejected(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name        string
+		contentType string
+		patch       string
+		err         error
+	}{
+		{"read-only field", "application/merge-patch+json", `{"user_id":"000000000000000000000000"}`, Domain.ErrInvalidPatch},
+		{"empty title", "application/merge-patch+json", `{"title":null}`, Domain.ErrValidation},
+		{"long title", "application/merge-patch+json", `{"title":"` + strings.Repeat("x", 201) + `"}`, Domain.ErrValidation},
+		{"long description", "application/merge-patch+json", `{"description":"` + strings.Repeat("x", 5001) + `"}`, Domain.ErrValidation},
+		{"due date", "application/merge-patch+json", `{"due_date":"2150-01-01T00:00:00Z"}`, Domain.ErrValidation},
+		{"bad status", "application/merge-patch+json", `{"status":"someday"}`, Domain.ErrInvalidStatus},
+		{"transition", "application/merge-patch+json", `{"status":"done"}`, Domain.ErrInvalidTransition},
+		{"failed test", "application/json-patch+json", `[{"op":"test","path":"/title","value":"Other"}]`, Domain.ErrPatchTestFailed},
+		{"content type", "text/plain", `title=x`, Domain.ErrUnsupportedPatch},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockTaskRepository)
+			service := NewTaskService(mockRepo, nil)
+
+			existing := &Domain.Task{ID: primitive.NewObjectID(), Title: "Task", Status: Domain.StatusBlocked, UserID: owner.UserID}
+			mockRepo.On("GetTask", existing.ID.Hex(), owner.UserID.Hex()).Return(existing, nil)
+
+			_, err := service.PatchTask(ctx, owner, existing.ID.Hex(), 0, tc.contentType, []byte(tc.patch))
+
+			assert.ErrorIs(t, err, tc.err)
+			mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+// Test that writes are rejected when If-Match names an older version
+func TestUpdateTaskStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	existing := &Domain.Task{ID: primitive.NewObjectID(), Title: "Task", Status: Domain.StatusTodo, UserID: owner.UserID, Version: 4}
+	mockRepo.On("GetTask", existing.ID.Hex(), owner.UserID.Hex()).Return(existing, nil)
+
+	_, err := service.UpdateTask(ctx, owner, existing.ID.Hex(), 3, Domain.Task{Title: "Late"})
+	assert.ErrorIs(t, err, Domain.ErrVersionMismatch)
+
+	_, err = service.PatchTask(ctx, owner, existing.ID.Hex(), 3, "application/merge-patch+json", []byte(`{"title":"Late"}`))
+	assert.ErrorIs(t, err, Domain.ErrVersionMismatch)
+
+	mockRepo.AssertNotCalled(t, "UpdateTask", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "PatchTask", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
 // Test for DeleteTask
 func TestDeleteTask(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
 
 	taskID := primitive.NewObjectID().Hex()
-	mockRepo.On("DeleteTask", taskID).Return(nil)
+	mockRepo.On("GetTask", taskID, owner.UserID.Hex()).Return(&Domain.Task{Title: "Task", UserID: owner.UserID, Version: 2}, nil)
+	mockRepo.On("DeleteTask", taskID, owner.UserID.Hex(), int64(2)).Return(nil)
 
-	err := service.DeleteTask(taskID)
+	err := service.DeleteTask(ctx, owner, taskID, 2)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
 }
 
-// Test for GetTasksByUserID
-func TestGetTasksByUserID(t *testing.T) {
+// Test that non-admins can only list their own tasks
+func TestGetTasksScopedToOwner(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockTaskRepository)
-	service := NewTaskService(mockRepo)
+	service := NewTaskService(mockRepo, nil)
+
+	expectedFilter := Domain.TaskFilter{
+		UserID: owner.UserID.Hex(),
+		SortBy: Domain.DefaultTaskSort,
+		Limit:  Domain.DefaultTaskPageSize,
+	}
+	mockRepo.On("GetTasks", expectedFilter).Return(&Domain.TaskPage{}, nil)
+
+	_, err := service.GetTasks(ctx, owner, Domain.TaskFilter{UserID: admin.UserID.Hex()})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+// Test that non-admins can't update someone else's task
+func TestUpdateTaskOtherOwner(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
+
+	taskID := primitive.NewObjectID().Hex()
+	mockRepo.On("GetTask", taskID, owner.UserID.Hex()).Return(nil, Domain.ErrTaskNotFound)
+
+	_, err := service.UpdateTask(ctx, owner, taskID, 0, Domain.Task{Title: "Hijacked"})
+
+	assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+	mockRepo.AssertNotCalled(t, "UpdateTask", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// Test that managers read their team's tasks but only change their own
+func TestManagerTeamScope(t *testing.T) {
+	ctx := context.Background()
+	tasks := Repositories.NewInMemoryTaskRepository()
+	users := Repositories.NewInMemoryUserRepository()
+	service := NewTaskService(tasks, users)
+
+	ops := "ops"
+	newUser := func(name string, team *string) Domain.Principal {
+		user, err := users.CreateUser(ctx, Domain.User{Username: name, Password: "x"})
+		require.NoError(t, err)
+		if team != nil {
+			_, err = users.UpdateUser(ctx, user.ID.Hex(), Domain.UserUpdate{Team: team})
+			require.NoError(t, err)
+		}
+		return Domain.Principal{UserID: user.ID, Username: name, Role: Domain.RoleUser, Permissions: defaultPermissions(Domain.RoleUser)}
+	}
+	manager := newUser("manager", &ops)
+	manager.Role, manager.Permissions = Domain.RoleManager, defaultPermissions(Domain.RoleManager)
+	member := newUser("member", &ops)
+	outsider := newUser("outsider", nil)
+
+	memberTask, err := service.CreateTask(ctx, member, Domain.Task{Title: "Member"})
+	require.NoError(t, err)
+	outsiderTask, err := service.CreateTask(ctx, outsider, Domain.Task{Title: "Outsider"})
+	require.NoError(t, err)
+	_, err = service.CreateTask(ctx, manager, Domain.Task{Title: "Manager"})
+	require.NoError(t, err)
+
+	page, err := service.GetTasks(ctx, manager, Domain.TaskFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), page.Total)
+
+	page, err = service.GetTasks(ctx, manager, Domain.TaskFilter{UserID: outsider.UserID.Hex()})
+	require.NoError(t, err)
+	assert.Zero(t, page.Total)
+
+	_, err = service.GetTask(ctx, manager, memberTask.ID.Hex())
+	assert.NoError(t, err)
+	_, err = service.GetTask(ctx, manager, outsiderTask.ID.Hex())
+	assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+
+	_, err = service.UpdateTask(ctx, manager, memberTask.ID.Hex(), 0, Domain.Task{Title: "Taken over"})
+	assert.ErrorIs(t, err, Domain.ErrForbidden)
+	_, err = service.UpdateTask(ctx, manager, outsiderTask.ID.Hex(), 0, Domain.Task{Title: "Taken over"})
+	assert.ErrorIs(t, err, Domain.ErrTaskNotFound)
+	assert.ErrorIs(t, service.DeleteTask(ctx, manager, memberTask.ID.Hex(), 0), Domain.ErrForbidden)
+	assert.ErrorIs(t, service.DeleteTask(ctx, manager, outsiderTask.ID.Hex(), 0), Domain.ErrTaskNotFound)
+
+	// Without permissions nothing can be read or written.
+	_, err = service.GetTasks(ctx, Domain.Principal{UserID: member.UserID}, Domain.TaskFilter{})
+	assert.ErrorIs(t, err, Domain.ErrForbidden)
+	_, err = service.CreateTask(ctx, Domain.Principal{UserID: member.UserID}, Domain.Task{Title: "Nope"})
+	assert.ErrorIs(t, err, Domain.ErrForbidden)
+}
+
+// Test that an admin listing one user's tasks keeps the requested owner
+func TestGetTasksForUser(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockTaskRepository)
+	service := NewTaskService(mockRepo, nil)
 
 	tasks := []Domain.Task{
 		{
@@ -177,18 +484,25 @@ func TestGetTasksByUserID(t *testing.T) {
 			Title:       "Task 1",
 			Description: "Description 1",
 			DueDate:     time.Now(),
-			Status:      "Pending",
+			Status:      Domain.StatusTodo,
 			UserID:      primitive.NewObjectID(),
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
 		},
 	}
-	mockRepo.On("GetTasksByUserID", "user1").Return(tasks, nil)
+	page := &Domain.TaskPage{Tasks: tasks, Total: int64(len(tasks))}
+	userID := tasks[0].UserID.Hex()
+	expectedFilter := Domain.TaskFilter{
+		UserID: userID,
+		SortBy: Domain.DefaultTaskSort,
+		Limit:  Domain.DefaultTaskPageSize,
+	}
+	mockRepo.On("GetTasks", expectedFilter).Return(page, nil)
 
-	result, err := service.GetTasksByUserID("user1")
+	result, err := service.GetTasks(ctx, admin, Domain.TaskFilter{UserID: userID})
 
 	assert.NoError(t, err)
-	assert.Equal(t, tasks, result)
+	assert.Equal(t, page, result)
 	mockRepo.AssertExpectations(t)
 }
 
@@ -1,31 +1,979 @@
 package Usecases
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
 	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
 	"TaskManager5/Repositories"
 )
 
+type UserUsecase interface {
+	RegisterUser(ctx context.Context, user Domain.User) (*Domain.User, error)
+	AuthenticateUser(ctx context.Context, username, password, clientIP string) (*Domain.TokenPair, *Domain.MFAChallenge, error)
+	CompleteMFALogin(ctx context.Context, mfaToken, code, clientIP string) (*Domain.TokenPair, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*Domain.TokenPair, error)
+	Logout(ctx context.Context, principal Domain.Principal) error
+	GetUserByID(ctx context.Context, userID string) (*Domain.User, error)
+	GetAllUsers(ctx context.Context) ([]Domain.User, error)
+
+	GetProfile(ctx context.Context, principal Domain.Principal) (*Domain.User, error)
+	UpdateProfile(ctx context.Context, principal Domain.Principal, username string) (*Domain.User, error)
+	ChangePassword(ctx context.Context, principal Domain.Principal, currentPassword, newPassword, clientIP string) (*Domain.TokenPair, error)
+	DeleteAccount(ctx context.Context, principal Domain.Principal) error
+
+	BeginTOTPEnrollment(ctx context.Context, principal Domain.Principal) (*Domain.TOTPEnrollment, error)
+	ConfirmTOTPEnrollment(ctx context.Context, principal Domain.Principal, code string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, principal Domain.Principal, code string) ([]string, error)
+	DisableMFA(ctx context.Context, principal Domain.Principal, code string) error
+
+	SetUserRole(ctx context.Context, userID, role string) (*Domain.User, error)
+	SetUserTeam(ctx context.Context, userID, team string) (*Domain.User, error)
+	SetUserDisabled(ctx context.Context, userID string, disabled bool) (*Domain.User, error)
+	DeleteUser(ctx context.Context, userID string, deletion Domain.UserDeletion) error
+	ListLoginAttempts(ctx context.Context) ([]Domain.LoginAttempts, error)
+	ClearLoginAttempts(ctx context.Context, key string) error
+	ResetMFA(ctx context.Context, userID string) (*Domain.User, error)
+
+	CreateAPIKey(ctx context.Context, principal Domain.Principal, name string, scopes []Domain.Permission, expiresAt *time.Time) (*Domain.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, principal Domain.Principal) ([]Domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, principal Domain.Principal, id string) error
+
+	ListRoles(ctx context.Context) ([]Domain.Role, error)
+	SaveRole(ctx context.Context, role Domain.Role) (*Domain.Role, error)
+	SetRoleMFA(ctx context.Context, name string, required bool) (*Domain.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+}
+
+// UserService manages accounts, sessions and API keys. New passwords must
+// satisfy PasswordPolicy and are hashed by Passwords. Failed logins are
+// throttled per username with UserLockout and per client address with
+// ClientLockout, and so are wrong second-factor codes. API keys expire after
+// APIKeyTTL unless their creator picks another expiry, which can be at most
+// MaxAPIKeyTTL away. TOTP checks the codes of users with MFA. The outcome
+// of every login is reported to Metrics.
 type UserService struct {
-	repo      Repositories.UserRepository
-	secretKey string
+	repo           Repositories.UserRepository
+	taskRepo       Repositories.TaskRepository
+	tokenRepo      Repositories.TokenRepository
+	attempts       Repositories.LoginAttemptRepository
+	roles          Repositories.RoleRepository
+	apiKeys        Repositories.APIKeyRepository
+	tokens         *Infrastructure.TokenService
+	Passwords      *Infrastructure.PasswordService
+	PasswordPolicy Domain.PasswordPolicy
+	UserLockout    Domain.LockoutPolicy
+	ClientLockout  Domain.LockoutPolicy
+	APIKeyTTL      time.Duration
+	MaxAPIKeyTTL   time.Duration
+	TOTP           Infrastructure.TOTP
+	Metrics        *Infrastructure.Metrics
+}
+
+func NewUserService(repo Repositories.UserRepository, taskRepo Repositories.TaskRepository, tokenRepo Repositories.TokenRepository, attempts Repositories.LoginAttemptRepository, roles Repositories.RoleRepository, apiKeys Repositories.APIKeyRepository, tokens *Infrastructure.TokenService) *UserService {
+	return &UserService{
+		repo:           repo,
+		taskRepo:       taskRepo,
+		tokenRepo:      tokenRepo,
+		attempts:       attempts,
+		roles:          roles,
+		apiKeys:        apiKeys,
+		tokens:         tokens,
+		Passwords:      Infrastructure.NewPasswordService(Infrastructure.DefaultArgon2idHasher),
+		PasswordPolicy: Domain.DefaultPasswordPolicy,
+		UserLockout:    Domain.DefaultUserLockoutPolicy,
+		ClientLockout:  Domain.DefaultClientLockoutPolicy,
+		APIKeyTTL:      Domain.DefaultAPIKeyTTL,
+		MaxAPIKeyTTL:   Domain.MaxAPIKeyTTL,
+		TOTP:           Infrastructure.DefaultTOTP,
+	}
+}
+
+// RegisterUser creates an ordinary user whatever role was asked for;
+// admins are made with SetUserRole or EnsureAdmin. The username is stored
+// normalized.
+func (us *UserService) RegisterUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
+	user.Username = Domain.NormalizeUsername(user.Username)
+	if err := us.PasswordPolicy.Check("password", user.Username, user.Password); err != nil {
+		return nil, err
+	}
+	var err error
+	if user.Password, err = us.Passwords.Hash(user.Password); err != nil {
+		return nil, err
+	}
+	user.Role = Domain.RoleUser
+	return us.repo.CreateUser(ctx, user)
+}
+
+// EnsureAdmin creates the admin account an installation is configured
+// with, so that it can be administered at all. It does nothing if the admin
+// already exists, which makes it safe to run on every start and from
+// several servers at once. An existing account of that name that is not an
+// admin is left alone and reported, rather than promoted.
+func (us *UserService) EnsureAdmin(ctx context.Context, username, password string) error {
+	username = Domain.NormalizeUsername(username)
+	existing, err := us.repo.GetUserByUsername(ctx, username)
+	switch {
+	case err == nil:
+		if existing.Role != Domain.RoleAdmin {
+			return fmt.Errorf("%w: %q exists and is not an admin", Domain.ErrUsernameTaken, username)
+		}
+		return nil
+	case !errors.Is(err, Domain.ErrUserNotFound):
+		return err
+	}
+	if err := us.PasswordPolicy.Check("password", username, password); err != nil {
+		return err
+	}
+	hash, err := us.Passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = us.repo.CreateUser(ctx, Domain.User{Username: username, Password: hash, Role: Domain.RoleAdmin})
+	if errors.Is(err, Domain.ErrUsernameTaken) {
+		// Another server created it first.
+		return nil
+	}
+	return err
+}
+
+// AuthenticateUser checks the credentials and starts a new session. Unknown
+// usernames, wrong passwords and disabled accounts fail with the same
+// error, so that it never tells whether a password was right. Usernames
+// match regardless of case. While the username or the client address is
+// locked out after failed attempts, it fails with a *Domain.ThrottledError
+// without looking at the password.
+//
+// Users with MFA get a challenge instead of tokens, to be completed with
+// CompleteMFALogin. Their failed attempts are only cleared once that
+// succeeds, so that knowing the password doesn't buy more code guesses.
+func (us *UserService) AuthenticateUser(ctx context.Context, username, password, clientIP string) (pair *Domain.TokenPair, challenge *Domain.MFAChallenge, err error) {
+	defer func() { us.Metrics.ObserveLogin(Infrastructure.LoginStepPassword, challenge != nil, err) }()
+	username = Domain.NormalizeUsername(username)
+	throttles := us.loginThrottles(username, clientIP)
+	if err := us.checkLoginThrottles(ctx, throttles); err != nil {
+		return nil, nil, err
+	}
+
+	user, rehash, err := us.checkCredentials(ctx, username, password)
+	if err == nil && user.Disabled {
+		err = Domain.ErrInvalidCredentials
+	}
+	if errors.Is(err, Domain.ErrInvalidCredentials) {
+		if err := us.recordLoginFailure(ctx, throttles); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.MFA.Enabled {
+		if err := us.clearLoginFailures(ctx, throttles); err != nil {
+			return nil, nil, err
+		}
+	}
+	if rehash {
+		// The password is only ever known here, so this is the one chance
+		// to move it to the preferred algorithm. Failing to is not the
+		// caller's problem.
+		us.rehashPassword(ctx, *user, password)
+	}
+	if user.MFA.Enabled {
+		issued, err := us.tokens.IssueMFAToken(*user)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &Domain.MFAChallenge{
+			MFARequired: true,
+			MFAToken:    issued.Token,
+			ExpiresIn:   int64(us.tokens.MFATTL.Seconds()),
+		}, nil
+	}
+	sessionID, err := Infrastructure.NewTokenID()
+	if err != nil {
+		return nil, nil, err
+	}
+	pair, _, err = us.issueTokens(ctx, *user, sessionID, false)
+	return pair, nil, err
+}
+
+// CompleteMFALogin finishes the login a challenge was issued for, given a
+// TOTP code or a recovery code. Wrong codes count as failed logins.
+func (us *UserService) CompleteMFALogin(ctx context.Context, mfaToken, code, clientIP string) (pair *Domain.TokenPair, err error) {
+	defer func() { us.Metrics.ObserveLogin(Infrastructure.LoginStepMFA, false, err) }()
+	claims, err := us.tokens.Parse(mfaToken, Infrastructure.MFATokenType)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.repo.GetUserByID(ctx, claims.Subject)
+	if err != nil {
+		if errors.Is(err, Domain.ErrUserNotFound) {
+			return nil, Domain.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, Domain.ErrAccountDisabled
+	}
+	if !user.MFA.Enabled {
+		// MFA was reset since the challenge; a plain login will do.
+		return nil, Domain.ErrInvalidToken
+	}
+	throttles := us.loginThrottles(user.Username, clientIP)
+	if err := us.verifyMFACode(ctx, user, code, throttles); err != nil {
+		return nil, err
+	}
+	if err := us.clearLoginFailures(ctx, throttles); err != nil {
+		return nil, err
+	}
+	sessionID, err := Infrastructure.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	pair, _, err = us.issueTokens(ctx, *user, sessionID, true)
+	return pair, err
+}
+
+type loginThrottle struct {
+	key    string
+	policy Domain.LockoutPolicy
+}
+
+// loginThrottles returns the throttles of the username and, when known, of
+// the client address.
+func (us *UserService) loginThrottles(username, clientIP string) []loginThrottle {
+	throttles := []loginThrottle{{Domain.UsernameAttemptKey(username), us.UserLockout}}
+	if clientIP != "" {
+		throttles = append(throttles, loginThrottle{Domain.ClientAttemptKey(clientIP), us.ClientLockout})
+	}
+	return throttles
+}
+
+func (us *UserService) recordLoginFailure(ctx context.Context, throttles []loginThrottle) error {
+	now := time.Now()
+	for _, throttle := range throttles {
+		if _, err := us.attempts.RecordLoginFailure(ctx, throttle.key, now, throttle.policy); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func NewUserService(repo Repositories.UserRepository, secretKey string) *UserService {
-	return &UserService{repo: repo, secretKey: secretKey}
+// clearLoginFailures only clears the username: a client guessing at many
+// accounts must not reset its own counter by logging into one it controls.
+func (us *UserService) clearLoginFailures(ctx context.Context, throttles []loginThrottle) error {
+	return us.attempts.ClearLoginAttempts(ctx, throttles[0].key)
+}
+
+// checkLoginThrottles fails with the longest lock among the keys.
+func (us *UserService) checkLoginThrottles(ctx context.Context, throttles []loginThrottle) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, throttle := range throttles {
+		attempts, err := us.attempts.GetLoginAttempts(ctx, throttle.key)
+		if err != nil {
+			return err
+		}
+		if retryAfter := attempts.RetryAfter(now); retryAfter > wait {
+			wait = retryAfter
+		}
+	}
+	if wait > 0 {
+		return &Domain.ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// checkCredentials also reports whether the stored hash should be replaced.
+func (us *UserService) checkCredentials(ctx context.Context, username, password string) (*Domain.User, bool, error) {
+	user, err := us.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, Domain.ErrUserNotFound) {
+			return nil, false, Domain.ErrInvalidCredentials
+		}
+		return nil, false, err
+	}
+	rehash, err := us.Passwords.Verify(password, user.Password)
+	if err != nil {
+		if !errors.Is(err, Infrastructure.ErrPasswordMismatch) {
+			slog.ErrorContext(ctx, "checking password", "account_id", user.ID.Hex(), "error", err)
+		}
+		return nil, false, Domain.ErrInvalidCredentials
+	}
+	return user, rehash, nil
+}
+
+func (us *UserService) rehashPassword(ctx context.Context, user Domain.User, password string) {
+	hash, err := us.Passwords.Hash(password)
+	if err == nil {
+		err = us.repo.UpdatePassword(ctx, user.ID.Hex(), hash)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "rehashing password", "account_id", user.ID.Hex(), "error", err)
+	}
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair in the same
+// session. Each refresh token can be used once; presenting one that was
+// already rotated revokes the whole session, since either the client or an
+// attacker holds a stolen copy.
+func (us *UserService) RefreshTokens(ctx context.Context, refreshToken string) (*Domain.TokenPair, error) {
+	claims, err := us.tokens.Parse(refreshToken, Infrastructure.RefreshTokenType)
+	if err != nil {
+		return nil, err
+	}
+	record, err := us.tokenRepo.GetRefreshToken(ctx, claims.ID)
+	if err != nil {
+		if errors.Is(err, Domain.ErrTokenNotFound) {
+			return nil, Domain.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if record.RevokedAt != nil || record.ExpiresAt.Before(time.Now()) {
+		return nil, Domain.ErrInvalidToken
+	}
+	if record.ReplacedBy != "" {
+		return nil, us.revokeReusedSession(ctx, record.SessionID)
+	}
+
+	user, err := us.repo.GetUserByID(ctx, record.UserID.Hex())
+	if err != nil {
+		if errors.Is(err, Domain.ErrUserNotFound) {
+			return nil, Domain.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, Domain.ErrAccountDisabled
+	}
+	pair, replacementID, err := us.issueTokens(ctx, *user, record.SessionID, record.MFA)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.tokenRepo.RotateRefreshToken(ctx, record.ID, replacementID); err != nil {
+		if errors.Is(err, Domain.ErrTokenReused) {
+			return nil, us.revokeReusedSession(ctx, record.SessionID)
+		}
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Logout revokes the presented access token and ends its session, so no
+// refresh token issued for it can be used again.
+func (us *UserService) Logout(ctx context.Context, principal Domain.Principal) error {
+	if err := us.tokenRepo.RevokeAccessToken(ctx, principal.TokenID, principal.ExpiresAt); err != nil {
+		return err
+	}
+	return us.revokeSession(ctx, principal.SessionID)
+}
+
+func (us *UserService) revokeSession(ctx context.Context, sessionID string) error {
+	// Access tokens issued for the session expire within AccessTTL from now.
+	return us.tokenRepo.RevokeSession(ctx, sessionID, time.Now().Add(us.tokens.AccessTTL))
+}
+
+// revokeUserSessions ends every session of the user, so that a changed
+// password, role or account state takes effect immediately.
+func (us *UserService) revokeUserSessions(ctx context.Context, user Domain.User) error {
+	return us.tokenRepo.RevokeUserSessions(ctx, user.ID, time.Now().Add(us.tokens.AccessTTL))
+}
+
+func (us *UserService) revokeReusedSession(ctx context.Context, sessionID string) error {
+	if err := us.revokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+	return Domain.ErrTokenReused
+}
+
+// issueTokens mints and records a token pair for the session and returns the
+// id of the new refresh token. mfa says whether the session was opened with
+// a second factor.
+func (us *UserService) issueTokens(ctx context.Context, user Domain.User, sessionID string, mfa bool) (*Domain.TokenPair, string, error) {
+	access, err := us.tokens.IssueAccessToken(user, sessionID, mfa)
+	if err != nil {
+		return nil, "", err
+	}
+	refresh, err := us.tokens.IssueRefreshToken(user, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	err = us.tokenRepo.CreateRefreshToken(ctx, Domain.RefreshToken{
+		ID:        refresh.ID,
+		SessionID: sessionID,
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		ExpiresAt: refresh.ExpiresAt,
+		MFA:       mfa,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &Domain.TokenPair{
+		AccessToken:  access.Token,
+		RefreshToken: refresh.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(us.tokens.AccessTTL.Seconds()),
+	}, refresh.ID, nil
+}
+
+func (us *UserService) GetUserByID(ctx context.Context, userID string) (*Domain.User, error) {
+	return us.repo.GetUserByID(ctx, userID)
+}
+
+func (us *UserService) GetAllUsers(ctx context.Context) ([]Domain.User, error) {
+	return us.repo.GetAllUsers(ctx)
+}
+
+func (us *UserService) GetProfile(ctx context.Context, principal Domain.Principal) (*Domain.User, error) {
+	return us.repo.GetUserByID(ctx, principal.UserID.Hex())
+}
+
+func (us *UserService) UpdateProfile(ctx context.Context, principal Domain.Principal, username string) (*Domain.User, error) {
+	username = Domain.NormalizeUsername(username)
+	return us.repo.UpdateUser(ctx, principal.UserID.Hex(), Domain.UserUpdate{Username: &username})
+}
+
+// ChangePassword replaces the caller's password and ends all of their
+// sessions, including the current one. The caller carries on with the token
+// pair of a fresh session, which keeps the second factor of the current one.
+// Wrong current passwords count as failed logins, so a stolen session can't
+// be used to guess the password.
+func (us *UserService) ChangePassword(ctx context.Context, principal Domain.Principal, currentPassword, newPassword, clientIP string) (*Domain.TokenPair, error) {
+	user, err := us.repo.GetUserByID(ctx, principal.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	throttles := us.loginThrottles(user.Username, clientIP)
+	if err := us.checkLoginThrottles(ctx, throttles); err != nil {
+		return nil, err
+	}
+	if _, err := us.Passwords.Verify(currentPassword, user.Password); err != nil {
+		if err := us.recordLoginFailure(ctx, throttles); err != nil {
+			return nil, err
+		}
+		return nil, Domain.ErrWrongPassword
+	}
+	if err := us.clearLoginFailures(ctx, throttles); err != nil {
+		return nil, err
+	}
+	if err := us.PasswordPolicy.Check("new_password", user.Username, newPassword); err != nil {
+		return nil, err
+	}
+	hash, err := us.Passwords.Hash(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.repo.UpdatePassword(ctx, user.ID.Hex(), hash); err != nil {
+		return nil, err
+	}
+	if err := us.revokeUserSessions(ctx, *user); err != nil {
+		return nil, err
+	}
+	sessionID, err := Infrastructure.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+	pair, _, err := us.issueTokens(ctx, *user, sessionID, principal.MFA)
+	return pair, err
+}
+
+// DeleteAccount deletes the caller's account together with their tasks.
+func (us *UserService) DeleteAccount(ctx context.Context, principal Domain.Principal) error {
+	return us.DeleteUser(ctx, principal.UserID.Hex(), Domain.UserDeletion{Tasks: Domain.CascadeDelete})
+}
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+// BeginTOTPEnrollment gives the caller a new TOTP secret to add to their
+// authenticator app. It only takes effect once ConfirmTOTPEnrollment sees a
+// code made with it, so a secret that never made it into the app can't lock
+// anybody out.
+func (us *UserService) BeginTOTPEnrollment(ctx context.Context, principal Domain.Principal) (*Domain.TOTPEnrollment, error) {
+	user, err := us.repo.GetUserByID(ctx, principal.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.Enabled {
+		return nil, Domain.ErrMFAEnabled
+	}
+	secret, err := Infrastructure.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	mfa := user.MFA
+	mfa.PendingSecret = secret
+	if err := us.repo.UpdateMFA(ctx, user.ID.Hex(), mfa); err != nil {
+		return nil, err
+	}
+	return &Domain.TOTPEnrollment{Secret: secret, URI: us.TOTP.URI(secret, user.Username)}, nil
+}
+
+// ConfirmTOTPEnrollment turns MFA on once code matches the pending secret
+// and returns the user's recovery codes, which are not shown again. The
+// current session carries on without a second factor; logging in again
+// gives one with it.
+func (us *UserService) ConfirmTOTPEnrollment(ctx context.Context, principal Domain.Principal, code string) ([]string, error) {
+	user, err := us.repo.GetUserByID(ctx, principal.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if user.MFA.Enabled {
+		return nil, Domain.ErrMFAEnabled
+	}
+	if user.MFA.PendingSecret == "" {
+		return nil, fmt.Errorf("%w: no enrollment has been started", Domain.ErrMFANotEnabled)
+	}
+	step, ok := us.TOTP.Verify(user.MFA.PendingSecret, strings.TrimSpace(code), time.Now(), 0)
+	if !ok {
+		return nil, Domain.ErrInvalidMFACode
+	}
+	codes, hashes, err := Infrastructure.NewRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	mfa := Domain.MFA{Enabled: true, TOTPSecret: user.MFA.PendingSecret, LastStep: step, RecoveryCodes: hashes}
+	if err := us.repo.UpdateMFA(ctx, user.ID.Hex(), mfa); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes, given a
+// current code.
+func (us *UserService) RegenerateRecoveryCodes(ctx context.Context, principal Domain.Principal, code string) ([]string, error) {
+	user, err := us.enrolledUser(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.verifyMFACode(ctx, user, code, us.loginThrottles(user.Username, "")); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := Infrastructure.NewRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	user.MFA.RecoveryCodes = hashes
+	if err := us.repo.UpdateMFA(ctx, user.ID.Hex(), user.MFA); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns the caller's MFA off, given a current code. Holders of a
+// role that requires MFA can't.
+func (us *UserService) DisableMFA(ctx context.Context, principal Domain.Principal, code string) error {
+	user, err := us.enrolledUser(ctx, principal)
+	if err != nil {
+		return err
+	}
+	role, err := us.roles.GetRole(ctx, user.Role)
+	if err != nil && !errors.Is(err, Domain.ErrRoleNotFound) {
+		return err
+	}
+	if role != nil && role.RequireMFA {
+		return fmt.Errorf("%w: it can't be turned off", Domain.ErrMFARequired)
+	}
+	if err := us.verifyMFACode(ctx, user, code, us.loginThrottles(user.Username, "")); err != nil {
+		return err
+	}
+	return us.repo.UpdateMFA(ctx, user.ID.Hex(), Domain.MFA{})
+}
+
+func (us *UserService) enrolledUser(ctx context.Context, principal Domain.Principal) (*Domain.User, error) {
+	user, err := us.repo.GetUserByID(ctx, principal.UserID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFA.Enabled {
+		return nil, Domain.ErrMFANotEnabled
+	}
+	return user, nil
+}
+
+// verifyMFACode accepts a TOTP code or one of the user's recovery codes and
+// uses it up, updating user.MFA. Wrong codes are counted against the
+// throttles like wrong passwords.
+func (us *UserService) verifyMFACode(ctx context.Context, user *Domain.User, code string, throttles []loginThrottle) error {
+	if err := us.checkLoginThrottles(ctx, throttles); err != nil {
+		return err
+	}
+	code = strings.TrimSpace(code)
+	mfa := user.MFA
+	used := false
+	if step, ok := us.TOTP.Verify(mfa.TOTPSecret, code, time.Now(), mfa.LastStep); ok {
+		mfa.LastStep = step
+		used = true
+	} else if code != "" {
+		hash := Infrastructure.HashRecoveryCode(code)
+		for i, stored := range mfa.RecoveryCodes {
+			if stored == hash {
+				remaining := make([]string, 0, len(mfa.RecoveryCodes)-1)
+				mfa.RecoveryCodes = append(append(remaining, mfa.RecoveryCodes[:i]...), mfa.RecoveryCodes[i+1:]...)
+				used = true
+				break
+			}
+		}
+	}
+	if !used {
+		if err := us.recordLoginFailure(ctx, throttles); err != nil {
+			return err
+		}
+		return Domain.ErrInvalidMFACode
+	}
+	if err := us.repo.UpdateMFA(ctx, user.ID.Hex(), mfa); err != nil {
+		return err
+	}
+	user.MFA = mfa
+	return nil
+}
+
+func (us *UserService) SetUserRole(ctx context.Context, userID, role string) (*Domain.User, error) {
+	if _, err := us.roles.GetRole(ctx, role); err != nil {
+		if errors.Is(err, Domain.ErrRoleNotFound) {
+			return nil, fmt.Errorf("%w: %q", Domain.ErrInvalidRole, role)
+		}
+		return nil, err
+	}
+	user, err := us.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+	if err := us.ensureNotLastAdmin(ctx, *user); err != nil {
+		return nil, err
+	}
+	updated, err := us.repo.UpdateUser(ctx, userID, Domain.UserUpdate{Role: &role})
+	if err != nil {
+		return nil, err
+	}
+	// Access tokens carry the role, so the old ones must stop working.
+	if err := us.revokeUserSessions(ctx, *updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// SetUserTeam puts the user in a team, or takes them out of theirs when team
+// is empty. Managers see the tasks of everybody in their team.
+func (us *UserService) SetUserTeam(ctx context.Context, userID, team string) (*Domain.User, error) {
+	team = strings.TrimSpace(team)
+	return us.repo.UpdateUser(ctx, userID, Domain.UserUpdate{Team: &team})
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling one ends its
+// sessions and stops it from logging in.
+func (us *UserService) SetUserDisabled(ctx context.Context, userID string, disabled bool) (*Domain.User, error) {
+	user, err := us.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled == disabled {
+		return user, nil
+	}
+	if disabled {
+		if err := us.ensureNotLastAdmin(ctx, *user); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := us.repo.UpdateUser(ctx, userID, Domain.UserUpdate{Disabled: &disabled})
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := us.revokeUserSessions(ctx, *updated); err != nil {
+			return nil, err
+		}
+	}
+	return updated, nil
+}
+
+// DeleteUser deletes an account after dealing with its tasks as the
+// deletion asks; an empty policy means CascadeRestrict. The tasks are
+// handled first so that a failure leaves the account in place.
+func (us *UserService) DeleteUser(ctx context.Context, userID string, deletion Domain.UserDeletion) error {
+	user, err := us.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := us.ensureNotLastAdmin(ctx, *user); err != nil {
+		return err
+	}
+
+	switch deletion.Tasks {
+	case Domain.CascadeRestrict, "":
+		page, err := us.taskRepo.GetTasks(ctx, Domain.TaskFilter{UserID: userID, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if page.Total > 0 {
+			return fmt.Errorf("%w: %d tasks", Domain.ErrUserHasTasks, page.Total)
+		}
+	case Domain.CascadeDelete:
+		if _, err := us.taskRepo.DeleteTasksByUserID(ctx, userID); err != nil {
+			return err
+		}
+	case Domain.CascadeReassign:
+		if deletion.ReassignTo == "" || deletion.ReassignTo == userID {
+			return fmt.Errorf("%w: tasks must be reassigned to another user", Domain.ErrInvalidRequest)
+		}
+		if _, err := us.repo.GetUserByID(ctx, deletion.ReassignTo); err != nil {
+			if errors.Is(err, Domain.ErrUserNotFound) {
+				return fmt.Errorf("%w: no user %s to reassign tasks to", Domain.ErrInvalidRequest, deletion.ReassignTo)
+			}
+			return err
+		}
+		if _, err := us.taskRepo.ReassignTasks(ctx, userID, deletion.ReassignTo); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown task policy %q", Domain.ErrInvalidRequest, deletion.Tasks)
+	}
+
+	if err := us.repo.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	return us.revokeUserSessions(ctx, *user)
+}
+
+// ensureNotLastAdmin fails with Domain.ErrLastAdmin if user is the only
+// enabled admin, since demoting, disabling or deleting them would leave
+// nobody able to administer the installation.
+func (us *UserService) ensureNotLastAdmin(ctx context.Context, user Domain.User) error {
+	if user.Role != Domain.RoleAdmin || user.Disabled {
+		return nil
+	}
+	users, err := us.repo.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range users {
+		if other.ID != user.ID && other.Role == Domain.RoleAdmin && !other.Disabled {
+			return nil
+		}
+	}
+	return Domain.ErrLastAdmin
+}
+
+// ListLoginAttempts returns every username and client address with recent
+// failed logins, including those currently locked out.
+func (us *UserService) ListLoginAttempts(ctx context.Context) ([]Domain.LoginAttempts, error) {
+	return us.attempts.ListLoginAttempts(ctx)
+}
+
+// ClearLoginAttempts lifts a lockout and forgets the failures counted
+// against the key.
+func (us *UserService) ClearLoginAttempts(ctx context.Context, key string) error {
+	return us.attempts.ClearLoginAttempts(ctx, key)
+}
+
+// ResetMFA turns a user's MFA off for somebody who lost both their
+// authenticator and their recovery codes, and ends their sessions.
+func (us *UserService) ResetMFA(ctx context.Context, userID string) (*Domain.User, error) {
+	user, err := us.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := us.repo.UpdateMFA(ctx, userID, Domain.MFA{}); err != nil {
+		return nil, err
+	}
+	if err := us.revokeUserSessions(ctx, *user); err != nil {
+		return nil, err
+	}
+	user.MFA = Domain.MFA{}
+	return user, nil
+}
+
+// CreateAPIKey creates a key for the principal and returns it along with
+// the key itself, which is not stored and can't be shown again. The key can
+// only be given permissions the principal has.
+func (us *UserService) CreateAPIKey(ctx context.Context, principal Domain.Principal, name string, scopes []Domain.Permission, expiresAt *time.Time) (*Domain.APIKey, string, error) {
+	now := time.Now()
+	expires := now.Add(us.APIKeyTTL)
+	if expiresAt != nil {
+		expires = *expiresAt
+	}
+	var problems []Domain.FieldError
+	if !expires.After(now) || expires.After(now.Add(us.MaxAPIKeyTTL)) {
+		problems = append(problems, Domain.FieldError{
+			Field: "expires_at", Rule: "range", Message: fmt.Sprintf("must be in the next %s", us.MaxAPIKeyTTL),
+		})
+	}
+	if len(scopes) == 0 {
+		problems = append(problems, Domain.FieldError{Field: "scopes", Rule: "required", Message: "is required"})
+	}
+	for _, scope := range scopes {
+		if !Domain.ValidPermission(scope) {
+			problems = append(problems, Domain.FieldError{
+				Field: "scopes", Rule: "permission", Message: fmt.Sprintf("has unknown permission %q", scope),
+			})
+		}
+	}
+	if len(problems) > 0 {
+		return nil, "", &Domain.ValidationError{Fields: problems}
+	}
+	for _, scope := range scopes {
+		if !principal.Can(scope) {
+			return nil, "", fmt.Errorf("%w: your role doesn't grant %s", Domain.ErrForbidden, scope)
+		}
+	}
+
+	apiKey, id, secretHash, err := Infrastructure.NewAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	key := Domain.APIKey{
+		ID:         id,
+		UserID:     principal.UserID,
+		Name:       strings.TrimSpace(name),
+		SecretHash: secretHash,
+		Scopes:     scopes,
+		CreatedAt:  now,
+		ExpiresAt:  expires,
+		MFA:        principal.MFA,
+	}
+	if err := us.apiKeys.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+	return &key, apiKey, nil
+}
+
+func (us *UserService) ListAPIKeys(ctx context.Context, principal Domain.Principal) ([]Domain.APIKey, error) {
+	return us.apiKeys.ListAPIKeys(ctx, principal.UserID)
+}
+
+func (us *UserService) RevokeAPIKey(ctx context.Context, principal Domain.Principal, id string) error {
+	return us.apiKeys.RevokeAPIKey(ctx, id, principal.UserID, time.Now())
+}
+
+// apiKeyUseResolution is how stale a key's last use may get before it is
+// written again, so that a busy key doesn't cost a write per request.
+const apiKeyUseResolution = time.Minute
+
+// AuthenticateAPIKey returns the principal an API key acts as. Keys that
+// are unknown, expired or revoked, or whose owner is gone, all fail with
+// Domain.ErrInvalidAPIKey.
+func (us *UserService) AuthenticateAPIKey(ctx context.Context, apiKey string) (Domain.Principal, error) {
+	id, secret, ok := Infrastructure.ParseAPIKey(apiKey)
+	if !ok {
+		return Domain.Principal{}, Domain.ErrInvalidAPIKey
+	}
+	key, err := us.apiKeys.GetAPIKey(ctx, id)
+	if err != nil {
+		if errors.Is(err, Domain.ErrAPIKeyNotFound) {
+			return Domain.Principal{}, Domain.ErrInvalidAPIKey
+		}
+		return Domain.Principal{}, err
+	}
+	now := time.Now()
+	if !Infrastructure.APIKeySecretMatches(secret, key.SecretHash) || !key.Active(now) {
+		return Domain.Principal{}, Domain.ErrInvalidAPIKey
+	}
+	user, err := us.repo.GetUserByID(ctx, key.UserID.Hex())
+	if err != nil {
+		if errors.Is(err, Domain.ErrUserNotFound) {
+			return Domain.Principal{}, Domain.ErrInvalidAPIKey
+		}
+		return Domain.Principal{}, err
+	}
+	if user.Disabled {
+		return Domain.Principal{}, Domain.ErrAccountDisabled
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUseResolution {
+		if err := us.apiKeys.TouchAPIKey(ctx, key.ID, now); err != nil {
+			slog.ErrorContext(ctx, "recording use of API key", "key_id", key.ID, "error", err)
+		}
+	}
+	return Domain.Principal{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+		MFA:      key.MFA,
+	}, nil
 }
 
-func (us *UserService) RegisterUser(user Domain.User) (*Domain.User, error) {
-	return us.repo.CreateUser(user)
+func (us *UserService) ListRoles(ctx context.Context) ([]Domain.Role, error) {
+	return us.roles.ListRoles(ctx)
 }
 
-func (us *UserService) AuthenticateUser(username, password string) (string, error) {
-	return us.repo.AuthenticateUser(username, password)
+// SaveRole creates a role or replaces its permissions. The admin role can't
+// be changed, so that somebody can always manage the server. Principals pick
+// up the new permissions on their next request.
+func (us *UserService) SaveRole(ctx context.Context, role Domain.Role) (*Domain.Role, error) {
+	if !Domain.ValidRoleName(role.Name) {
+		return nil, &Domain.ValidationError{Fields: []Domain.FieldError{{
+			Field: "name", Rule: "role_name", Message: "must be lowercase letters, digits, dashes or underscores",
+		}}}
+	}
+	if role.Name == Domain.RoleAdmin {
+		return nil, Domain.ErrRoleBuiltIn
+	}
+	for _, p := range role.Permissions {
+		if !Domain.ValidPermission(p) {
+			return nil, &Domain.ValidationError{Fields: []Domain.FieldError{{
+				Field: "permissions", Rule: "permission", Message: fmt.Sprintf("has unknown permission %q", p),
+			}}}
+		}
+	}
+	// Whether the role requires MFA is changed with SetRoleMFA only.
+	existing, err := us.roles.GetRole(ctx, role.Name)
+	switch {
+	case err == nil:
+		role.RequireMFA = existing.RequireMFA
+	case errors.Is(err, Domain.ErrRoleNotFound):
+		role.RequireMFA = false
+	default:
+		return nil, err
+	}
+	if err := us.roles.SaveRole(ctx, role); err != nil {
+		return nil, err
+	}
+	return &role, nil
 }
 
-func (us *UserService) GetUserByID(userID string) (*Domain.User, error) {
-	return us.repo.GetUserByID(userID)
+// SetRoleMFA sets whether holders of the role need a second factor. Unlike
+// its permissions, this can be set for the admin role too. Holders without
+// MFA keep their sessions but lose the role's permissions until they enroll
+// and log in with it.
+func (us *UserService) SetRoleMFA(ctx context.Context, name string, required bool) (*Domain.Role, error) {
+	role, err := us.roles.GetRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	role.RequireMFA = required
+	if err := us.roles.SaveRole(ctx, *role); err != nil {
+		return nil, err
+	}
+	return role, nil
 }
 
-func (us *UserService) GetAllUsers() ([]Domain.User, error) {
-	return us.repo.GetAllUsers()
+// DeleteRole deletes a role nobody holds. Built-in roles can't be deleted.
+func (us *UserService) DeleteRole(ctx context.Context, name string) error {
+	if Domain.BuiltInRole(name) {
+		return Domain.ErrRoleBuiltIn
+	}
+	if _, err := us.roles.GetRole(ctx, name); err != nil {
+		return err
+	}
+	users, err := us.repo.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.Role == name {
+			return fmt.Errorf("%w: %s", Domain.ErrRoleInUse, user.Username)
+		}
+	}
+	return us.roles.DeleteRole(ctx, name)
 }
@@ -1,11 +1,19 @@
 package Usecases
 
 import (
+	"context"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"TaskManager5/Domain"
+	"TaskManager5/Infrastructure"
+	"TaskManager5/Repositories"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Mock repository
@@ -13,66 +21,253 @@ type MockUserRepository struct {
 	mock.Mock
 }
 
-func (m *MockUserRepository) CreateUser(user Domain.User) (*Domain.User, error) {
+func (m *MockUserRepository) CreateUser(ctx context.Context, user Domain.User) (*Domain.User, error) {
 	args := m.Called(user)
 	return args.Get(0).(*Domain.User), args.Error(1)
 }
 
-func (m *MockUserRepository) AuthenticateUser(username, password string) (string, error) {
-	args := m.Called(username, password)
-	return args.String(0), args.Error(1)
+func (m *MockUserRepository) GetUserByUsername(ctx context.Context, username string) (*Domain.User, error) {
+	args := m.Called(username)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
 }
 
-func (m *MockUserRepository) GetUserByID(userID string) (*Domain.User, error) {
+func (m *MockUserRepository) GetUserByID(ctx context.Context, userID string) (*Domain.User, error) {
 	args := m.Called(userID)
 	return args.Get(0).(*Domain.User), args.Error(1)
 }
 
-func (m *MockUserRepository) GetAllUsers() ([]Domain.User, error) {
+func (m *MockUserRepository) GetAllUsers(ctx context.Context) ([]Domain.User, error) {
 	args := m.Called()
 	return args.Get(0).([]Domain.User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetUsersByTeam(ctx context.Context, team string) ([]Domain.User, error) {
+	args := m.Called(team)
+	return args.Get(0).([]Domain.User), args.Error(1)
+}
+
+func (m *MockUserRepository) UpdateUser(ctx context.Context, userID string, update Domain.UserUpdate) (*Domain.User, error) {
+	args := m.Called(userID, update)
+	user, _ := args.Get(0).(*Domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepository) UpdatePassword(ctx context.Context, userID, password string) error {
+	args := m.Called(userID, password)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) UpdateMFA(ctx context.Context, userID string, mfa Domain.MFA) error {
+	args := m.Called(userID, mfa)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) DeleteUser(ctx context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func newTestUserService(repo Repositories.UserRepository) *UserService {
+	return NewUserService(repo, Repositories.NewInMemoryTaskRepository(), Repositories.NewInMemoryTokenRepository(),
+		Repositories.NewInMemoryLoginAttemptRepository(), Repositories.NewInMemoryRoleRepository(), Repositories.NewInMemoryAPIKeyRepository(), Infrastructure.NewTokenService(Infrastructure.NewHMACKeyRing("secret")))
+}
+
+// withPasswordHash matches want with its password replaced by a hash of it
+func withPasswordHash(service *UserService, want Domain.User) interface{} {
+	return mock.MatchedBy(func(user Domain.User) bool {
+		if _, err := service.Passwords.Verify(want.Password, user.Password); err != nil {
+			return false
+		}
+		user.Password = want.Password
+		return reflect.DeepEqual(user, want)
+	})
+}
+
 // Test for RegisterUser
 func TestRegisterUser(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockUserRepository)
-	service := NewUserService(mockRepo, "secret")
+	service := newTestUserService(mockRepo)
 
 	user := Domain.User{
 		ID:       primitive.NewObjectID(),
 		Username: "user1",
 		Password: "password",
+		Role:     Domain.RoleUser,
 	}
-	mockRepo.On("CreateUser", user).Return(&user, nil)
+	mockRepo.On("CreateUser", withPasswordHash(service, user)).Return(&user, nil)
 
-	result, err := service.RegisterUser(user)
+	submitted := user
+	submitted.Role = Domain.RoleAdmin
+	result, err := service.RegisterUser(ctx, submitted)
 
 	assert.NoError(t, err)
 	assert.Equal(t, &user, result)
 	mockRepo.AssertExpectations(t)
 }
 
+// Test that EnsureAdmin creates the configured admin once
+func TestEnsureAdmin(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+
+	admin := Domain.User{Username: "root", Password: "password", Role: Domain.RoleAdmin}
+	mockRepo.On("GetUserByUsername", "root").Return(nil, Domain.ErrUserNotFound).Once()
+	mockRepo.On("CreateUser", withPasswordHash(service, admin)).Return(&admin, nil).Once()
+	assert.NoError(t, service.EnsureAdmin(ctx, "Root", "password"))
+
+	mockRepo.On("GetUserByUsername", "root").Return(&admin, nil).Once()
+	assert.NoError(t, service.EnsureAdmin(ctx, "root", "password"), "an existing admin is left as it is")
+
+	mockRepo.On("GetUserByUsername", "root").Return(&Domain.User{Username: "root", Role: Domain.RoleUser}, nil).Once()
+	assert.ErrorIs(t, service.EnsureAdmin(ctx, "root", "password"), Domain.ErrUsernameTaken, "an ordinary user is not promoted")
+	mockRepo.AssertExpectations(t)
+}
+
 // Test for AuthenticateUser
 func TestAuthenticateUser(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockUserRepository)
-	service := NewUserService(mockRepo, "secret")
+	service := newTestUserService(mockRepo)
 
 	username := "user1"
 	password := "password"
-	token := "someJWTToken"
-	mockRepo.On("AuthenticateUser", username, password).Return(token, nil)
+	hash, err := service.Passwords.Hash(password)
+	assert.NoError(t, err)
+	mockRepo.On("GetUserByUsername", username).Return(&Domain.User{
+		ID:       primitive.NewObjectID(),
+		Username: username,
+		Password: hash,
+		Role:     Domain.RoleUser,
+	}, nil)
 
-	result, err := service.AuthenticateUser(username, password)
+	result, _, err := service.AuthenticateUser(ctx, username, password, "")
 
 	assert.NoError(t, err)
-	assert.Equal(t, token, result)
+	assert.NotEmpty(t, result.AccessToken)
+	assert.NotEmpty(t, result.RefreshToken)
+	assert.Equal(t, "Bearer", result.TokenType)
 	mockRepo.AssertExpectations(t)
 }
 
+// loginForTest registers a user on the mock and returns a fresh token pair
+func loginForTest(t *testing.T, mockRepo *MockUserRepository, service *UserService) (*Domain.User, *Domain.TokenPair) {
+	ctx := context.Background()
+	hash, err := service.Passwords.Hash("password")
+	assert.NoError(t, err)
+	user := &Domain.User{ID: primitive.NewObjectID(), Username: "user1", Password: hash, Role: Domain.RoleUser}
+	mockRepo.On("GetUserByUsername", user.Username).Return(user, nil)
+	mockRepo.On("GetUserByID", user.ID.Hex()).Return(user, nil)
+
+	pair, _, err := service.AuthenticateUser(ctx, user.Username, "password", "")
+	assert.NoError(t, err)
+	return user, pair
+}
+
+// Test that refreshing rotates the refresh token
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+	_, pair := loginForTest(t, mockRepo, service)
+
+	refreshed, err := service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, pair.RefreshToken, refreshed.RefreshToken)
+
+	refreshed, err = service.RefreshTokens(ctx, refreshed.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, refreshed.AccessToken)
+}
+
+// Test that reusing a rotated refresh token revokes the whole session
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+	_, pair := loginForTest(t, mockRepo, service)
+
+	rotated, err := service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.NoError(t, err)
+
+	_, err = service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, Domain.ErrTokenReused)
+
+	// The legitimate successor is dead too, and so is its access token
+	_, err = service.RefreshTokens(ctx, rotated.RefreshToken)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+
+	claims, err := service.tokens.Parse(rotated.AccessToken, Infrastructure.AccessTokenType)
+	assert.NoError(t, err)
+	revoked, err := service.tokenRepo.IsRevoked(ctx, claims.ID, claims.SessionID)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+// Test that refresh rejects access tokens and garbage
+func TestRefreshTokensInvalid(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+	_, pair := loginForTest(t, mockRepo, service)
+
+	_, err := service.RefreshTokens(ctx, pair.AccessToken)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+
+	_, err = service.RefreshTokens(ctx, "garbage")
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+}
+
+// Test that logout revokes the access token and the session's refresh tokens
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+	user, pair := loginForTest(t, mockRepo, service)
+
+	claims, err := service.tokens.Parse(pair.AccessToken, Infrastructure.AccessTokenType)
+	assert.NoError(t, err)
+	principal := Domain.Principal{
+		UserID:    user.ID,
+		TokenID:   claims.ID,
+		SessionID: claims.SessionID,
+	}
+
+	assert.NoError(t, service.Logout(ctx, principal))
+
+	revoked, err := service.tokenRepo.IsRevoked(ctx, principal.TokenID, "")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+}
+
+// Test AuthenticateUser with a wrong password or unknown user
+func TestAuthenticateUserInvalid(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockUserRepository)
+	service := newTestUserService(mockRepo)
+
+	hash, err := service.Passwords.Hash("password")
+	assert.NoError(t, err)
+	mockRepo.On("GetUserByUsername", "user1").Return(&Domain.User{Username: "user1", Password: hash}, nil)
+	mockRepo.On("GetUserByUsername", "ghost").Return(nil, Domain.ErrUserNotFound)
+
+	_, _, err = service.AuthenticateUser(ctx, "user1", "wrong", "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+
+	_, _, err = service.AuthenticateUser(ctx, "ghost", "password", "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+}
+
 // Test for GetUserByID
 func TestGetUserByID(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockUserRepository)
-	service := NewUserService(mockRepo, "secret")
+	service := newTestUserService(mockRepo)
 
 	user := &Domain.User{
 		ID:       primitive.NewObjectID(),
@@ -81,7 +276,7 @@ func TestGetUserByID(t *testing.T) {
 	}
 	mockRepo.On("GetUserByID", user.ID.Hex()).Return(user, nil)
 
-	result, err := service.GetUserByID(user.ID.Hex())
+	result, err := service.GetUserByID(ctx, user.ID.Hex())
 
 	assert.NoError(t, err)
 	assert.Equal(t, user, result)
@@ -90,8 +285,9 @@ func TestGetUserByID(t *testing.T) {
 
 // Test for GetAllUsers
 func TestGetAllUsers(t *testing.T) {
+	ctx := context.Background()
 	mockRepo := new(MockUserRepository)
-	service := NewUserService(mockRepo, "secret")
+	service := newTestUserService(mockRepo)
 
 	users := []Domain.User{
 		{
@@ -107,9 +303,453 @@ func TestGetAllUsers(t *testing.T) {
 	}
 	mockRepo.On("GetAllUsers").Return(users, nil)
 
-	result, err := service.GetAllUsers()
+	result, err := service.GetAllUsers(ctx)
 
 	assert.NoError(t, err)
 	assert.Equal(t, users, result)
 	mockRepo.AssertExpectations(t)
-}
\ No newline at end of file
+}
+// newAccountTestService wires a UserService to in-memory repositories and
+// registers an admin and an ordinary user, both with password "password".
+func newAccountTestService(t *testing.T) (*UserService, Repositories.TaskRepository, *Domain.User, *Domain.User) {
+	ctx := context.Background()
+	tasks := Repositories.NewInMemoryTaskRepository()
+	service := NewUserService(Repositories.NewInMemoryUserRepository(), tasks, Repositories.NewInMemoryTokenRepository(),
+		Repositories.NewInMemoryLoginAttemptRepository(), Repositories.NewInMemoryRoleRepository(), Repositories.NewInMemoryAPIKeyRepository(), Infrastructure.NewTokenService(Infrastructure.NewHMACKeyRing("secret")))
+	assert.NoError(t, service.EnsureAdmin(ctx, "admin", "password"))
+	admin, err := service.repo.GetUserByUsername(ctx, "admin")
+	assert.NoError(t, err)
+	user, err := service.RegisterUser(ctx, Domain.User{Username: "user1", Password: "password"})
+	assert.NoError(t, err)
+	return service, tasks, admin, user
+}
+
+// Test that changing the password ends the old sessions
+func TestChangePassword(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+	pair, _, err := service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.NoError(t, err)
+	principal := Domain.Principal{UserID: user.ID, Username: user.Username, Role: user.Role}
+
+	_, err = service.ChangePassword(ctx, principal, "wrong", "new password", "")
+	assert.ErrorIs(t, err, Domain.ErrWrongPassword)
+
+	fresh, err := service.ChangePassword(ctx, principal, "password", "new password", "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, fresh.RefreshToken)
+
+	_, err = service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+	_, err = service.RefreshTokens(ctx, fresh.RefreshToken)
+	assert.NoError(t, err)
+
+	_, _, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+	_, _, err = service.AuthenticateUser(ctx, "user1", "new password", "")
+	assert.NoError(t, err)
+}
+
+// Test role changes, including the last-admin guard
+func TestSetUserRole(t *testing.T) {
+	ctx := context.Background()
+	service, _, admin, user := newAccountTestService(t)
+
+	_, err := service.SetUserRole(ctx, user.ID.Hex(), "superuser")
+	assert.ErrorIs(t, err, Domain.ErrInvalidRole)
+
+	_, err = service.SetUserRole(ctx, admin.ID.Hex(), Domain.RoleUser)
+	assert.ErrorIs(t, err, Domain.ErrLastAdmin)
+
+	promoted, err := service.SetUserRole(ctx, user.ID.Hex(), Domain.RoleAdmin)
+	assert.NoError(t, err)
+	assert.Equal(t, Domain.RoleAdmin, promoted.Role)
+
+	demoted, err := service.SetUserRole(ctx, admin.ID.Hex(), Domain.RoleUser)
+	assert.NoError(t, err)
+	assert.Equal(t, Domain.RoleUser, demoted.Role)
+}
+
+// Test that admins can define roles but not break the built-in ones
+func TestRoleManagement(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+
+	_, err := service.SaveRole(ctx, Domain.Role{Name: Domain.RoleAdmin})
+	assert.ErrorIs(t, err, Domain.ErrRoleBuiltIn)
+	_, err = service.SaveRole(ctx, Domain.Role{Name: "Auditors"})
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+	_, err = service.SaveRole(ctx, Domain.Role{Name: "auditor", Permissions: []Domain.Permission{"tasks:delete:any"}})
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+
+	auditor, err := service.SaveRole(ctx, Domain.Role{Name: "auditor", Permissions: []Domain.Permission{Domain.PermTasksReadAny}})
+	assert.NoError(t, err)
+	assert.Equal(t, "auditor", auditor.Name)
+
+	_, err = service.SetUserRole(ctx, user.ID.Hex(), "auditor")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, service.DeleteRole(ctx, "auditor"), Domain.ErrRoleInUse)
+	assert.ErrorIs(t, service.DeleteRole(ctx, Domain.RoleManager), Domain.ErrRoleBuiltIn)
+	assert.ErrorIs(t, service.DeleteRole(ctx, "nobody"), Domain.ErrRoleNotFound)
+
+	_, err = service.SetUserRole(ctx, user.ID.Hex(), Domain.RoleManager)
+	assert.NoError(t, err)
+	assert.NoError(t, service.DeleteRole(ctx, "auditor"))
+	_, err = service.SetUserRole(ctx, user.ID.Hex(), "auditor")
+	assert.ErrorIs(t, err, Domain.ErrInvalidRole)
+
+	moved, err := service.SetUserTeam(ctx, user.ID.Hex(), " ops ")
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", moved.Team)
+}
+
+// Test the life of an API key, from creation to revocation
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+	caller := Domain.Principal{UserID: user.ID, Username: user.Username, Role: user.Role,
+		Permissions: []Domain.Permission{Domain.PermTasksReadOwn, Domain.PermTasksWriteOwn}}
+
+	_, _, err := service.CreateAPIKey(ctx, caller, "ci", []Domain.Permission{Domain.PermTasksReadAny}, nil)
+	assert.ErrorIs(t, err, Domain.ErrForbidden)
+	_, _, err = service.CreateAPIKey(ctx, caller, "ci", nil, nil)
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+	tooLate := time.Now().Add(2 * service.MaxAPIKeyTTL)
+	_, _, err = service.CreateAPIKey(ctx, caller, "ci", []Domain.Permission{Domain.PermTasksReadOwn}, &tooLate)
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+
+	key, secret, err := service.CreateAPIKey(ctx, caller, "ci", []Domain.Permission{Domain.PermTasksReadOwn}, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "tm_"+key.ID+"_"))
+	assert.NotContains(t, key.SecretHash, secret)
+	assert.WithinDuration(t, time.Now().Add(service.APIKeyTTL), key.ExpiresAt, time.Minute)
+
+	principal, err := service.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, principal.UserID)
+	assert.Equal(t, key.ID, principal.APIKeyID)
+	assert.Equal(t, []Domain.Permission{Domain.PermTasksReadOwn}, principal.Scopes)
+
+	_, err = service.AuthenticateAPIKey(ctx, secret + "x")
+	assert.ErrorIs(t, err, Domain.ErrInvalidAPIKey)
+	_, err = service.AuthenticateAPIKey(ctx, "tm_nope")
+	assert.ErrorIs(t, err, Domain.ErrInvalidAPIKey)
+
+	keys, err := service.ListAPIKeys(ctx, caller)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+
+	require.NoError(t, service.RevokeAPIKey(ctx, caller, key.ID))
+	_, err = service.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, Domain.ErrInvalidAPIKey)
+}
+
+// Test enrolling in TOTP and logging in with a code or a recovery code
+func TestMFALogin(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+	caller := Domain.Principal{UserID: user.ID, Username: user.Username, Role: user.Role}
+
+	enrollment, err := service.BeginTOTPEnrollment(ctx, caller)
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+	_, err = service.ConfirmTOTPEnrollment(ctx, caller, "000000")
+	assert.ErrorIs(t, err, Domain.ErrInvalidMFACode)
+	code, err := service.TOTP.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := service.ConfirmTOTPEnrollment(ctx, caller, code)
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, RecoveryCodeCount)
+	_, err = service.BeginTOTPEnrollment(ctx, caller)
+	assert.ErrorIs(t, err, Domain.ErrMFAEnabled)
+
+	pair, challenge, err := service.AuthenticateUser(ctx, "user1", "password", "")
+	require.NoError(t, err)
+	assert.Nil(t, pair)
+	require.NotNil(t, challenge)
+	assert.True(t, challenge.MFARequired)
+
+	// The code that confirmed the enrollment has been used up.
+	_, err = service.CompleteMFALogin(ctx, challenge.MFAToken, code, "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidMFACode)
+	_, err = service.CompleteMFALogin(ctx, "not a token", code, "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+	next, err := service.TOTP.Code(enrollment.Secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	pair, err = service.CompleteMFALogin(ctx, challenge.MFAToken, next, "")
+	require.NoError(t, err)
+	refreshed, err := service.RefreshTokens(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+	claims, err := service.tokens.Parse(refreshed.AccessToken, Infrastructure.AccessTokenType)
+	require.NoError(t, err)
+	assert.True(t, claims.MFA, "refreshed tokens keep the second factor")
+
+	// Each recovery code works once.
+	_, challenge, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	require.NoError(t, err)
+	_, err = service.CompleteMFALogin(ctx, challenge.MFAToken, strings.ToUpper(recoveryCodes[0]), "")
+	require.NoError(t, err)
+	_, err = service.CompleteMFALogin(ctx, challenge.MFAToken, recoveryCodes[0], "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidMFACode)
+
+	fresh, err := service.RegenerateRecoveryCodes(ctx, caller, recoveryCodes[1])
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.DisableMFA(ctx, caller, recoveryCodes[2]), Domain.ErrInvalidMFACode)
+	require.NoError(t, service.DisableMFA(ctx, caller, fresh[0]))
+
+	pair, challenge, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+	assert.NotNil(t, pair)
+}
+
+// Test that wrong codes are throttled like wrong passwords
+func TestMFALoginLockout(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+	service.UserLockout = Domain.LockoutPolicy{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Minute, ResetAfter: time.Hour}
+	caller := Domain.Principal{UserID: user.ID, Username: user.Username, Role: user.Role}
+	enrollment, err := service.BeginTOTPEnrollment(ctx, caller)
+	require.NoError(t, err)
+	code, err := service.TOTP.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := service.ConfirmTOTPEnrollment(ctx, caller, code)
+	require.NoError(t, err)
+
+	_, challenge, err := service.AuthenticateUser(ctx, "user1", "password", "")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = service.CompleteMFALogin(ctx, challenge.MFAToken, "wrong", "")
+		assert.ErrorIs(t, err, Domain.ErrInvalidMFACode)
+	}
+	_, err = service.CompleteMFALogin(ctx, challenge.MFAToken, recoveryCodes[0], "")
+	assert.ErrorIs(t, err, Domain.ErrTooManyAttempts)
+	// The right password alone doesn't lift the lock.
+	_, _, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.ErrorIs(t, err, Domain.ErrTooManyAttempts)
+}
+
+// Test that a role can require MFA, which its holders then can't turn off
+func TestRoleRequiresMFA(t *testing.T) {
+	ctx := context.Background()
+	service, _, admin, _ := newAccountTestService(t)
+	caller := Domain.Principal{UserID: admin.ID, Username: admin.Username, Role: admin.Role}
+
+	role, err := service.SetRoleMFA(ctx, Domain.RoleAdmin, true)
+	require.NoError(t, err)
+	assert.True(t, role.RequireMFA)
+	_, err = service.SetRoleMFA(ctx, "nobody", true)
+	assert.ErrorIs(t, err, Domain.ErrRoleNotFound)
+
+	enrollment, err := service.BeginTOTPEnrollment(ctx, caller)
+	require.NoError(t, err)
+	code, err := service.TOTP.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes, err := service.ConfirmTOTPEnrollment(ctx, caller, code)
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.DisableMFA(ctx, caller, recoveryCodes[0]), Domain.ErrMFARequired)
+
+	// Saving a role's permissions leaves its requirement alone.
+	_, err = service.SetRoleMFA(ctx, Domain.RoleManager, true)
+	require.NoError(t, err)
+	saved, err := service.SaveRole(ctx, Domain.Role{Name: Domain.RoleManager, Permissions: []Domain.Permission{Domain.PermTasksReadTeam}})
+	require.NoError(t, err)
+	assert.True(t, saved.RequireMFA)
+
+	// An admin can reset the MFA of somebody who lost their codes.
+	reset, err := service.ResetMFA(ctx, admin.ID.Hex())
+	require.NoError(t, err)
+	assert.False(t, reset.MFA.Enabled)
+	_, challenge, err := service.AuthenticateUser(ctx, "admin", "password", "")
+	require.NoError(t, err)
+	assert.Nil(t, challenge)
+}
+
+// Test that a disabled account can neither log in nor refresh
+func TestSetUserDisabled(t *testing.T) {
+	ctx := context.Background()
+	service, _, admin, user := newAccountTestService(t)
+	pair, _, err := service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.NoError(t, err)
+
+	_, err = service.SetUserDisabled(ctx, admin.ID.Hex(), true)
+	assert.ErrorIs(t, err, Domain.ErrLastAdmin)
+
+	disabled, err := service.SetUserDisabled(ctx, user.ID.Hex(), true)
+	assert.NoError(t, err)
+	assert.True(t, disabled.Disabled)
+
+	// The right password gets the same answer as a wrong one
+	_, _, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+	_, _, err = service.AuthenticateUser(ctx, "user1", "wrong", "")
+	assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+	_, err = service.RefreshTokens(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, Domain.ErrInvalidToken)
+
+	_, err = service.SetUserDisabled(ctx, user.ID.Hex(), false)
+	assert.NoError(t, err)
+	_, _, err = service.AuthenticateUser(ctx, "user1", "password", "")
+	assert.NoError(t, err)
+}
+
+// Test each policy for the tasks of a deleted user
+func TestDeleteUserTaskPolicies(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		policy     Domain.TaskCascade
+		wantErr    error
+		adminTasks int
+	}{
+		{"Restrict", Domain.CascadeRestrict, Domain.ErrUserHasTasks, 0},
+		{"Delete", Domain.CascadeDelete, nil, 0},
+		{"Reassign", Domain.CascadeReassign, nil, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, tasks, admin, user := newAccountTestService(t)
+			for i := 0; i < 2; i++ {
+				_, err := tasks.CreateTask(ctx, Domain.Task{Title: "Task", UserID: user.ID})
+				assert.NoError(t, err)
+			}
+
+			err := service.DeleteUser(ctx, user.ID.Hex(), Domain.UserDeletion{Tasks: tt.policy, ReassignTo: admin.ID.Hex()})
+			assert.ErrorIs(t, err, tt.wantErr)
+
+			adminTasks, _ := tasks.GetTasks(ctx, Domain.TaskFilter{UserID: admin.ID.Hex()}.WithDefaults())
+			assert.Len(t, adminTasks.Tasks, tt.adminTasks)
+			_, err = service.GetUserByID(ctx, user.ID.Hex())
+			if tt.wantErr != nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, Domain.ErrUserNotFound)
+				userTasks, _ := tasks.GetTasks(ctx, Domain.TaskFilter{UserID: user.ID.Hex()}.WithDefaults())
+				assert.Empty(t, userTasks.Tasks)
+			}
+		})
+	}
+}
+
+// Test that the last admin cannot delete their own account
+func TestDeleteAccountLastAdmin(t *testing.T) {
+	ctx := context.Background()
+	service, _, admin, _ := newAccountTestService(t)
+	err := service.DeleteAccount(ctx, Domain.Principal{UserID: admin.ID, Role: Domain.RoleAdmin})
+	assert.ErrorIs(t, err, Domain.ErrLastAdmin)
+}
+
+// Test that usernames differing only in case name the same account
+func TestUsernamesIgnoreCase(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+
+	_, err := service.RegisterUser(ctx, Domain.User{Username: "USER1", Password: "password"})
+	assert.ErrorIs(t, err, Domain.ErrUsernameTaken)
+
+	carol, err := service.RegisterUser(ctx, Domain.User{Username: " Carol ", Password: "password"})
+	assert.NoError(t, err)
+	assert.Equal(t, "carol", carol.Username)
+
+	_, _, err = service.AuthenticateUser(ctx, "User1", "password", "")
+	assert.NoError(t, err)
+
+	_, err = service.UpdateProfile(ctx, Domain.Principal{UserID: user.ID}, "CAROL")
+	assert.ErrorIs(t, err, Domain.ErrUsernameTaken)
+}
+
+// Test that repeated failures lock out the username and then the client
+func TestAuthenticateUserLockout(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, _ := newAccountTestService(t)
+	service.UserLockout = Domain.LockoutPolicy{FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Minute, ResetAfter: time.Hour}
+	service.ClientLockout = Domain.LockoutPolicy{FreeFailures: 4, BaseDelay: time.Minute, MaxDelay: time.Minute, ResetAfter: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		_, _, err := service.AuthenticateUser(ctx, "user1", "wrong", "192.0.2.1")
+		assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+	}
+	// Locked even with the right password, and from another address
+	_, _, err := service.AuthenticateUser(ctx, "USER1", "password", "192.0.2.99")
+	var throttled *Domain.ThrottledError
+	if assert.ErrorAs(t, err, &throttled) {
+		assert.InDelta(t, time.Minute, throttled.RetryAfter, float64(time.Second))
+	}
+
+	// The client has two failures left before it is locked out for everyone
+	for _, username := range []string{"admin", "ghost"} {
+		_, _, err = service.AuthenticateUser(ctx, username, "wrong", "192.0.2.1")
+		assert.ErrorIs(t, err, Domain.ErrInvalidCredentials)
+	}
+	_, _, err = service.AuthenticateUser(ctx, "admin", "password", "192.0.2.1")
+	assert.ErrorIs(t, err, Domain.ErrTooManyAttempts)
+	_, _, err = service.AuthenticateUser(ctx, "admin", "password", "192.0.2.2")
+	assert.NoError(t, err)
+
+	// The successful login cleared admin's failures but not the client's
+	attempts, err := service.ListLoginAttempts(ctx)
+	assert.NoError(t, err)
+	keys := make([]string, len(attempts))
+	for i, a := range attempts {
+		keys[i] = a.Key
+	}
+	assert.Equal(t, []string{"ip:192.0.2.1", "user:ghost", "user:user1"}, keys)
+
+	assert.NoError(t, service.ClearLoginAttempts(ctx, Domain.UsernameAttemptKey("user1")))
+	_, _, err = service.AuthenticateUser(ctx, "user1", "password", "192.0.2.99")
+	assert.NoError(t, err)
+}
+
+// Test that registration and password changes enforce the password policy
+func TestPasswordPolicyEnforced(t *testing.T) {
+	ctx := context.Background()
+	service, _, _, user := newAccountTestService(t)
+	service.PasswordPolicy = Domain.PasswordPolicy{MinLength: 10, MinClasses: 2, Blocklist: Domain.PasswordBlocklist{"letmein2024": {}}}
+
+	_, err := service.RegisterUser(ctx, Domain.User{Username: "carol", Password: "carolcarol1"})
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+	_, err = service.RegisterUser(ctx, Domain.User{Username: "carol", Password: "LetMeIn2024"})
+	assert.ErrorIs(t, err, Domain.ErrValidation)
+	_, err = service.RegisterUser(ctx, Domain.User{Username: "carol", Password: "plum tree 42"})
+	assert.NoError(t, err)
+
+	principal := Domain.Principal{UserID: user.ID}
+	_, err = service.ChangePassword(ctx, principal, "password", "tooshort", "")
+	var invalid *Domain.ValidationError
+	if assert.ErrorAs(t, err, &invalid) {
+		assert.Equal(t, "new_password", invalid.Fields[0].Field)
+	}
+}
+
+// Test that logging in moves a password to the preferred algorithm
+func TestAuthenticateUserRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	users := Repositories.NewInMemoryUserRepository()
+	service := NewUserService(users, Repositories.NewInMemoryTaskRepository(), Repositories.NewInMemoryTokenRepository(),
+		Repositories.NewInMemoryLoginAttemptRepository(), Repositories.NewInMemoryRoleRepository(), Repositories.NewInMemoryAPIKeyRepository(), Infrastructure.NewTokenService(Infrastructure.NewHMACKeyRing("secret")))
+	service.Passwords = Infrastructure.NewPasswordService(Infrastructure.BcryptHasher{Cost: bcrypt.MinCost})
+	_, err := service.RegisterUser(ctx, Domain.User{Username: "alice", Password: "password"})
+	assert.NoError(t, err)
+
+	service.Passwords = Infrastructure.NewPasswordService(Infrastructure.DefaultArgon2idHasher)
+	_, _, err = service.AuthenticateUser(ctx, "alice", "password", "")
+	assert.NoError(t, err)
+
+	stored, err := users.GetUserByUsername(ctx, "alice")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"), stored.Password)
+	rehash, err := service.Passwords.Verify("password", stored.Password)
+	assert.NoError(t, err)
+	assert.False(t, rehash)
+
+	// Going back to bcrypt moves the user back on the next login.
+	service.Passwords = Infrastructure.NewPasswordService(Infrastructure.BcryptHasher{Cost: bcrypt.MinCost})
+	_, _, err = service.AuthenticateUser(ctx, "alice", "password", "")
+	assert.NoError(t, err)
+	stored, err = users.GetUserByUsername(ctx, "alice")
+	assert.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(stored.Password))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost, cost)
+}